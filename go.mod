@@ -3,9 +3,23 @@ module github.com/xperimental/bukky
 go 1.17
 
 require (
+	github.com/cespare/xxhash/v2 v2.1.1
 	github.com/google/go-cmp v0.5.6
 	github.com/gorilla/mux v1.8.0
+	github.com/prometheus/client_golang v1.11.1
 	github.com/sirupsen/logrus v1.8.1
+	github.com/zeebo/blake3 v0.2.1
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
 )
 
-require golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.26.0 // indirect
+	github.com/prometheus/procfs v0.6.0 // indirect
+	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+	google.golang.org/protobuf v1.26.0-rc.1 // indirect
+)
@@ -0,0 +1,116 @@
+package chunker
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// Config contains the size limits used for splitting content into chunks.
+type Config struct {
+	// MinSize is the minimum size of a chunk. Only the last chunk of a content can be smaller.
+	MinSize int
+	// AvgSize is the targeted average size of a chunk. It needs to be a power of two.
+	AvgSize int
+	// MaxSize is the maximum size of a chunk.
+	MaxSize int
+}
+
+// DefaultConfig results in chunks of 8 KiB on average.
+var DefaultConfig = Config{
+	MinSize: 2 << 10,
+	AvgSize: 8 << 10,
+	MaxSize: 64 << 10,
+}
+
+// gear contains pseudo-random values for every byte, generated using a fixed seed,
+// so that chunk boundaries are stable across restarts.
+var gear [256]uint64
+
+func init() {
+	seed := uint64(0x6275_6b6b_7900_0001)
+	for i := range gear {
+		// splitmix64
+		seed += 0x9e3779b97f4a7c15
+		z := seed
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// Validate checks if the configuration can be used for chunking.
+func (c Config) Validate() error {
+	switch {
+	case c.MinSize <= 0:
+		return errors.New("minimum size needs to be positive")
+	case c.AvgSize <= c.MinSize:
+		return errors.New("average size needs to be larger than minimum size")
+	case c.MaxSize <= c.AvgSize:
+		return errors.New("maximum size needs to be larger than average size")
+	case bits.OnesCount(uint(c.AvgSize)) != 1:
+		return errors.New("average size needs to be a power of two")
+	default:
+		return nil
+	}
+}
+
+// Split divides data into content-defined chunks using a gear-based rolling hash (similar to FastCDC).
+// Because boundaries only depend on the surrounding bytes, inserting or removing data only changes
+// the chunks close to the modification.
+func (c Config) Split(data []byte) [][]byte {
+	var chunks [][]byte
+	for len(data) > 0 {
+		n := c.next(data)
+		chunks = append(chunks, data[:n])
+		data = data[n:]
+	}
+
+	return chunks
+}
+
+// next returns the length of the next chunk at the start of data.
+func (c Config) next(data []byte) int {
+	if len(data) <= c.MinSize {
+		return len(data)
+	}
+
+	// Normalized chunking: a stricter mask before the average size and a looser one afterwards
+	// narrows the distribution of chunk sizes.
+	avgBits := bits.Len(uint(c.AvgSize)) - 1
+	maskStrict := mask(avgBits + 1)
+	maskLoose := mask(avgBits - 1)
+
+	end := len(data)
+	if end > c.MaxSize {
+		end = c.MaxSize
+	}
+
+	normal := c.AvgSize
+	if normal > end {
+		normal = end
+	}
+
+	var hash uint64
+	i := c.MinSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskStrict == 0 {
+			return i + 1
+		}
+	}
+
+	for ; i < end; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&maskLoose == 0 {
+			return i + 1
+		}
+	}
+
+	return end
+}
+
+// mask returns a mask with the highest n bits set. The high bits of the hash are influenced by more input bytes
+// than the low ones.
+func mask(n int) uint64 {
+	return ^uint64(0) << (64 - n)
+}
@@ -0,0 +1,132 @@
+package chunker
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestValidate(t *testing.T) {
+	tt := []struct {
+		desc    string
+		config  Config
+		wantErr bool
+	}{
+		{
+			desc:    "default",
+			config:  DefaultConfig,
+			wantErr: false,
+		},
+		{
+			desc:    "zero",
+			config:  Config{},
+			wantErr: true,
+		},
+		{
+			desc:    "average not power of two",
+			config:  Config{MinSize: 10, AvgSize: 100, MaxSize: 1000},
+			wantErr: true,
+		},
+		{
+			desc:    "maximum too small",
+			config:  Config{MinSize: 16, AvgSize: 64, MaxSize: 32},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.config.Validate()
+			if (err != nil) != tc.wantErr {
+				t.Errorf("got error %v, want error %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplit(t *testing.T) {
+	tt := []struct {
+		desc       string
+		data       []byte
+		wantChunks int
+	}{
+		{
+			desc:       "empty",
+			data:       nil,
+			wantChunks: 0,
+		},
+		{
+			desc:       "smaller than minimum",
+			data:       []byte("small content"),
+			wantChunks: 1,
+		},
+		{
+			desc:       "zeros",
+			data:       make([]byte, 1<<20),
+			wantChunks: 16,
+		},
+		{
+			desc:       "random",
+			data:       randomData(1, 1<<20),
+			wantChunks: -1,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			chunks := DefaultConfig.Split(tc.data)
+			if tc.wantChunks >= 0 && len(chunks) != tc.wantChunks {
+				t.Errorf("got %d chunks, want %d", len(chunks), tc.wantChunks)
+			}
+
+			for i, c := range chunks {
+				if len(c) > DefaultConfig.MaxSize {
+					t.Errorf("chunk %d larger than maximum: %d", i, len(c))
+				}
+
+				if len(c) < DefaultConfig.MinSize && i != len(chunks)-1 {
+					t.Errorf("chunk %d smaller than minimum: %d", i, len(c))
+				}
+			}
+
+			joined := bytes.Join(chunks, nil)
+			if !bytes.Equal(joined, tc.data) {
+				t.Error("joined chunks differ from data")
+			}
+		})
+	}
+}
+
+func TestSplitInsertion(t *testing.T) {
+	original := randomData(2, 1<<20)
+	modified := append(append(append([]byte{}, original[:1000]...), []byte("inserted data")...), original[1000:]...)
+
+	chunks := map[string]bool{}
+	for _, c := range DefaultConfig.Split(original) {
+		chunks[string(c)] = true
+	}
+
+	modifiedChunks := DefaultConfig.Split(modified)
+	changed := 0
+	for _, c := range modifiedChunks {
+		if !chunks[string(c)] {
+			changed++
+		}
+	}
+
+	if changed > 2 {
+		t.Errorf("got %d changed chunks out of %d, want at most 2", changed, len(modifiedChunks))
+	}
+}
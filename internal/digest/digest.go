@@ -3,23 +3,34 @@ package digest
 import (
 	"crypto/sha256"
 	"fmt"
+	"io"
+	"io/ioutil"
 )
 
 // A Digest is the hash of an object's contents.
 type Digest string
 
-// Digester returns the Digest of an object.
-type Digester func(content string) (Digest, error)
+// Digester returns the Digest of the content read from r. The content is read until EOF.
+type Digester func(r io.Reader) (Digest, error)
 
 // OneToOne is a digest implementation that maps the content onto itself.
 // Not for real-world usage, but no conflicts.
-func OneToOne(content string) (Digest, error) {
+func OneToOne(r io.Reader) (Digest, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
 	return Digest(content), nil
 }
 
 // SHA256 hashes the content with SHA-256 and returns the hash as a hex-string.
-func SHA256(content string) (Digest, error) {
-	sum := sha256.Sum256([]byte(content))
-	str := fmt.Sprintf("%x", sum)
+func SHA256(r io.Reader) (Digest, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
+	}
+
+	str := fmt.Sprintf("%x", hash.Sum(nil))
 	return Digest(str), nil
 }
@@ -0,0 +1,92 @@
+package digest
+
+import (
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/zeebo/blake3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// DefaultAlgorithm is used for buckets which do not select an algorithm.
+const DefaultAlgorithm = "sha256"
+
+var (
+	ErrUnknownAlgorithm = errors.New("unknown digest algorithm")
+	// ErrCollision is returned when different contents have the same digest.
+	ErrCollision = errors.New("digest collision")
+)
+
+// Algorithm is a hash function which can be selected by name.
+type Algorithm struct {
+	Name     string
+	Digester Digester
+	// Verify is set for hash functions which are not collision resistant. Contents with the same digest need to be
+	// compared before they are deduplicated.
+	Verify bool
+}
+
+var algorithms = map[string]Algorithm{
+	// SHA-256 digests are not prefixed, so that they stay compatible with contents saved by older versions.
+	DefaultAlgorithm: {Name: DefaultAlgorithm, Digester: SHA256},
+	"sha512-256":     {Name: "sha512-256", Digester: prefixed("sha512-256", sha512.New512_256)},
+	"blake2b-256":    {Name: "blake2b-256", Digester: prefixed("blake2b-256", newBLAKE2b)},
+	"blake3":         {Name: "blake3", Digester: prefixed("blake3", func() hash.Hash { return blake3.New() })},
+	"xxhash":         {Name: "xxhash", Digester: prefixed("xxhash", func() hash.Hash { return xxhash.New() }), Verify: true},
+}
+
+// Lookup returns the algorithm with the given name.
+func Lookup(name string) (Algorithm, error) {
+	a, ok := algorithms[name]
+	if !ok {
+		return Algorithm{}, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, name)
+	}
+
+	return a, nil
+}
+
+// Algorithms returns the sorted names of all algorithms.
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Algorithm returns the name of the algorithm which created the digest.
+// Digests without prefix have been created by the default algorithm.
+func (d Digest) Algorithm() string {
+	if i := strings.IndexByte(string(d), ':'); i > 0 {
+		return string(d[:i])
+	}
+
+	return DefaultAlgorithm
+}
+
+// prefixed returns a Digester which prefixes the hex-encoded hash with the name of the algorithm, so that digests
+// created by different algorithms can not be mistaken for each other.
+func prefixed(name string, newHash func() hash.Hash) Digester {
+	return func(r io.Reader) (Digest, error) {
+		h := newHash()
+		if _, err := io.Copy(h, r); err != nil {
+			return "", err
+		}
+
+		return Digest(fmt.Sprintf("%s:%x", name, h.Sum(nil))), nil
+	}
+}
+
+func newBLAKE2b() hash.Hash {
+	// New256 only fails for keys which are too long.
+	h, _ := blake2b.New256(nil)
+	return h
+}
@@ -0,0 +1,67 @@
+package digest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAlgorithms(t *testing.T) {
+	tt := []struct {
+		algorithm  string
+		wantDigest Digest
+	}{
+		{
+			algorithm:  "sha256",
+			wantDigest: "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad",
+		},
+		{
+			algorithm:  "sha512-256",
+			wantDigest: "sha512-256:53048e2681941ef99b2e29b76b4c7dabe4c2d0c634fc6d46e0e2f13107e7af23",
+		},
+		{
+			algorithm:  "blake2b-256",
+			wantDigest: "blake2b-256:bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319",
+		},
+		{
+			algorithm:  "blake3",
+			wantDigest: "blake3:6437b3ac38465133ffb63b75273a8db548c558465d79db03fd359c6cd5bd9d85",
+		},
+		{
+			algorithm:  "xxhash",
+			wantDigest: "xxhash:44bc2cf5ad770999",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.algorithm, func(t *testing.T) {
+			t.Parallel()
+
+			a, err := Lookup(tc.algorithm)
+			if err != nil {
+				t.Fatalf("can not look up algorithm: %s", err)
+			}
+
+			d, err := a.Digester(strings.NewReader("abc"))
+			if err != nil {
+				t.Fatalf("can not create digest: %s", err)
+			}
+
+			if d != tc.wantDigest {
+				t.Errorf("got digest %q, want %q", d, tc.wantDigest)
+			}
+
+			if got := d.Algorithm(); got != tc.algorithm {
+				t.Errorf("got algorithm %q, want %q", got, tc.algorithm)
+			}
+		})
+	}
+}
+
+func TestLookupUnknown(t *testing.T) {
+	_, err := Lookup("md5")
+	if !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Errorf("got error %q, want %q", err, ErrUnknownAlgorithm)
+	}
+}
@@ -0,0 +1,129 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+var (
+	// ErrBatchAborted is returned for the operations of an atomic batch which were not applied, because another
+	// operation of the batch failed.
+	ErrBatchAborted = errors.New("batch aborted")
+)
+
+// BatchOp is the type of an operation of a batch.
+type BatchOp string
+
+const (
+	// BatchPut saves the content of the operation as the object like Put.
+	BatchPut BatchOp = "put"
+	// BatchDelete deletes the object like Delete.
+	BatchDelete BatchOp = "delete"
+	// BatchGet reads the object like Get.
+	BatchGet BatchOp = "get"
+)
+
+// BatchOperation is a single operation on an object of a batch.
+type BatchOperation struct {
+	Op       BatchOp
+	ObjectID string
+	// Content is the content saved by puts. Batches are meant for small objects, so it is kept in memory.
+	Content []byte
+	// PutOptions are only used by puts.
+	PutOptions PutOptions
+	// DeleteOptions are only used by deletes.
+	DeleteOptions DeleteOptions
+	// GetOptions are only used by gets.
+	GetOptions GetOptions
+}
+
+// BatchResult is the result of a single operation of a batch.
+type BatchResult struct {
+	// Metadata is the metadata of the saved or read object.
+	Metadata Metadata
+	// Content is the content of the read object.
+	Content []byte
+	// Err contains the error of a failed operation.
+	Err error
+}
+
+// ValidateBatch checks that all operations of the batch have a known type and an object ID. Atomic batches can not
+// select versions, because deleting a version can change the current version of the object.
+func ValidateBatch(ops []BatchOperation, atomic bool) error {
+	for i, op := range ops {
+		switch op.Op {
+		case BatchPut, BatchDelete, BatchGet:
+		default:
+			return fmt.Errorf("unknown operation %q at index %d", op.Op, i)
+		}
+
+		if op.ObjectID == "" {
+			return fmt.Errorf("missing object ID at index %d", i)
+		}
+
+		if atomic && (op.DeleteOptions.VersionID != "" || op.GetOptions.VersionID != "") {
+			return fmt.Errorf("can not select version in atomic batch at index %d", i)
+		}
+	}
+
+	return nil
+}
+
+// CheckBatch checks the conditions of the operations of an atomic batch and that deleted and read objects exist,
+// without modifying anything. Each operation is checked against the objects as they are left by the previous
+// operations. current returns the metadata of an object before the batch or nil and digests contains the digests of
+// the contents of the puts by index. Returns the index and error of the first failing operation.
+func CheckBatch(ops []BatchOperation, digests []digest.Digest, current func(objectID string) *Metadata) (int, error) {
+	objects := make(map[string]*Metadata)
+	for i, op := range ops {
+		meta, ok := objects[op.ObjectID]
+		if !ok {
+			meta = current(op.ObjectID)
+		}
+
+		switch op.Op {
+		case BatchPut:
+			if err := op.PutOptions.Condition.Check(meta); err != nil {
+				return i, err
+			}
+
+			objects[op.ObjectID] = &Metadata{Digest: digests[i]}
+		case BatchDelete:
+			if err := op.DeleteOptions.Condition.Check(meta); err != nil {
+				return i, err
+			}
+			if meta == nil {
+				return i, ErrNotFound
+			}
+
+			objects[op.ObjectID] = nil
+		case BatchGet:
+			if meta == nil {
+				return i, ErrNotFound
+			}
+		default:
+			return i, fmt.Errorf("unknown operation %q", op.Op)
+		}
+	}
+
+	return -1, nil
+}
+
+// FailBatch sets the error as result of all operations of an atomic batch which failed as a whole.
+func FailBatch(results []BatchResult, err error) {
+	for i := range results {
+		results[i] = BatchResult{Err: err}
+	}
+}
+
+// AbortBatch marks all results of the batch without an error as aborted. Backends use it when an operation of an
+// atomic batch fails before any operation has been applied.
+func AbortBatch(results []BatchResult) {
+	for i := range results {
+		if results[i].Err == nil {
+			results[i] = BatchResult{Err: ErrBatchAborted}
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestCheckBatch(t *testing.T) {
+	current := func(objectID string) *Metadata {
+		if objectID != "existing-object" {
+			return nil
+		}
+
+		return &Metadata{Digest: "existing-digest"}
+	}
+
+	tt := []struct {
+		desc       string
+		ops        []BatchOperation
+		wantFailed int
+		wantErr    error
+	}{
+		{
+			desc: "success",
+			ops: []BatchOperation{
+				{Op: BatchGet, ObjectID: "existing-object"},
+				{Op: BatchPut, ObjectID: "existing-object", PutOptions: PutOptions{
+					Condition: Condition{IfMatch: []digest.Digest{"existing-digest"}},
+				}},
+				{Op: BatchDelete, ObjectID: "existing-object", DeleteOptions: DeleteOptions{
+					Condition: Condition{IfMatch: []digest.Digest{"test-digest"}},
+				}},
+				{Op: BatchPut, ObjectID: "new-object"},
+				{Op: BatchGet, ObjectID: "new-object"},
+			},
+			wantFailed: -1,
+		},
+		{
+			desc: "get missing object",
+			ops: []BatchOperation{
+				{Op: BatchGet, ObjectID: "existing-object"},
+				{Op: BatchGet, ObjectID: "missing-object"},
+			},
+			wantFailed: 1,
+			wantErr:    ErrNotFound,
+		},
+		{
+			desc: "delete twice",
+			ops: []BatchOperation{
+				{Op: BatchDelete, ObjectID: "existing-object"},
+				{Op: BatchDelete, ObjectID: "existing-object"},
+			},
+			wantFailed: 1,
+			wantErr:    ErrNotFound,
+		},
+		{
+			desc: "condition uses previous put",
+			ops: []BatchOperation{
+				{Op: BatchPut, ObjectID: "existing-object"},
+				{Op: BatchPut, ObjectID: "existing-object", PutOptions: PutOptions{
+					Condition: Condition{IfMatch: []digest.Digest{"existing-digest"}},
+				}},
+			},
+			wantFailed: 1,
+			wantErr:    ErrPreconditionFailed,
+		},
+		{
+			desc: "create only",
+			ops: []BatchOperation{
+				{Op: BatchPut, ObjectID: "existing-object", PutOptions: PutOptions{
+					Condition: Condition{IfNoneMatch: []digest.Digest{MatchAny}},
+				}},
+			},
+			wantFailed: 0,
+			wantErr:    ErrPreconditionFailed,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			digests := make([]digest.Digest, len(tc.ops))
+			for i := range digests {
+				digests[i] = "test-digest"
+			}
+
+			failed, err := CheckBatch(tc.ops, digests, current)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if failed != tc.wantFailed {
+				t.Errorf("got failed operation %d, want %d", failed, tc.wantFailed)
+			}
+		})
+	}
+}
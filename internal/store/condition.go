@@ -0,0 +1,49 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+// MatchAny matches every existing object when used in a Condition.
+const MatchAny digest.Digest = "*"
+
+var (
+	// ErrPreconditionFailed is returned when the condition of a modification does not match the current object.
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Condition makes a modification depend on the current digest of the object.
+// The condition is checked by the backend while holding the lock, so that concurrent modifications are detected.
+type Condition struct {
+	// IfMatch only allows the modification if the object exists and has one of the digests.
+	IfMatch []digest.Digest
+	// IfNoneMatch only allows the modification if the object has none of the digests.
+	// Use MatchAny to only allow creating objects which do not exist yet.
+	IfNoneMatch []digest.Digest
+}
+
+// Check returns ErrPreconditionFailed if the condition does not match the current metadata of the object.
+// current is nil if the object does not exist.
+func (c Condition) Check(current *Metadata) error {
+	if len(c.IfMatch) > 0 && (current == nil || !matchDigest(c.IfMatch, current.Digest)) {
+		return ErrPreconditionFailed
+	}
+
+	if len(c.IfNoneMatch) > 0 && current != nil && matchDigest(c.IfNoneMatch, current.Digest) {
+		return ErrPreconditionFailed
+	}
+
+	return nil
+}
+
+func matchDigest(digests []digest.Digest, d digest.Digest) bool {
+	for _, candidate := range digests {
+		if candidate == MatchAny || candidate == d {
+			return true
+		}
+	}
+
+	return false
+}
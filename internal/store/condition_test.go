@@ -0,0 +1,93 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+func TestConditionCheck(t *testing.T) {
+	current := &Metadata{
+		Digest: "test-digest",
+	}
+
+	tt := []struct {
+		desc      string
+		condition Condition
+		current   *Metadata
+		wantErr   error
+	}{
+		{
+			desc:    "no condition",
+			current: current,
+		},
+		{
+			desc:    "no condition without object",
+			current: nil,
+		},
+		{
+			desc: "if-match",
+			condition: Condition{
+				IfMatch: []digest.Digest{"other-digest", "test-digest"},
+			},
+			current: current,
+		},
+		{
+			desc: "if-match different digest",
+			condition: Condition{
+				IfMatch: []digest.Digest{"other-digest"},
+			},
+			current: current,
+			wantErr: ErrPreconditionFailed,
+		},
+		{
+			desc: "if-match any",
+			condition: Condition{
+				IfMatch: []digest.Digest{MatchAny},
+			},
+			current: current,
+		},
+		{
+			desc: "if-match without object",
+			condition: Condition{
+				IfMatch: []digest.Digest{MatchAny},
+			},
+			current: nil,
+			wantErr: ErrPreconditionFailed,
+		},
+		{
+			desc: "if-none-match any",
+			condition: Condition{
+				IfNoneMatch: []digest.Digest{MatchAny},
+			},
+			current: current,
+			wantErr: ErrPreconditionFailed,
+		},
+		{
+			desc: "if-none-match any without object",
+			condition: Condition{
+				IfNoneMatch: []digest.Digest{MatchAny},
+			},
+			current: nil,
+		},
+		{
+			desc: "if-none-match different digest",
+			condition: Condition{
+				IfNoneMatch: []digest.Digest{"other-digest"},
+			},
+			current: current,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			err := tc.condition.Check(tc.current)
+			if err != tc.wantErr {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+// batchContent is the content of a put of a batch, which is written to a temporary file before the lock is acquired.
+type batchContent struct {
+	tmpPath string
+	digest  digest.Digest
+}
+
+func (s *Store) Batch(bucketName string, ops []store.BatchOperation, atomic bool) ([]store.BatchResult, error) {
+	if err := store.ValidateBatch(ops, atomic); err != nil {
+		return nil, err
+	}
+
+	s.bucketMutex.RLock()
+	digester, verify := s.bucketDigester(s.buckets[bucketName])
+	s.bucketMutex.RUnlock()
+
+	results := make([]store.BatchResult, len(ops))
+	contents := make([]batchContent, len(ops))
+	for i, op := range ops {
+		if op.Op != store.BatchPut {
+			continue
+		}
+
+		contents[i], results[i].Err = s.writeBatchContent(digester, op.Content)
+		if results[i].Err == nil {
+			defer os.Remove(contents[i].tmpPath)
+		}
+	}
+
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	if atomic {
+		if !s.checkBatch(bucketName, ops, contents, results) {
+			store.AbortBatch(results)
+			return results, nil
+		}
+
+		s.applyAtomicBatch(bucketName, ops, contents, verify, results)
+		return results, nil
+	}
+
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+
+		results[i] = s.applyBatchOperation(bucketName, op, contents[i], verify)
+	}
+
+	return results, nil
+}
+
+func (s *Store) writeBatchContent(digester digest.Digester, data []byte) (batchContent, error) {
+	tmp, err := ioutil.TempFile(s.dir, uploadPrefix)
+	if err != nil {
+		return batchContent{}, fmt.Errorf("can not create temporary file: %w", err)
+	}
+	defer tmp.Close()
+
+	contentDigest, err := digester(io.TeeReader(bytes.NewReader(data), tmp))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return batchContent{}, fmt.Errorf("can not create digest: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		os.Remove(tmp.Name())
+		return batchContent{}, fmt.Errorf("can not write content: %w", err)
+	}
+
+	return batchContent{
+		tmpPath: tmp.Name(),
+		digest:  contentDigest,
+	}, nil
+}
+
+// checkBatch checks that all operations of an atomic batch can be applied. Returns false if the batch needs to be
+// aborted, with the error set in the result of the failing operation. It needs to be called with the lock held.
+func (s *Store) checkBatch(bucketName string, ops []store.BatchOperation, contents []batchContent, results []store.BatchResult) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return false
+		}
+	}
+
+	digests := make([]digest.Digest, len(ops))
+	for i, c := range contents {
+		digests[i] = c.digest
+	}
+
+	if i, err := store.CheckBatch(ops, digests, func(objectID string) *store.Metadata {
+		return s.current(bucketName, objectID)
+	}); err != nil {
+		results[i].Err = err
+		return false
+	}
+
+	return true
+}
+
+// applyBatchOperation applies a single operation of a batch. It needs to be called with the lock held.
+func (s *Store) applyBatchOperation(bucketName string, op store.BatchOperation, c batchContent, verify bool) store.BatchResult {
+	switch op.Op {
+	case store.BatchPut:
+		meta, err := s.saveLocked(bucketName, op.ObjectID, c.tmpPath, c.digest, verify, op.PutOptions.Condition, func(replaced *store.Metadata) store.Metadata {
+			return store.NewMetadata(op.PutOptions, int64(len(op.Content)), c.digest, s.now(), replaced)
+		})
+		return store.BatchResult{Metadata: meta, Err: err}
+	case store.BatchDelete:
+		return store.BatchResult{Err: s.deleteWithOptions(bucketName, op.ObjectID, op.DeleteOptions)}
+	default:
+		meta := s.current(bucketName, op.ObjectID)
+		if meta == nil || op.GetOptions.VersionID != "" {
+			return store.BatchResult{Err: store.ErrNotFound}
+		}
+
+		content, err := ioutil.ReadFile(contentPath(s.buckets[bucketName].dir, meta.Digest))
+		if err != nil {
+			return store.BatchResult{Err: fmt.Errorf("can not read content with digest %q: %w", meta.Digest, err)}
+		}
+
+		return store.BatchResult{Metadata: *meta, Content: content}
+	}
+}
+
+// batchChange records the state of an object before it was changed by an atomic batch, so that the change can be
+// rolled back.
+type batchChange struct {
+	objectID string
+	previous *store.Metadata
+}
+
+// applyAtomicBatch applies all operations of a checked atomic batch to the bucket and writes its index once. A missing
+// bucket is only created if the batch contains a put. If any operation or writing the index fails, all changes are
+// rolled back and the batch is aborted. It needs to be called with the lock held.
+func (s *Store) applyAtomicBatch(bucketName string, ops []store.BatchOperation, contents []batchContent, verify bool, results []store.BatchResult) {
+	b, exists := s.buckets[bucketName]
+	if !exists {
+		// Like outside of batches, only puts create a missing bucket.
+		if !hasPut(ops) {
+			store.FailBatch(results, store.ErrNotFound)
+			return
+		}
+
+		var err error
+		b, err = s.createBucket(bucketName)
+		if err != nil {
+			store.FailBatch(results, err)
+			return
+		}
+	}
+
+	var changes []batchChange
+	var moved []string
+	rollback := func() {
+		for i := len(changes) - 1; i >= 0; i-- {
+			if c := changes[i]; c.previous != nil {
+				b.setObject(c.objectID, *c.previous)
+			} else {
+				b.unsetObject(c.objectID)
+			}
+		}
+
+		for _, path := range moved {
+			if err := os.Remove(path); err != nil {
+				s.log.Errorf("Error removing content %q: %s", path, err)
+			}
+		}
+
+		if !exists {
+			if err := os.RemoveAll(b.dir); err != nil {
+				s.log.Errorf("Error removing bucket directory %q: %s", b.dir, err)
+			}
+		}
+	}
+
+	for i, op := range ops {
+		previous := b.current(op.ObjectID)
+		switch op.Op {
+		case store.BatchPut:
+			c := contents[i]
+			path := contentPath(b.dir, c.digest)
+			wasMoved, err := placeContent(path, c.tmpPath, c.digest, verify)
+			if wasMoved {
+				moved = append(moved, path)
+			}
+			if err != nil {
+				rollback()
+				results[i].Err = err
+				store.AbortBatch(results)
+				return
+			}
+
+			meta := store.NewMetadata(op.PutOptions, int64(len(op.Content)), c.digest, s.now(), previous)
+			b.setObject(op.ObjectID, meta)
+			changes = append(changes, batchChange{objectID: op.ObjectID, previous: previous})
+			results[i] = store.BatchResult{Metadata: meta}
+		case store.BatchDelete:
+			b.unsetObject(op.ObjectID)
+			changes = append(changes, batchChange{objectID: op.ObjectID, previous: previous})
+		default:
+			content, err := ioutil.ReadFile(contentPath(b.dir, previous.Digest))
+			if err != nil {
+				rollback()
+				results[i].Err = fmt.Errorf("can not read content with digest %q: %w", previous.Digest, err)
+				store.AbortBatch(results)
+				return
+			}
+
+			results[i] = store.BatchResult{Metadata: *previous, Content: content}
+		}
+	}
+
+	if err := writeIndex(bucketName, b); err != nil {
+		rollback()
+		store.FailBatch(results, err)
+		return
+	}
+	s.buckets[bucketName] = b
+
+	// Contents are only released after the index has been written, as a rollback still needs them.
+	released := make(map[digest.Digest]bool)
+	for _, c := range changes {
+		if c.previous != nil && !released[c.previous.Digest] {
+			released[c.previous.Digest] = true
+			s.releaseContent(b, c.previous.Digest)
+		}
+	}
+}
+
+func hasPut(ops []store.BatchOperation) bool {
+	for _, op := range ops {
+		if op.Op == store.BatchPut {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,292 @@
+package disk
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestBatch(t *testing.T) {
+	tt := []struct {
+		desc         string
+		ops          []store.BatchOperation
+		atomic       bool
+		wantErrs     []error
+		wantContents []string
+		wantObjects  map[string]string
+		wantFiles    int
+	}{
+		{
+			desc: "mixed operations",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchGet, ObjectID: "existing-object"},
+				{Op: store.BatchDelete, ObjectID: "existing-object"},
+				{Op: store.BatchGet, ObjectID: "existing-object"},
+			},
+			wantErrs:     []error{nil, nil, nil, store.ErrNotFound},
+			wantContents: []string{"", "existing-content", "", ""},
+			wantObjects:  map[string]string{"new-object": "new-content", "existing-object": ""},
+			wantFiles:    1,
+		},
+		{
+			desc: "same content twice",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchPut, ObjectID: "other-object", Content: []byte("new-content")},
+			},
+			wantErrs:    []error{nil, nil},
+			wantObjects: map[string]string{"new-object": "new-content", "other-object": "new-content"},
+			wantFiles:   2,
+		},
+		{
+			desc: "atomic",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchDelete, ObjectID: "existing-object"},
+			},
+			atomic:      true,
+			wantErrs:    []error{nil, nil},
+			wantObjects: map[string]string{"new-object": "new-content", "existing-object": ""},
+			wantFiles:   1,
+		},
+		{
+			desc: "atomic object not found",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchGet, ObjectID: "missing-object"},
+			},
+			atomic:      true,
+			wantErrs:    []error{store.ErrBatchAborted, store.ErrNotFound},
+			wantObjects: map[string]string{"new-object": "", "existing-object": "existing-content"},
+			wantFiles:   1,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s := newTestStore(t, dir, []putOp{
+				{"test-bucket", "existing-object", "existing-content"},
+			})
+
+			results, err := s.Batch("test-bucket", tc.ops, tc.atomic)
+			if err != nil {
+				t.Fatalf("can not apply batch: %s", err)
+			}
+
+			if len(results) != len(tc.wantErrs) {
+				t.Fatalf("got %d results, want %d", len(results), len(tc.wantErrs))
+			}
+
+			for i, r := range results {
+				if !testutil.EqualErrorMessage(r.Err, tc.wantErrs[i]) {
+					t.Errorf("got error %q for operation %d, want %q", r.Err, i, tc.wantErrs[i])
+				}
+
+				if tc.wantContents != nil && string(r.Content) != tc.wantContents[i] {
+					t.Errorf("got content %q for operation %d, want %q", r.Content, i, tc.wantContents[i])
+				}
+			}
+
+			// The results of the batch need to be restored from the index after a restart.
+			restarted := newTestStore(t, dir, nil)
+			for objectID, want := range tc.wantObjects {
+				reader, _, err := restarted.Get("test-bucket", objectID, store.GetOptions{})
+				if want == "" {
+					if err != store.ErrNotFound {
+						t.Errorf("got error %q for %s, want %q", err, objectID, store.ErrNotFound)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("can not get %s: %s", objectID, err)
+				}
+
+				if got := testutil.ReadAll(t, reader); got != want {
+					t.Errorf("got content %q for %s, want %q", got, objectID, want)
+				}
+			}
+
+			if got := countContentFiles(t, restarted, "test-bucket"); got != tc.wantFiles {
+				t.Errorf("got %d content files, want %d", got, tc.wantFiles)
+			}
+		})
+	}
+}
+
+func TestBatchMissingBucket(t *testing.T) {
+	tt := []struct {
+		desc       string
+		ops        []store.BatchOperation
+		atomic     bool
+		wantErrs   []error
+		wantBucket bool
+	}{
+		{
+			desc:     "empty",
+			atomic:   true,
+			wantErrs: []error{},
+		},
+		{
+			desc: "delete",
+			ops: []store.BatchOperation{
+				{Op: store.BatchDelete, ObjectID: "missing-object"},
+			},
+			wantErrs: []error{store.ErrNotFound},
+		},
+		{
+			desc: "atomic delete",
+			ops: []store.BatchOperation{
+				{Op: store.BatchDelete, ObjectID: "missing-object"},
+			},
+			atomic:   true,
+			wantErrs: []error{store.ErrNotFound},
+		},
+		{
+			desc: "atomic put and get",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchGet, ObjectID: "missing-object"},
+			},
+			atomic:   true,
+			wantErrs: []error{store.ErrBatchAborted, store.ErrNotFound},
+		},
+		{
+			desc: "atomic put",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+			},
+			atomic:     true,
+			wantErrs:   []error{nil},
+			wantBucket: true,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s := newTestStore(t, dir, nil)
+
+			results, err := s.Batch("test-bucket", tc.ops, tc.atomic)
+			if err != nil {
+				t.Fatalf("can not apply batch: %s", err)
+			}
+
+			if len(results) != len(tc.wantErrs) {
+				t.Fatalf("got %d results, want %d", len(results), len(tc.wantErrs))
+			}
+
+			for i, r := range results {
+				if !testutil.EqualErrorMessage(r.Err, tc.wantErrs[i]) {
+					t.Errorf("got error %q for operation %d, want %q", r.Err, i, tc.wantErrs[i])
+				}
+			}
+
+			restarted := newTestStore(t, dir, nil)
+			for _, s := range []*Store{s, restarted} {
+				if got := s.BucketExists("test-bucket"); got != tc.wantBucket {
+					t.Errorf("got bucket exists %v, want %v", got, tc.wantBucket)
+				}
+			}
+		})
+	}
+}
+
+func TestAtomicBatchRollback(t *testing.T) {
+	tt := []struct {
+		desc string
+		// fail prepares the bucket, so that the batch fails after the put has been applied.
+		fail      func(t *testing.T, b *bucket)
+		ops       []store.BatchOperation
+		wantFiles int
+	}{
+		{
+			desc: "index not writable",
+			fail: func(t *testing.T, b *bucket) {
+				// Writing the index fails, because it can not replace a directory which is not empty.
+				indexPath := filepath.Join(b.dir, indexFile)
+				if err := os.Remove(indexPath); err != nil {
+					t.Fatalf("can not remove index: %s", err)
+				}
+				if err := os.MkdirAll(filepath.Join(indexPath, "blocked"), 0o755); err != nil {
+					t.Fatalf("can not block index: %s", err)
+				}
+			},
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchDelete, ObjectID: "other-object"},
+			},
+			wantFiles: 2,
+		},
+		{
+			desc: "content not readable",
+			fail: func(t *testing.T, b *bucket) {
+				if err := os.Remove(contentPath(b.dir, b.objects["other-object"])); err != nil {
+					t.Fatalf("can not remove content: %s", err)
+				}
+			},
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchDelete, ObjectID: "existing-object"},
+				{Op: store.BatchGet, ObjectID: "other-object"},
+			},
+			wantFiles: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestStore(t, t.TempDir(), []putOp{
+				{"test-bucket", "existing-object", "existing-content"},
+				{"test-bucket", "other-object", "other-content"},
+			})
+			tc.fail(t, s.buckets["test-bucket"])
+
+			results, err := s.Batch("test-bucket", tc.ops, true)
+			if err != nil {
+				t.Fatalf("can not apply batch: %s", err)
+			}
+
+			for i, r := range results {
+				if r.Err == nil {
+					t.Errorf("got no error for operation %d", i)
+				}
+			}
+
+			if _, err := s.Head("test-bucket", "new-object", store.GetOptions{}); err != store.ErrNotFound {
+				t.Errorf("got error %q for new-object, want %q", err, store.ErrNotFound)
+			}
+
+			reader, _, err := s.Get("test-bucket", "existing-object", store.GetOptions{})
+			if err != nil {
+				t.Fatalf("can not get existing-object: %s", err)
+			}
+
+			if got := testutil.ReadAll(t, reader); got != "existing-content" {
+				t.Errorf("got content %q, want %q", got, "existing-content")
+			}
+
+			if _, err := s.Head("test-bucket", "other-object", store.GetOptions{}); err != nil {
+				t.Errorf("can not head other-object: %s", err)
+			}
+
+			if got := countContentFiles(t, s, "test-bucket"); got != tc.wantFiles {
+				t.Errorf("got %d content files, want %d", got, tc.wantFiles)
+			}
+		})
+	}
+}
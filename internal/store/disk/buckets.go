@@ -0,0 +1,146 @@
+package disk
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (s *Store) CreateBucket(bucketName string, opts store.BucketOptions) error {
+	algorithm, err := bucketAlgorithm(opts.Digest)
+	if err != nil {
+		return err
+	}
+
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	if _, ok := s.buckets[bucketName]; ok {
+		return store.ErrBucketExists
+	}
+
+	b, err := s.createBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	b.algorithm = algorithm
+
+	if err := writeIndex(bucketName, b); err != nil {
+		return err
+	}
+	s.buckets[bucketName] = b
+
+	return nil
+}
+
+// createBucket creates the directories of a new bucket. The bucket is not added to the store.
+func (s *Store) createBucket(bucketName string) (*bucket, error) {
+	b := newBucket(filepath.Join(s.dir, hex.EncodeToString([]byte(bucketName))))
+
+	if err := os.MkdirAll(filepath.Join(b.dir, contentsDir), 0o755); err != nil {
+		return nil, fmt.Errorf("can not create bucket directory: %w", err)
+	}
+
+	return b, nil
+}
+
+func (s *Store) ListBuckets() ([]string, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (s *Store) DeleteBucket(bucketName string, force bool) error {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	if len(b.objects) > 0 && !force {
+		return store.ErrBucketNotEmpty
+	}
+
+	// Removing the index first makes sure the bucket is not loaded again, even if removing the contents fails.
+	if err := os.Remove(filepath.Join(b.dir, indexFile)); err != nil {
+		return fmt.Errorf("can not remove index: %w", err)
+	}
+	delete(s.buckets, bucketName)
+	s.removeUploads(bucketName)
+
+	if err := os.RemoveAll(b.dir); err != nil {
+		s.log.Errorf("Error removing bucket directory %s: %s", b.dir, err)
+	}
+
+	return nil
+}
+
+func (s *Store) BucketExists(bucketName string) bool {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	_, ok := s.buckets[bucketName]
+	return ok
+}
+
+// SetVersioning is not supported, because the disk store only keeps the current version of each object.
+func (s *Store) SetVersioning(bucketName string, enabled bool) error {
+	if !s.BucketExists(bucketName) {
+		return store.ErrNotFound
+	}
+
+	return store.ErrNotSupported
+}
+
+func (s *Store) Versioning(bucketName string) (bool, error) {
+	if !s.BucketExists(bucketName) {
+		return false, store.ErrNotFound
+	}
+
+	return false, nil
+}
+
+// ListVersions returns the current version of each object, which is the only version kept.
+func (s *Store) ListVersions(bucketName, prefix string) ([]store.Version, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	var versions []store.Version
+	for objectID, meta := range b.metadata {
+		if !strings.HasPrefix(objectID, prefix) {
+			continue
+		}
+
+		versions = append(versions, store.Version{
+			ObjectID: objectID,
+			IsLatest: true,
+			Modified: meta.Modified,
+			Size:     meta.Size,
+			Digest:   meta.Digest,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ObjectID < versions[j].ObjectID
+	})
+
+	return versions, nil
+}
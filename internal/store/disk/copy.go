@@ -0,0 +1,146 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (s *Store) Copy(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	if opts.VersionID != "" {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	if srcBucket == dstBucket {
+		s.bucketMutex.Lock()
+		defer s.bucketMutex.Unlock()
+
+		return s.copyWithin(srcBucket, srcObjectID, dstObjectID, opts.Condition)
+	}
+
+	meta, _, err := s.copyAcross(srcBucket, srcObjectID, dstBucket, dstObjectID, opts.Condition)
+	return meta, err
+}
+
+func (s *Store) Move(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	if opts.VersionID != "" {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	if srcBucket == dstBucket {
+		s.bucketMutex.Lock()
+		defer s.bucketMutex.Unlock()
+
+		meta, err := s.copyWithin(srcBucket, srcObjectID, dstObjectID, opts.Condition)
+		if err != nil || srcObjectID == dstObjectID {
+			return meta, err
+		}
+
+		if err := s.deleteObject(srcBucket, srcObjectID, *s.current(srcBucket, srcObjectID)); err != nil {
+			return store.Metadata{}, err
+		}
+
+		return meta, nil
+	}
+
+	meta, source, err := s.copyAcross(srcBucket, srcObjectID, dstBucket, dstObjectID, opts.Condition)
+	if err != nil {
+		return store.Metadata{}, err
+	}
+
+	// The source is only deleted if it has not been replaced or deleted while it was copied.
+	err = s.Delete(srcBucket, srcObjectID, store.DeleteOptions{
+		Condition: store.Condition{IfMatch: []digest.Digest{source.Digest}},
+	})
+	switch {
+	case err == store.ErrPreconditionFailed:
+		s.log.Warnf("Object %s/%s changed while it was moved, keeping it.", srcBucket, srcObjectID)
+	case err != nil:
+		return store.Metadata{}, err
+	default:
+	}
+
+	return meta, nil
+}
+
+// copyWithin copies the object within the bucket by only saving the metadata of the copy. The content is shared.
+// It needs to be called with the lock held.
+func (s *Store) copyWithin(bucketName, srcObjectID, dstObjectID string, condition store.Condition) (store.Metadata, error) {
+	source := s.current(bucketName, srcObjectID)
+	if source == nil {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	replaced := s.current(bucketName, dstObjectID)
+	if err := condition.Check(replaced); err != nil {
+		return store.Metadata{}, err
+	}
+
+	b := s.buckets[bucketName]
+	meta := store.CopyMetadata(*source, s.now(), replaced)
+	if err := s.replaceObject(bucketName, b, dstObjectID, meta, replaced); err != nil {
+		return store.Metadata{}, err
+	}
+
+	return meta, nil
+}
+
+// copyAcross copies the content file of the object into the other bucket and returns the metadata of the copy and of
+// the source. The digest is only computed again if the buckets use different digest algorithms.
+func (s *Store) copyAcross(srcBucket, srcObjectID, dstBucket, dstObjectID string, condition store.Condition) (store.Metadata, store.Metadata, error) {
+	s.bucketMutex.RLock()
+	source := s.current(srcBucket, srcObjectID)
+	if source == nil {
+		s.bucketMutex.RUnlock()
+		return store.Metadata{}, store.Metadata{}, store.ErrNotFound
+	}
+
+	src := s.buckets[srcBucket]
+	dst := s.buckets[dstBucket]
+	digester, verify := s.bucketDigester(dst)
+	sameAlgorithm := algorithmName(src) == algorithmName(dst)
+
+	// An open file stays readable even if the content is removed by a concurrent delete.
+	file, err := os.Open(contentPath(src.dir, source.Digest))
+	s.bucketMutex.RUnlock()
+	if err != nil {
+		return store.Metadata{}, store.Metadata{}, fmt.Errorf("can not open content with digest %q: %w", source.Digest, err)
+	}
+	defer file.Close()
+
+	tmp, err := ioutil.TempFile(s.dir, uploadPrefix)
+	if err != nil {
+		return store.Metadata{}, store.Metadata{}, fmt.Errorf("can not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	contentDigest := source.Digest
+	if sameAlgorithm {
+		_, err = io.Copy(tmp, file)
+	} else {
+		contentDigest, err = digester(io.TeeReader(file, tmp))
+	}
+	if err != nil {
+		return store.Metadata{}, store.Metadata{}, fmt.Errorf("can not copy content: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return store.Metadata{}, store.Metadata{}, fmt.Errorf("can not write content: %w", err)
+	}
+
+	meta, err := s.save(dstBucket, dstObjectID, tmp.Name(), contentDigest, verify, condition, func(replaced *store.Metadata) store.Metadata {
+		meta := store.CopyMetadata(*source, s.now(), replaced)
+		meta.Digest = contentDigest
+		return meta
+	})
+	if err != nil {
+		return store.Metadata{}, store.Metadata{}, err
+	}
+
+	return meta, *source, nil
+}
@@ -0,0 +1,171 @@
+package disk
+
+import (
+	"testing"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestCopy(t *testing.T) {
+	tt := []struct {
+		desc          string
+		move          bool
+		dstBucket     string
+		dstObjectID   string
+		srcObjectID   string
+		opts          store.CopyOptions
+		wantErr       error
+		wantAlgorithm string
+		wantSource    bool
+		wantFiles     map[string]int
+	}{
+		{
+			desc:          "within bucket",
+			dstBucket:     "test-bucket",
+			dstObjectID:   "copied-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantFiles:     map[string]int{"test-bucket": 2},
+		},
+		{
+			desc:          "other bucket",
+			dstBucket:     "other-bucket",
+			dstObjectID:   "copied-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantFiles:     map[string]int{"test-bucket": 2, "other-bucket": 1},
+		},
+		{
+			desc:          "bucket with other algorithm",
+			dstBucket:     "blake-bucket",
+			dstObjectID:   "copied-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: "blake3",
+			wantSource:    true,
+			wantFiles:     map[string]int{"test-bucket": 2, "blake-bucket": 1},
+		},
+		{
+			desc:        "source not found",
+			dstBucket:   "test-bucket",
+			dstObjectID: "copied-object",
+			srcObjectID: "missing-object",
+			wantErr:     store.ErrNotFound,
+		},
+		{
+			desc:        "version not supported",
+			dstBucket:   "test-bucket",
+			dstObjectID: "copied-object",
+			srcObjectID: "test-object",
+			opts: store.CopyOptions{
+				VersionID: "test-version",
+			},
+			wantErr: store.ErrNotFound,
+		},
+		{
+			desc:        "precondition failed",
+			dstBucket:   "test-bucket",
+			dstObjectID: "other-object",
+			srcObjectID: "test-object",
+			opts: store.CopyOptions{
+				Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+		{
+			desc:          "overwrite",
+			dstBucket:     "test-bucket",
+			dstObjectID:   "other-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantFiles:     map[string]int{"test-bucket": 1},
+		},
+		{
+			desc:          "move within bucket",
+			move:          true,
+			dstBucket:     "test-bucket",
+			dstObjectID:   "moved-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantFiles:     map[string]int{"test-bucket": 2},
+		},
+		{
+			desc:          "move to other bucket",
+			move:          true,
+			dstBucket:     "other-bucket",
+			dstObjectID:   "moved-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantFiles:     map[string]int{"test-bucket": 1, "other-bucket": 1},
+		},
+		{
+			desc:          "move onto itself",
+			move:          true,
+			dstBucket:     "test-bucket",
+			dstObjectID:   "test-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantFiles:     map[string]int{"test-bucket": 2},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s := newTestStore(t, dir, []putOp{
+				{"test-bucket", "test-object", "test-content"},
+				{"test-bucket", "other-object", "other-content"},
+			})
+			if err := s.CreateBucket("blake-bucket", store.BucketOptions{Digest: "blake3"}); err != nil {
+				t.Fatalf("can not create bucket: %s", err)
+			}
+
+			copyObject := s.Copy
+			if tc.move {
+				copyObject = s.Move
+			}
+
+			meta, err := copyObject("test-bucket", tc.srcObjectID, tc.dstBucket, tc.dstObjectID, tc.opts)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if got := meta.Digest.Algorithm(); got != tc.wantAlgorithm {
+				t.Errorf("got algorithm %q, want %q", got, tc.wantAlgorithm)
+			}
+
+			// The copy needs to be restored from the index after a restart.
+			restarted := newTestStore(t, dir, nil)
+			reader, _, err := restarted.Get(tc.dstBucket, tc.dstObjectID, store.GetOptions{})
+			if err != nil {
+				t.Fatalf("can not get copy: %s", err)
+			}
+
+			if content := testutil.ReadAll(t, reader); content != "test-content" {
+				t.Errorf("got content %q, want %q", content, "test-content")
+			}
+
+			if _, err := restarted.Head("test-bucket", tc.srcObjectID, store.GetOptions{}); (err == nil) != tc.wantSource {
+				t.Errorf("got error %q for source, want source to exist: %v", err, tc.wantSource)
+			}
+
+			for bucket, want := range tc.wantFiles {
+				if got := countContentFiles(t, restarted, bucket); got != want {
+					t.Errorf("got %d content files in %s, want %d", got, bucket, want)
+				}
+			}
+		})
+	}
+}
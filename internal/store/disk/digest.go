@@ -0,0 +1,95 @@
+package disk
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+// bucketAlgorithm looks up the algorithm selected for a bucket. An empty name selects the default algorithm,
+// which is returned as nil.
+func bucketAlgorithm(name string) (*digest.Algorithm, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	algorithm, err := digest.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &algorithm, nil
+}
+
+// bucketDigester returns the digester used for the contents of the bucket and whether identical digests need to be
+// verified by comparing the contents. Buckets which do not exist yet use the default algorithm.
+func (s *Store) bucketDigester(b *bucket) (digest.Digester, bool) {
+	if b == nil || b.algorithm == nil {
+		return s.digester, s.verify
+	}
+
+	return b.algorithm.Digester, b.algorithm.Verify
+}
+
+// algorithmName returns the name of the algorithm selected for the bucket or an empty string for the default algorithm.
+func algorithmName(b *bucket) string {
+	if b == nil || b.algorithm == nil {
+		return ""
+	}
+
+	return b.algorithm.Name
+}
+
+// verifyContent compares the existing content file with the new content having the same digest. Content files are
+// named by their digest, so colliding contents can not be kept separately and are rejected with digest.ErrCollision.
+func verifyContent(existingPath, newPath string, d digest.Digest) error {
+	existing, err := os.Open(existingPath)
+	if err != nil {
+		return fmt.Errorf("can not verify content: %w", err)
+	}
+	defer existing.Close()
+
+	content, err := os.Open(newPath)
+	if err != nil {
+		return fmt.Errorf("can not verify content: %w", err)
+	}
+	defer content.Close()
+
+	equal, err := equalReaders(existing, content)
+	if err != nil {
+		return fmt.Errorf("can not verify content: %w", err)
+	}
+
+	if !equal {
+		return fmt.Errorf("%w: %s", digest.ErrCollision, d)
+	}
+
+	return nil
+}
+
+func equalReaders(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, 32*1024)
+	bufB := make([]byte, len(bufA))
+	for {
+		n, errA := io.ReadFull(a, bufA)
+		m, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:n], bufB[:m]) {
+			return false, nil
+		}
+
+		doneA := errA == io.EOF || errA == io.ErrUnexpectedEOF
+		doneB := errB == io.EOF || errB == io.ErrUnexpectedEOF
+		switch {
+		case errA != nil && !doneA:
+			return false, errA
+		case errB != nil && !doneB:
+			return false, errB
+		case doneA || doneB:
+			return doneA && doneB, nil
+		default:
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package disk
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestBucketDigest(t *testing.T) {
+	dir := t.TempDir()
+	before := newTestStore(t, dir, nil)
+	if err := before.CreateBucket("blake-bucket", store.BucketOptions{Digest: "blake3"}); err != nil {
+		t.Fatalf("can not create bucket: %s", err)
+	}
+
+	if err := before.CreateBucket("test-bucket", store.BucketOptions{Digest: "md5"}); !errors.Is(err, digest.ErrUnknownAlgorithm) {
+		t.Errorf("got error %q, want %q", err, digest.ErrUnknownAlgorithm)
+	}
+
+	after := newTestStore(t, dir, []putOp{
+		{"blake-bucket", "test-object", "test-content"},
+		{"default-bucket", "test-object", "test-content"},
+	})
+
+	for bucket, want := range map[string]string{
+		"blake-bucket":   "blake3",
+		"default-bucket": digest.DefaultAlgorithm,
+	} {
+		meta, err := after.Head(bucket, "test-object", store.GetOptions{})
+		if err != nil {
+			t.Fatalf("can not get metadata: %s", err)
+		}
+
+		if got := meta.Digest.Algorithm(); got != want {
+			t.Errorf("got algorithm %q in %s, want %q", got, bucket, want)
+		}
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	s, err := NewStore(log, t.TempDir(), WithDigest(digest.Algorithm{
+		Name: "constant",
+		Digester: func(r io.Reader) (digest.Digest, error) {
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return "", err
+			}
+			return "constant", nil
+		},
+		Verify: true,
+	}))
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	for _, objectID := range []string{"test-object", "test-object2"} {
+		if _, err := s.Put("test-bucket", objectID, strings.NewReader("test-content"), store.PutOptions{}); err != nil {
+			t.Fatalf("can not put %s: %s", objectID, err)
+		}
+	}
+
+	_, err = s.Put("test-bucket", "test-object3", strings.NewReader("other-content"), store.PutOptions{})
+	if !errors.Is(err, digest.ErrCollision) {
+		t.Errorf("got error %q, want %q", err, digest.ErrCollision)
+	}
+
+	if _, err := s.Head("test-bucket", "test-object3", store.GetOptions{}); err != store.ErrNotFound {
+		t.Errorf("got error %q for colliding object, want %q", err, store.ErrNotFound)
+	}
+
+	// Colliding contents are rejected by all operations saving contents, as the disk store does not keep them
+	// separately.
+	results, err := s.Batch("test-bucket", []store.BatchOperation{
+		{Op: store.BatchPut, ObjectID: "batch-object", Content: []byte("other-content")},
+	}, true)
+	if err != nil {
+		t.Fatalf("can not apply batch: %s", err)
+	}
+	if !errors.Is(results[0].Err, digest.ErrCollision) {
+		t.Errorf("got error %q for batch, want %q", results[0].Err, digest.ErrCollision)
+	}
+
+	uploadID, err := s.CreateUpload("test-bucket", "upload-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+	if _, err := s.PutPart("test-bucket", "upload-object", uploadID, 1, strings.NewReader("other-content")); err != nil {
+		t.Fatalf("can not put part: %s", err)
+	}
+	if _, err := s.CompleteUpload("test-bucket", "upload-object", uploadID, nil); !errors.Is(err, digest.ErrCollision) {
+		t.Errorf("got error %q for upload, want %q", err, digest.ErrCollision)
+	}
+
+	if _, err := s.Put("other-bucket", "test-object", strings.NewReader("other-content"), store.PutOptions{}); err != nil {
+		t.Fatalf("can not put into other bucket: %s", err)
+	}
+	if _, err := s.Copy("other-bucket", "test-object", "test-bucket", "copy-object", store.CopyOptions{}); !errors.Is(err, digest.ErrCollision) {
+		t.Errorf("got error %q for copy, want %q", err, digest.ErrCollision)
+	}
+
+	for _, objectID := range []string{"batch-object", "upload-object", "copy-object"} {
+		if _, err := s.Head("test-bucket", objectID, store.GetOptions{}); err != store.ErrNotFound {
+			t.Errorf("got error %q for %s, want %q", err, objectID, store.ErrNotFound)
+		}
+	}
+
+	reader, _, err := s.Get("test-bucket", "test-object", store.GetOptions{})
+	if err != nil {
+		t.Fatalf("can not get test-object: %s", err)
+	}
+	if got := testutil.ReadAll(t, reader); got != "test-content" {
+		t.Errorf("got content %q, want %q", got, "test-content")
+	}
+}
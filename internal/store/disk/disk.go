@@ -0,0 +1,609 @@
+package disk
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/fileutil"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+const (
+	indexFile    = "index.json"
+	contentsDir  = "contents"
+	uploadPrefix = ".upload-"
+	uploadsDir   = ".multipart"
+)
+
+type bucket struct {
+	dir       string
+	objects   map[string]digest.Digest
+	metadata  map[string]store.Metadata
+	lifecycle []store.LifecycleRule
+	// expired counts the objects removed by expiration since the store was started.
+	expired uint
+	// refs contains the number of objects referencing each content file.
+	refs map[digest.Digest]uint
+	// size is the number of bytes of the content files.
+	size  uint64
+	usage store.Usage
+	// algorithm is the digest algorithm selected when the bucket was created. It is nil for the default algorithm.
+	algorithm *digest.Algorithm
+}
+
+func newBucket(dir string) *bucket {
+	return &bucket{
+		dir:      dir,
+		objects:  make(map[string]digest.Digest),
+		metadata: make(map[string]store.Metadata),
+		refs:     make(map[digest.Digest]uint),
+	}
+}
+
+// setObject saves the object in the bucket and updates the usage. The content file needs to exist already.
+func (b *bucket) setObject(objectID string, meta store.Metadata) {
+	var previous *store.Metadata
+	if _, ok := b.objects[objectID]; ok {
+		previousMeta := b.metadata[objectID]
+		previous = &previousMeta
+		b.unreference(previousMeta)
+	}
+
+	b.objects[objectID] = meta.Digest
+	b.metadata[objectID] = meta
+	if b.refs[meta.Digest] == 0 {
+		b.size += uint64(meta.Size)
+	}
+	b.refs[meta.Digest]++
+	b.usage.Update(objectID, previous, &meta, b.metadata)
+}
+
+// unsetObject removes the object from the bucket and updates the usage. The content file is not removed.
+func (b *bucket) unsetObject(objectID string) {
+	if _, ok := b.objects[objectID]; !ok {
+		return
+	}
+
+	previous := b.metadata[objectID]
+	delete(b.objects, objectID)
+	delete(b.metadata, objectID)
+	b.unreference(previous)
+	b.usage.Update(objectID, &previous, nil, b.metadata)
+}
+
+func (b *bucket) unreference(meta store.Metadata) {
+	b.refs[meta.Digest]--
+	if b.refs[meta.Digest] == 0 {
+		delete(b.refs, meta.Digest)
+		b.size -= uint64(meta.Size)
+	}
+}
+
+// index is the on-disk representation of a bucket's object index.
+type index struct {
+	Name    string                   `json:"name"`
+	Objects map[string]digest.Digest `json:"objects"`
+	// Metadata is missing from indexes written by older versions.
+	Metadata  map[string]store.Metadata `json:"metadata,omitempty"`
+	Lifecycle []store.LifecycleRule     `json:"lifecycle,omitempty"`
+	Digest    string                    `json:"digest,omitempty"`
+}
+
+// Store is a store.Store which keeps contents as content-addressed files and the object index of each bucket
+// as a JSON file. Every bucket is stored in its own directory below the base directory. Contents colliding with an
+// existing content of the same digest are rejected with digest.ErrCollision.
+type Store struct {
+	log         logrus.FieldLogger
+	dir         string
+	buckets     map[string]*bucket
+	bucketMutex *sync.RWMutex
+	digester    digest.Digester
+	verify      bool
+	now         func() time.Time
+	uploads     map[string]*upload
+	uploadMutex *sync.Mutex
+}
+
+// Option configures optional behavior of a Store.
+type Option func(s *Store)
+
+// WithDigest uses the algorithm for the contents of all buckets which do not select their own algorithm.
+func WithDigest(algorithm digest.Algorithm) Option {
+	return func(s *Store) {
+		s.digester = algorithm.Digester
+		s.verify = algorithm.Verify
+	}
+}
+
+// NewStore creates a Store using dir as base directory. Buckets already existing in dir are loaded.
+func NewStore(log logrus.FieldLogger, dir string, opts ...Option) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can not create directory: %w", err)
+	}
+
+	s := &Store{
+		log:         log,
+		dir:         dir,
+		buckets:     make(map[string]*bucket),
+		bucketMutex: &sync.RWMutex{},
+		digester:    digest.SHA256,
+		now:         time.Now,
+		uploads:     make(map[string]*upload),
+		uploadMutex: &sync.Mutex{},
+	}
+
+	for _, o := range opts {
+		o(s)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *Store) load() error {
+	if err := os.RemoveAll(filepath.Join(s.dir, uploadsDir)); err != nil {
+		return fmt.Errorf("can not remove multipart uploads: %w", err)
+	}
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return fmt.Errorf("can not read directory: %w", err)
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() {
+			if strings.HasPrefix(e.Name(), uploadPrefix) {
+				s.log.Debugf("Removing incomplete upload: %s", e.Name())
+				os.Remove(filepath.Join(s.dir, e.Name()))
+			}
+			continue
+		}
+
+		bucketDir := filepath.Join(s.dir, e.Name())
+		data, err := ioutil.ReadFile(filepath.Join(bucketDir, indexFile))
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			s.log.Warnf("Directory without index: %s", bucketDir)
+			continue
+		case err != nil:
+			return fmt.Errorf("can not read index of %q: %w", bucketDir, err)
+		default:
+		}
+
+		var idx index
+		if err := json.Unmarshal(data, &idx); err != nil {
+			return fmt.Errorf("can not parse index of %q: %w", bucketDir, err)
+		}
+
+		metadata, err := restoreMetadata(bucketDir, idx)
+		if err != nil {
+			return fmt.Errorf("can not restore metadata of %q: %w", bucketDir, err)
+		}
+
+		algorithm, err := bucketAlgorithm(idx.Digest)
+		if err != nil {
+			return fmt.Errorf("can not load bucket %q: %w", bucketDir, err)
+		}
+
+		b := newBucket(bucketDir)
+		b.lifecycle = idx.Lifecycle
+		b.algorithm = algorithm
+		for objectID := range idx.Objects {
+			b.setObject(objectID, metadata[objectID])
+		}
+		s.buckets[idx.Name] = b
+	}
+
+	s.log.Debugf("Loaded %d buckets from %s", len(s.buckets), s.dir)
+	return nil
+}
+
+func (s *Store) Stats() store.StoreStats {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	stats := store.StoreStats{
+		Buckets: make(map[string]store.BucketStats, len(s.buckets)),
+	}
+	for name, b := range s.buckets {
+		stats.AddBucket(name, b.stats())
+		// Contents are not shared between buckets.
+		stats.PhysicalBytes += b.size
+	}
+	stats.DedupRatio = store.DedupRatio(stats.LogicalBytes, stats.PhysicalBytes)
+
+	return stats
+}
+
+func (s *Store) BucketStats(bucketName string) (store.BucketStats, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return store.BucketStats{}, store.ErrNotFound
+	}
+
+	return b.stats(), nil
+}
+
+func (b *bucket) stats() store.BucketStats {
+	stats := b.usage.BucketStats(b.size)
+	stats.NumContents = uint(len(b.refs))
+	stats.Expired = b.expired
+
+	return stats
+}
+
+// restoreMetadata returns the metadata of all objects of the index. The metadata of objects saved by older versions
+// is created from their content files.
+func restoreMetadata(bucketDir string, idx index) (map[string]store.Metadata, error) {
+	metadata := make(map[string]store.Metadata, len(idx.Objects))
+	for objectID, d := range idx.Objects {
+		if meta, ok := idx.Metadata[objectID]; ok {
+			metadata[objectID] = meta
+			continue
+		}
+
+		info, err := os.Stat(contentPath(bucketDir, d))
+		if err != nil {
+			return nil, err
+		}
+
+		metadata[objectID] = store.NewMetadata(store.PutOptions{}, info.Size(), d, info.ModTime(), nil)
+	}
+
+	return metadata, nil
+}
+
+// Get returns the current version of the object. Previous versions are not kept, so selecting a version fails.
+func (s *Store) Get(bucketName, objectID string, opts store.GetOptions) (io.ReadSeekCloser, store.Metadata, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok || opts.VersionID != "" {
+		return nil, store.Metadata{}, store.ErrNotFound
+	}
+
+	obj, ok := b.objects[objectID]
+	if !ok {
+		return nil, store.Metadata{}, store.ErrNotFound
+	}
+
+	// An open file stays readable even if the content is removed by a concurrent delete.
+	file, err := os.Open(contentPath(b.dir, obj))
+	if err != nil {
+		return nil, store.Metadata{}, fmt.Errorf("can not open content with digest %q: %w", obj, err)
+	}
+
+	return file, b.metadata[objectID], nil
+}
+
+func (s *Store) Head(bucketName, objectID string, opts store.GetOptions) (store.Metadata, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok || opts.VersionID != "" {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	if _, ok := b.objects[objectID]; !ok {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	return b.metadata[objectID], nil
+}
+
+func (s *Store) GetContent(bucketName string, d digest.Digest) (io.ReadSeekCloser, int64, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok || b.refs[d] == 0 {
+		return nil, 0, store.ErrNotFound
+	}
+
+	file, err := os.Open(contentPath(b.dir, d))
+	if err != nil {
+		return nil, 0, fmt.Errorf("can not open content with digest %q: %w", d, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("can not open content with digest %q: %w", d, err)
+	}
+
+	return file, info.Size(), nil
+}
+
+func (s *Store) Put(bucketName string, objectID string, content io.Reader, opts store.PutOptions) (store.Metadata, error) {
+	// The content is streamed into a temporary file before acquiring the lock and moved into place afterwards.
+	tmp, err := ioutil.TempFile(s.dir, uploadPrefix)
+	if err != nil {
+		return store.Metadata{}, fmt.Errorf("can not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	// Contents of a bucket which is recreated with a different algorithm in the meantime are still saved with the
+	// previous algorithm. This is harmless, because the digests of different algorithms do not overlap.
+	s.bucketMutex.RLock()
+	digester, verify := s.bucketDigester(s.buckets[bucketName])
+	s.bucketMutex.RUnlock()
+
+	contentDigest, err := digester(io.TeeReader(content, tmp))
+	if err != nil {
+		return store.Metadata{}, fmt.Errorf("can not create digest: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return store.Metadata{}, fmt.Errorf("can not write content: %w", err)
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return store.Metadata{}, fmt.Errorf("can not write content: %w", err)
+	}
+
+	return s.save(bucketName, objectID, tmp.Name(), contentDigest, verify, opts.Condition, func(replaced *store.Metadata) store.Metadata {
+		return store.NewMetadata(opts, info.Size(), contentDigest, s.now(), replaced)
+	})
+}
+
+// save moves the content from the temporary file into place and saves the object with the metadata created by
+// newMeta. The temporary file is left in place if the content already exists.
+func (s *Store) save(bucketName, objectID, tmpPath string, contentDigest digest.Digest, verify bool, condition store.Condition, newMeta func(replaced *store.Metadata) store.Metadata) (store.Metadata, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	return s.saveLocked(bucketName, objectID, tmpPath, contentDigest, verify, condition, newMeta)
+}
+
+// saveLocked is save for callers which already hold the lock.
+func (s *Store) saveLocked(bucketName, objectID, tmpPath string, contentDigest digest.Digest, verify bool, condition store.Condition, newMeta func(replaced *store.Metadata) store.Metadata) (store.Metadata, error) {
+	replaced := s.current(bucketName, objectID)
+	if err := condition.Check(replaced); err != nil {
+		return store.Metadata{}, err
+	}
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		var err error
+		b, err = s.createBucket(bucketName)
+		if err != nil {
+			return store.Metadata{}, err
+		}
+	}
+
+	if _, err := placeContent(contentPath(b.dir, contentDigest), tmpPath, contentDigest, verify); err != nil {
+		return store.Metadata{}, err
+	}
+
+	meta := newMeta(replaced)
+	if err := s.replaceObject(bucketName, b, objectID, meta, replaced); err != nil {
+		return store.Metadata{}, err
+	}
+
+	return meta, nil
+}
+
+// placeContent moves the content from the temporary file to path unless it already exists. Existing contents are
+// compared with the temporary file if verify is set. Returns true if the file has been moved.
+func placeContent(path, tmpPath string, contentDigest digest.Digest, verify bool) (bool, error) {
+	_, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if err := os.Rename(tmpPath, path); err != nil {
+			return false, fmt.Errorf("can not write content: %w", err)
+		}
+		return true, nil
+	case err == nil && verify:
+		return false, verifyContent(path, tmpPath, contentDigest)
+	default:
+		return false, nil
+	}
+}
+
+// replaceObject saves the metadata of the object and releases the content of the replaced object if it is not
+// referenced anymore. It needs to be called with the lock held.
+func (s *Store) replaceObject(bucketName string, b *bucket, objectID string, meta store.Metadata, replaced *store.Metadata) error {
+	b.setObject(objectID, meta)
+	if err := writeIndex(bucketName, b); err != nil {
+		if replaced != nil {
+			b.setObject(objectID, *replaced)
+		} else {
+			b.unsetObject(objectID)
+		}
+		return err
+	}
+	s.buckets[bucketName] = b
+
+	if replaced != nil && replaced.Digest != meta.Digest {
+		s.releaseContent(b, replaced.Digest)
+	}
+
+	return nil
+}
+
+func (s *Store) PutReference(bucketName, objectID string, d digest.Digest, opts store.PutOptions) (store.Metadata, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok || b.refs[d] == 0 {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	replaced := s.current(bucketName, objectID)
+	if err := opts.Condition.Check(replaced); err != nil {
+		return store.Metadata{}, err
+	}
+
+	info, err := os.Stat(contentPath(b.dir, d))
+	if err != nil {
+		return store.Metadata{}, fmt.Errorf("can not open content with digest %q: %w", d, err)
+	}
+
+	meta := store.NewMetadata(opts, info.Size(), d, s.now(), replaced)
+	if err := s.replaceObject(bucketName, b, objectID, meta, replaced); err != nil {
+		return store.Metadata{}, err
+	}
+
+	return meta, nil
+}
+
+func (s *Store) List(bucketName string, opts store.ListOptions) (store.ListResult, error) {
+	s.bucketMutex.RLock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		s.bucketMutex.RUnlock()
+		return store.ListResult{}, store.ErrNotFound
+	}
+
+	ids := make([]string, 0, len(b.objects))
+	for id := range b.objects {
+		ids = append(ids, id)
+	}
+	s.bucketMutex.RUnlock()
+
+	return store.ListObjectIDs(ids, opts)
+}
+
+func (s *Store) Delete(bucketName, objectID string, opts store.DeleteOptions) error {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	return s.deleteWithOptions(bucketName, objectID, opts)
+}
+
+// deleteWithOptions deletes the object if the condition matches. It needs to be called with the lock held.
+func (s *Store) deleteWithOptions(bucketName, objectID string, opts store.DeleteOptions) error {
+	current := s.current(bucketName, objectID)
+	if err := opts.Condition.Check(current); err != nil {
+		return err
+	}
+
+	if current == nil || opts.VersionID != "" {
+		return store.ErrNotFound
+	}
+
+	return s.deleteObject(bucketName, objectID, *current)
+}
+
+// deleteObject removes the current object and releases its content if it is not referenced anymore. It needs to be
+// called with the lock held.
+func (s *Store) deleteObject(bucketName, objectID string, current store.Metadata) error {
+	b := s.buckets[bucketName]
+	b.unsetObject(objectID)
+	if err := writeIndex(bucketName, b); err != nil {
+		b.setObject(objectID, current)
+		return err
+	}
+
+	s.releaseContent(b, current.Digest)
+	return nil
+}
+
+// current returns the metadata of the object or nil if it does not exist. It needs to be called with the lock held.
+func (s *Store) current(bucketName, objectID string) *store.Metadata {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil
+	}
+
+	return b.current(objectID)
+}
+
+// current returns the metadata of the object in the bucket or nil if it does not exist.
+func (b *bucket) current(objectID string) *store.Metadata {
+	if _, ok := b.objects[objectID]; !ok {
+		return nil
+	}
+
+	meta := b.metadata[objectID]
+	return &meta
+}
+
+// releaseContent removes the content file unless the content is still referenced by an object.
+func (s *Store) releaseContent(b *bucket, contentDigest digest.Digest) {
+	if b.refs[contentDigest] > 0 {
+		return
+	}
+
+	if err := os.Remove(contentPath(b.dir, contentDigest)); err != nil {
+		s.log.Errorf("Error removing content %q: %s", contentDigest, err)
+	}
+}
+
+// CollectGarbage removes content files which are not referenced by any object and leftover temporary files.
+func (s *Store) CollectGarbage() (uint, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	var removed uint
+	for _, b := range s.buckets {
+		referenced := make(map[string]bool, len(b.objects))
+		for _, d := range b.objects {
+			referenced[filepath.Base(contentPath(b.dir, d))] = true
+		}
+
+		dir := filepath.Join(b.dir, contentsDir)
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return removed, fmt.Errorf("can not list contents: %w", err)
+		}
+
+		for _, f := range files {
+			if referenced[f.Name()] {
+				continue
+			}
+
+			if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+				return removed, fmt.Errorf("can not remove content: %w", err)
+			}
+			removed++
+		}
+	}
+
+	return removed, nil
+}
+
+func contentPath(bucketDir string, d digest.Digest) string {
+	return filepath.Join(bucketDir, contentsDir, hex.EncodeToString([]byte(d)))
+}
+
+func writeIndex(name string, b *bucket) error {
+	data, err := json.Marshal(index{
+		Name:      name,
+		Objects:   b.objects,
+		Metadata:  b.metadata,
+		Lifecycle: b.lifecycle,
+		Digest:    algorithmName(b),
+	})
+	if err != nil {
+		return fmt.Errorf("can not encode index: %w", err)
+	}
+
+	if err := fileutil.WriteFile(filepath.Join(b.dir, indexFile), data); err != nil {
+		return fmt.Errorf("can not write index: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,775 @@
+package disk
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+var (
+	log = logrus.New()
+)
+
+type putOp struct {
+	bucket   string
+	objectID string
+	content  string
+}
+
+func newTestStore(t *testing.T, dir string, puts []putOp) *Store {
+	s, err := NewStore(log, dir)
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	for _, p := range puts {
+		if _, err := s.Put(p.bucket, p.objectID, strings.NewReader(p.content), store.PutOptions{}); err != nil {
+			t.Fatalf("can not put %s/%s: %s", p.bucket, p.objectID, err)
+		}
+	}
+
+	return s
+}
+
+func countContentFiles(t *testing.T, s *Store, bucketName string) int {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return 0
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(b.dir, contentsDir))
+	if err != nil {
+		t.Fatalf("can not list contents: %s", err)
+	}
+
+	return len(files)
+}
+
+func TestGet(t *testing.T) {
+	tt := []struct {
+		desc        string
+		puts        []putOp
+		bucket      string
+		objectID    string
+		wantContent string
+		wantErr     error
+	}{
+		{
+			desc:     "empty",
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			wantErr:  store.ErrNotFound,
+		},
+		{
+			desc: "object not found",
+			puts: []putOp{
+				{"test-bucket", "other-object", "content"},
+			},
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			wantErr:  store.ErrNotFound,
+		},
+		{
+			desc: "success",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+			},
+			bucket:      "test-bucket",
+			objectID:    "test-object",
+			wantContent: "content",
+		},
+		{
+			desc: "overwritten",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+				{"test-bucket", "test-object", "content2"},
+			},
+			bucket:      "test-bucket",
+			objectID:    "test-object",
+			wantContent: "content2",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestStore(t, t.TempDir(), tc.puts)
+
+			reader, _, err := s.Get(tc.bucket, tc.objectID, store.GetOptions{})
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			content := testutil.ReadAll(t, reader)
+			if content != tc.wantContent {
+				t.Errorf("got content %q, want %q", content, tc.wantContent)
+			}
+		})
+	}
+}
+
+func TestGetContent(t *testing.T) {
+	contentDigest, err := digest.SHA256(strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("can not create digest: %s", err)
+	}
+
+	tt := []struct {
+		desc        string
+		puts        []putOp
+		bucket      string
+		wantContent string
+		wantErr     error
+	}{
+		{
+			desc:    "empty",
+			bucket:  "test-bucket",
+			wantErr: store.ErrNotFound,
+		},
+		{
+			desc: "content not found",
+			puts: []putOp{
+				{"test-bucket", "test-object", "other-content"},
+			},
+			bucket:  "test-bucket",
+			wantErr: store.ErrNotFound,
+		},
+		{
+			desc: "content of other bucket",
+			puts: []putOp{
+				{"other-bucket", "test-object", "content"},
+				{"test-bucket", "test-object", "other-content"},
+			},
+			bucket:  "test-bucket",
+			wantErr: store.ErrNotFound,
+		},
+		{
+			desc: "overwritten",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+				{"test-bucket", "test-object", "content2"},
+			},
+			bucket:  "test-bucket",
+			wantErr: store.ErrNotFound,
+		},
+		{
+			desc: "success",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+			},
+			bucket:      "test-bucket",
+			wantContent: "content",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestStore(t, t.TempDir(), tc.puts)
+
+			reader, size, err := s.GetContent(tc.bucket, contentDigest)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if size != int64(len(tc.wantContent)) {
+				t.Errorf("got size %d, want %d", size, len(tc.wantContent))
+			}
+
+			content := testutil.ReadAll(t, reader)
+			if content != tc.wantContent {
+				t.Errorf("got content %q, want %q", content, tc.wantContent)
+			}
+		})
+	}
+}
+
+func TestPutReference(t *testing.T) {
+	contentDigest, err := digest.SHA256(strings.NewReader("content"))
+	if err != nil {
+		t.Fatalf("can not create digest: %s", err)
+	}
+
+	tt := []struct {
+		desc     string
+		puts     []putOp
+		objectID string
+		opts     store.PutOptions
+		wantErr  error
+	}{
+		{
+			desc:     "bucket not found",
+			objectID: "linked-object",
+			wantErr:  store.ErrNotFound,
+		},
+		{
+			desc: "content not found",
+			puts: []putOp{
+				{"test-bucket", "test-object", "other-content"},
+				{"other-bucket", "test-object", "content"},
+			},
+			objectID: "linked-object",
+			wantErr:  store.ErrNotFound,
+		},
+		{
+			desc: "precondition failed",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+			},
+			objectID: "test-object",
+			opts: store.PutOptions{
+				Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+		{
+			desc: "new object",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+			},
+			objectID: "linked-object",
+		},
+		{
+			desc: "overwrite object",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+				{"test-bucket", "linked-object", "other-content"},
+			},
+			objectID: "linked-object",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s := newTestStore(t, dir, tc.puts)
+
+			_, err := s.PutReference("test-bucket", tc.objectID, contentDigest, tc.opts)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			// The object needs to be restored from the index after a restart.
+			restarted := newTestStore(t, dir, nil)
+			reader, meta, err := restarted.Get("test-bucket", tc.objectID, store.GetOptions{})
+			if err != nil {
+				t.Fatalf("can not get object: %s", err)
+			}
+
+			if content := testutil.ReadAll(t, reader); content != "content" {
+				t.Errorf("got content %q, want %q", content, "content")
+			}
+
+			if meta.Digest != contentDigest || meta.Size != 7 {
+				t.Errorf("got digest %q and size %d, want %q and %d", meta.Digest, meta.Size, contentDigest, 7)
+			}
+
+			if got := countContentFiles(t, restarted, "test-bucket"); got != 1 {
+				t.Errorf("got %d content files, want 1", got)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tt := []struct {
+		desc         string
+		puts         []putOp
+		bucket       string
+		objectID     string
+		wantErr      error
+		wantStats    store.StoreStats
+		wantContents int
+	}{
+		{
+			desc:     "bucket not found",
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			wantErr:  store.ErrNotFound,
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{},
+			},
+		},
+		{
+			desc: "object not found",
+			puts: []putOp{
+				{"test-bucket", "other-object", "content"},
+			},
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			wantErr:  store.ErrNotFound,
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{
+					"test-bucket": {
+						NumObjects:    1,
+						NumContents:   1,
+						LogicalBytes:  7,
+						PhysicalBytes: 7,
+						DedupRatio:    1,
+						LargestObject: &store.ObjectSize{ObjectID: "other-object", Size: 7},
+					},
+				},
+				NumObjects:    1,
+				LogicalBytes:  7,
+				PhysicalBytes: 7,
+				DedupRatio:    1,
+				LargestObject: &store.ObjectSize{Bucket: "test-bucket", ObjectID: "other-object", Size: 7},
+			},
+			wantContents: 1,
+		},
+		{
+			desc: "delete object",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+			},
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{
+					"test-bucket": {},
+				},
+			},
+			wantContents: 0,
+		},
+		{
+			desc: "used content remaining",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+				{"test-bucket", "test-object2", "content"},
+			},
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{
+					"test-bucket": {
+						NumObjects:    1,
+						NumContents:   1,
+						LogicalBytes:  7,
+						PhysicalBytes: 7,
+						DedupRatio:    1,
+						LargestObject: &store.ObjectSize{ObjectID: "test-object2", Size: 7},
+					},
+				},
+				NumObjects:    1,
+				LogicalBytes:  7,
+				PhysicalBytes: 7,
+				DedupRatio:    1,
+				LargestObject: &store.ObjectSize{Bucket: "test-bucket", ObjectID: "test-object2", Size: 7},
+			},
+			wantContents: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := newTestStore(t, t.TempDir(), tc.puts)
+
+			err := s.Delete(tc.bucket, tc.objectID, store.DeleteOptions{})
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if diff := cmp.Diff(s.Stats(), tc.wantStats); diff != "" {
+				t.Errorf("stats differ: -got+want\n%s", diff)
+			}
+
+			if contents := countContentFiles(t, s, tc.bucket); contents != tc.wantContents {
+				t.Errorf("got %d content files, want %d", contents, tc.wantContents)
+			}
+		})
+	}
+}
+
+func TestRestart(t *testing.T) {
+	puts := []putOp{
+		{"test-bucket", "test-object", "content"},
+		{"test-bucket", "test-object2", "content"},
+		{"test-bucket", "test-object3", "content2"},
+		{"other/bucket", "test-object", "content"},
+	}
+
+	dir := t.TempDir()
+	before := newTestStore(t, dir, puts)
+	if err := before.Delete("test-bucket", "test-object3", store.DeleteOptions{}); err != nil {
+		t.Fatalf("can not delete object: %s", err)
+	}
+
+	after := newTestStore(t, dir, nil)
+
+	wantStats := store.StoreStats{
+		Buckets: map[string]store.BucketStats{
+			"test-bucket": {
+				NumObjects:    2,
+				NumContents:   1,
+				BytesSaved:    7,
+				LogicalBytes:  14,
+				PhysicalBytes: 7,
+				DedupRatio:    2,
+				LargestObject: &store.ObjectSize{ObjectID: "test-object", Size: 7},
+			},
+			"other/bucket": {
+				NumObjects:    1,
+				NumContents:   1,
+				LogicalBytes:  7,
+				PhysicalBytes: 7,
+				DedupRatio:    1,
+				LargestObject: &store.ObjectSize{ObjectID: "test-object", Size: 7},
+			},
+		},
+		NumObjects:    3,
+		LogicalBytes:  21,
+		PhysicalBytes: 14,
+		DedupRatio:    1.5,
+		LargestObject: &store.ObjectSize{Bucket: "other/bucket", ObjectID: "test-object", Size: 7},
+	}
+	if diff := cmp.Diff(after.Stats(), wantStats); diff != "" {
+		t.Errorf("stats differ: -got+want\n%s", diff)
+	}
+
+	bucketStats, err := after.BucketStats("test-bucket")
+	if err != nil {
+		t.Fatalf("can not get bucket stats: %s", err)
+	}
+
+	if diff := cmp.Diff(bucketStats, wantStats.Buckets["test-bucket"]); diff != "" {
+		t.Errorf("bucket stats differ: -got+want\n%s", diff)
+	}
+
+	if _, err := after.BucketStats("missing-bucket"); err != store.ErrNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrNotFound)
+	}
+
+	for _, p := range puts[:2] {
+		reader, _, err := after.Get(p.bucket, p.objectID, store.GetOptions{})
+		if err != nil {
+			t.Errorf("can not get %s/%s: %s", p.bucket, p.objectID, err)
+			continue
+		}
+
+		if content := testutil.ReadAll(t, reader); content != p.content {
+			t.Errorf("got content %q, want %q", content, p.content)
+		}
+	}
+
+	if _, _, err := after.Get("test-bucket", "test-object3", store.GetOptions{}); err != store.ErrNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrNotFound)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), []putOp{
+		{"test-bucket", "test-object", "content"},
+		{"test-bucket", "test-object", "content2"},
+	})
+
+	if contents := countContentFiles(t, s, "test-bucket"); contents != 1 {
+		t.Errorf("got %d content files after overwrite, want %d", contents, 1)
+	}
+
+	leaked := filepath.Join(s.buckets["test-bucket"].dir, contentsDir, "leaked")
+	if err := ioutil.WriteFile(leaked, []byte("leaked content"), 0o644); err != nil {
+		t.Fatalf("can not create leaked content: %s", err)
+	}
+
+	removed, err := s.CollectGarbage()
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if removed != 1 {
+		t.Errorf("got %d removed contents, want %d", removed, 1)
+	}
+
+	if contents := countContentFiles(t, s, "test-bucket"); contents != 1 {
+		t.Errorf("got %d content files, want %d", contents, 1)
+	}
+}
+
+func TestDeleteBucket(t *testing.T) {
+	tt := []struct {
+		desc        string
+		puts        []putOp
+		create      []string
+		bucket      string
+		force       bool
+		wantErr     error
+		wantBuckets []string
+	}{
+		{
+			desc:        "bucket not found",
+			bucket:      "test-bucket",
+			wantErr:     store.ErrNotFound,
+			wantBuckets: []string{},
+		},
+		{
+			desc:        "empty bucket",
+			create:      []string{"test-bucket", "other-bucket"},
+			bucket:      "test-bucket",
+			wantBuckets: []string{"other-bucket"},
+		},
+		{
+			desc: "bucket not empty",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+			},
+			bucket:      "test-bucket",
+			wantErr:     store.ErrBucketNotEmpty,
+			wantBuckets: []string{"test-bucket"},
+		},
+		{
+			desc: "forced",
+			puts: []putOp{
+				{"test-bucket", "test-object", "content"},
+				{"other-bucket", "test-object", "content"},
+			},
+			bucket:      "test-bucket",
+			force:       true,
+			wantBuckets: []string{"other-bucket"},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s := newTestStore(t, dir, tc.puts)
+			for _, name := range tc.create {
+				if err := s.CreateBucket(name, store.BucketOptions{}); err != nil {
+					t.Fatalf("can not create bucket %s: %s", name, err)
+				}
+			}
+
+			err := s.DeleteBucket(tc.bucket, tc.force)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			// The deletion needs to survive a restart.
+			restarted := newTestStore(t, dir, nil)
+			buckets, err := restarted.ListBuckets()
+			if err != nil {
+				t.Fatalf("can not list buckets: %s", err)
+			}
+
+			if diff := cmp.Diff(buckets, tc.wantBuckets); diff != "" {
+				t.Errorf("buckets differ: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	created := time.Date(2021, 6, 1, 11, 0, 0, 0, time.UTC)
+	modified := created.Add(time.Hour)
+
+	dir := t.TempDir()
+	before := newTestStore(t, dir, nil)
+	before.now = func() time.Time {
+		return created
+	}
+	if _, err := before.Put("test-bucket", "test-object", strings.NewReader("content"), store.PutOptions{}); err != nil {
+		t.Fatalf("can not put object: %s", err)
+	}
+
+	before.now = func() time.Time {
+		return modified
+	}
+	if _, err := before.Put("test-bucket", "test-object", strings.NewReader("content2"), store.PutOptions{
+		ContentType: "text/plain",
+		UserMetadata: map[string]string{
+			"Author": "test-author",
+		},
+	}); err != nil {
+		t.Fatalf("can not overwrite object: %s", err)
+	}
+
+	after := newTestStore(t, dir, nil)
+	meta, err := after.Head("test-bucket", "test-object", store.GetOptions{})
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	wantMeta := store.Metadata{
+		ContentType: "text/plain",
+		Size:        8,
+		Created:     created,
+		Modified:    modified,
+		Digest:      "dab741b6289e7dccc1ed42330cae1accc2b755ce8079c2cd5d4b5366c9f769a6",
+		User: map[string]string{
+			"Author": "test-author",
+		},
+	}
+	if diff := cmp.Diff(meta, wantMeta); diff != "" {
+		t.Errorf("metadata differs: -got+want\n%s", diff)
+	}
+
+	if _, err := after.Head("test-bucket", "other-object", store.GetOptions{}); err != store.ErrNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrNotFound)
+	}
+}
+
+func TestConditions(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), []putOp{
+		{"test-bucket", "test-object", "content"},
+	})
+
+	createOnly := store.Condition{
+		IfNoneMatch: []digest.Digest{store.MatchAny},
+	}
+	if _, err := s.Put("test-bucket", "test-object", strings.NewReader("content2"), store.PutOptions{
+		Condition: createOnly,
+	}); err != store.ErrPreconditionFailed {
+		t.Errorf("got error %q, want %q", err, store.ErrPreconditionFailed)
+	}
+
+	if _, err := s.Put("other-bucket", "test-object", strings.NewReader("content2"), store.PutOptions{
+		Condition: createOnly,
+	}); err != nil {
+		t.Errorf("got error: %s", err)
+	}
+
+	changed := store.Condition{
+		IfMatch: []digest.Digest{"other-digest"},
+	}
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{
+		Condition: changed,
+	}); err != store.ErrPreconditionFailed {
+		t.Errorf("got error %q, want %q", err, store.ErrPreconditionFailed)
+	}
+
+	if content := countContentFiles(t, s, "test-bucket"); content != 1 {
+		t.Errorf("got %d content files, want %d", content, 1)
+	}
+
+	meta, err := s.Head("test-bucket", "test-object", store.GetOptions{})
+	if err != nil {
+		t.Fatalf("can not get metadata: %s", err)
+	}
+
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{
+		Condition: store.Condition{
+			IfMatch: []digest.Digest{meta.Digest},
+		},
+	}); err != nil {
+		t.Errorf("got error: %s", err)
+	}
+}
+
+func TestVersioning(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), []putOp{
+		{"test-bucket", "test-object", "content"},
+		{"test-bucket", "other-object", "content2"},
+	})
+
+	if err := s.SetVersioning("test-bucket", true); err != store.ErrNotSupported {
+		t.Errorf("got error %q, want %q", err, store.ErrNotSupported)
+	}
+
+	if err := s.SetVersioning("missing-bucket", true); err != store.ErrNotFound {
+		t.Errorf("got error %q for missing bucket, want %q", err, store.ErrNotFound)
+	}
+
+	if _, err := s.Head("test-bucket", "test-object", store.GetOptions{VersionID: "test-version"}); err != store.ErrNotFound {
+		t.Errorf("got error %q for version, want %q", err, store.ErrNotFound)
+	}
+
+	versions, err := s.ListVersions("test-bucket", "test-")
+	if err != nil {
+		t.Fatalf("can not list versions: %s", err)
+	}
+
+	wantVersions := []store.Version{
+		{ObjectID: "test-object", IsLatest: true, Size: 7},
+	}
+	if diff := cmp.Diff(versions, wantVersions, cmpopts.IgnoreFields(store.Version{}, "Modified", "Digest")); diff != "" {
+		t.Errorf("versions differ: -got+want\n%s", diff)
+	}
+}
+
+func TestExpireObjects(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, dir, []putOp{
+		{"test-bucket", "test-object", "content"},
+		{"test-bucket", "tmp/object", "content"},
+		{"test-bucket", "tmp/other-object", "content2"},
+	})
+
+	rules := []store.LifecycleRule{
+		{Prefix: "tmp/", Days: 1},
+	}
+	if err := s.SetLifecycle("test-bucket", rules); err != nil {
+		t.Fatalf("can not set lifecycle: %s", err)
+	}
+
+	s.now = func() time.Time {
+		return time.Now().AddDate(0, 0, 1)
+	}
+	expired, err := s.ExpireObjects()
+	if err != nil {
+		t.Fatalf("can not expire objects: %s", err)
+	}
+
+	if expired != 2 {
+		t.Errorf("got %d expired objects, want 2", expired)
+	}
+
+	after, err := NewStore(log, dir)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+
+	got, err := after.Lifecycle("test-bucket")
+	if err != nil {
+		t.Fatalf("can not get lifecycle: %s", err)
+	}
+
+	if diff := cmp.Diff(got, rules); diff != "" {
+		t.Errorf("rules differ: -got+want\n%s", diff)
+	}
+
+	list, err := after.List("test-bucket", store.ListOptions{})
+	if err != nil {
+		t.Fatalf("can not list objects: %s", err)
+	}
+
+	if diff := cmp.Diff(list.Objects, []string{"test-object"}); diff != "" {
+		t.Errorf("objects differ: -got+want\n%s", diff)
+	}
+
+	if removed, err := after.CollectGarbage(); err != nil || removed != 0 {
+		t.Errorf("got %d unreferenced contents (error %v), want none", removed, err)
+	}
+}
@@ -0,0 +1,89 @@
+package disk
+
+import (
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (s *Store) SetLifecycle(bucketName string, rules []store.LifecycleRule) error {
+	if err := store.ValidateRules(rules); err != nil {
+		return err
+	}
+
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	previous := b.lifecycle
+	b.lifecycle = rules
+	if err := writeIndex(bucketName, b); err != nil {
+		b.lifecycle = previous
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) Lifecycle(bucketName string) ([]store.LifecycleRule, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return b.lifecycle, nil
+}
+
+// ExpireObjects removes the expired objects of each bucket and writes the index once per bucket.
+func (s *Store) ExpireObjects() (uint, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	now := s.now()
+	var expired uint
+	for bucketName, b := range s.buckets {
+		removed := make(map[string]store.Metadata)
+		for objectID, meta := range b.metadata {
+			if store.Expired(objectID, meta, b.lifecycle, now) {
+				removed[objectID] = meta
+			}
+		}
+
+		if len(removed) == 0 {
+			continue
+		}
+
+		for objectID := range removed {
+			b.unsetObject(objectID)
+		}
+
+		if err := writeIndex(bucketName, b); err != nil {
+			for objectID, meta := range removed {
+				b.setObject(objectID, meta)
+			}
+			return expired, err
+		}
+
+		released := make(map[digest.Digest]bool, len(removed))
+		for _, meta := range removed {
+			if !released[meta.Digest] {
+				s.releaseContent(b, meta.Digest)
+				released[meta.Digest] = true
+			}
+		}
+		b.expired += uint(len(removed))
+		expired += uint(len(removed))
+	}
+
+	if uploads := s.expireUploads(now); uploads > 0 {
+		s.log.Debugf("Discarded %d uploads older than %s.", uploads, store.UploadMaxAge)
+	}
+
+	return expired, nil
+}
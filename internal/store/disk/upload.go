@@ -0,0 +1,229 @@
+package disk
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/xperimental/bukky/internal/store"
+)
+
+// upload is a multipart upload in progress. The parts are kept as files in the directory of the upload.
+// Uploads are discarded when the store is restarted.
+type upload struct {
+	bucket   string
+	objectID string
+	opts     store.PutOptions
+	dir      string
+	parts    map[int]store.Part
+	created  time.Time
+}
+
+func (s *Store) CreateUpload(bucketName, objectID string, opts store.PutOptions) (string, error) {
+	uploadID, err := store.NewUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(s.dir, uploadsDir, uploadID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("can not create upload directory: %w", err)
+	}
+
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	s.uploads[uploadID] = &upload{
+		bucket:   bucketName,
+		objectID: objectID,
+		opts:     opts,
+		dir:      dir,
+		parts:    make(map[int]store.Part),
+		created:  s.now(),
+	}
+	return uploadID, nil
+}
+
+// upload returns the upload if it belongs to the object. It needs to be called with the upload lock held.
+func (s *Store) upload(bucketName, objectID, uploadID string) (*upload, error) {
+	u, ok := s.uploads[uploadID]
+	if !ok || u.bucket != bucketName || u.objectID != objectID {
+		return nil, store.ErrUploadNotFound
+	}
+
+	return u, nil
+}
+
+func (s *Store) PutPart(bucketName, objectID, uploadID string, number int, content io.Reader) (store.Part, error) {
+	if err := store.ValidatePartNumber(number); err != nil {
+		return store.Part{}, err
+	}
+
+	s.uploadMutex.Lock()
+	u, err := s.upload(bucketName, objectID, uploadID)
+	s.uploadMutex.Unlock()
+	if err != nil {
+		return store.Part{}, err
+	}
+
+	tmp, err := ioutil.TempFile(u.dir, uploadPrefix)
+	if err != nil {
+		return store.Part{}, fmt.Errorf("can not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	d, err := s.digester(io.TeeReader(content, tmp))
+	if err != nil {
+		return store.Part{}, fmt.Errorf("can not create digest: %w", err)
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return store.Part{}, fmt.Errorf("can not write part: %w", err)
+	}
+
+	info, err := tmp.Stat()
+	if err != nil {
+		return store.Part{}, fmt.Errorf("can not write part: %w", err)
+	}
+
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	// The upload might have been completed or aborted in the meantime.
+	if _, err := s.upload(bucketName, objectID, uploadID); err != nil {
+		return store.Part{}, err
+	}
+
+	if err := os.Rename(tmp.Name(), partPath(u.dir, number)); err != nil {
+		return store.Part{}, fmt.Errorf("can not write part: %w", err)
+	}
+
+	part := store.Part{
+		Number: number,
+		Size:   info.Size(),
+		Digest: d,
+	}
+	u.parts[number] = part
+	return part, nil
+}
+
+func (s *Store) ListParts(bucketName, objectID, uploadID string) ([]store.Part, error) {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	u, err := s.upload(bucketName, objectID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.SortedParts(u.parts), nil
+}
+
+func (s *Store) CompleteUpload(bucketName, objectID, uploadID string, requested []store.Part) (store.Metadata, error) {
+	s.uploadMutex.Lock()
+	u, err := s.upload(bucketName, objectID, uploadID)
+	if err != nil {
+		s.uploadMutex.Unlock()
+		return store.Metadata{}, err
+	}
+
+	parts, err := store.SelectParts(u.parts, requested)
+	if err != nil {
+		s.uploadMutex.Unlock()
+		return store.Metadata{}, err
+	}
+
+	// The upload is removed while the object is saved, so that it can not be completed twice.
+	delete(s.uploads, uploadID)
+	s.uploadMutex.Unlock()
+
+	meta, err := s.assemble(u, parts)
+	if err != nil {
+		s.uploadMutex.Lock()
+		s.uploads[uploadID] = u
+		s.uploadMutex.Unlock()
+		return store.Metadata{}, err
+	}
+
+	if err := os.RemoveAll(u.dir); err != nil {
+		s.log.Errorf("Error removing upload directory %s: %s", u.dir, err)
+	}
+
+	return meta, nil
+}
+
+// assemble saves the object from the part files.
+func (s *Store) assemble(u *upload, parts []store.Part) (store.Metadata, error) {
+	readers := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		file, err := os.Open(partPath(u.dir, p.Number))
+		if err != nil {
+			return store.Metadata{}, fmt.Errorf("can not open part %d: %w", p.Number, err)
+		}
+		defer file.Close()
+
+		readers = append(readers, file)
+	}
+
+	return s.Put(u.bucket, u.objectID, io.MultiReader(readers...), u.opts)
+}
+
+func (s *Store) AbortUpload(bucketName, objectID, uploadID string) error {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	u, err := s.upload(bucketName, objectID, uploadID)
+	if err != nil {
+		return err
+	}
+
+	s.discardUpload(uploadID, u)
+	return nil
+}
+
+// removeUploads discards all uploads of the bucket together with their directories, so that they can not be
+// completed after the bucket has been deleted. It needs to be called with the write lock held.
+func (s *Store) removeUploads(bucketName string) {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	for uploadID, u := range s.uploads {
+		if u.bucket == bucketName {
+			s.discardUpload(uploadID, u)
+		}
+	}
+}
+
+// expireUploads discards all uploads started more than store.UploadMaxAge ago and returns their number.
+// It needs to be called with the write lock held.
+func (s *Store) expireUploads(now time.Time) uint {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	var expired uint
+	for uploadID, u := range s.uploads {
+		if now.Sub(u.created) > store.UploadMaxAge {
+			s.discardUpload(uploadID, u)
+			expired++
+		}
+	}
+
+	return expired
+}
+
+// discardUpload removes the upload and its directory. It needs to be called with the upload lock held.
+func (s *Store) discardUpload(uploadID string, u *upload) {
+	delete(s.uploads, uploadID)
+	if err := os.RemoveAll(u.dir); err != nil {
+		s.log.Errorf("Error removing upload directory %s: %s", u.dir, err)
+	}
+}
+
+func partPath(uploadDir string, number int) string {
+	return filepath.Join(uploadDir, strconv.Itoa(number))
+}
@@ -0,0 +1,152 @@
+package disk
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), nil)
+	uploadID, err := s.CreateUpload("test-bucket", "test-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	for _, p := range []struct {
+		number  int
+		content string
+	}{
+		{2, "content"},
+		{1, "test-"},
+	} {
+		if _, err := s.PutPart("test-bucket", "test-object", uploadID, p.number, strings.NewReader(p.content)); err != nil {
+			t.Fatalf("can not put part %d: %s", p.number, err)
+		}
+	}
+
+	if _, err := s.CompleteUpload("test-bucket", "test-object", uploadID, []store.Part{
+		{Number: 1},
+		{Number: 3},
+	}); err != store.ErrInvalidPart {
+		t.Errorf("got error %q, want %q", err, store.ErrInvalidPart)
+	}
+
+	if _, err := s.CompleteUpload("test-bucket", "test-object", uploadID, nil); err != nil {
+		t.Fatalf("can not complete upload: %s", err)
+	}
+
+	reader, _, err := s.Get("test-bucket", "test-object", store.GetOptions{})
+	if err != nil {
+		t.Fatalf("can not get object: %s", err)
+	}
+
+	if content := testutil.ReadAll(t, reader); content != "test-content" {
+		t.Errorf("got content %q, want %q", content, "test-content")
+	}
+
+	if _, err := os.Stat(filepath.Join(s.dir, uploadsDir, uploadID)); !os.IsNotExist(err) {
+		t.Errorf("upload directory still exists: %v", err)
+	}
+}
+
+func TestUploadRestart(t *testing.T) {
+	dir := t.TempDir()
+	before := newTestStore(t, dir, nil)
+	uploadID, err := before.CreateUpload("test-bucket", "test-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	if _, err := before.PutPart("test-bucket", "test-object", uploadID, 1, strings.NewReader("content")); err != nil {
+		t.Fatalf("can not put part: %s", err)
+	}
+
+	after := newTestStore(t, dir, nil)
+	if _, err := after.ListParts("test-bucket", "test-object", uploadID); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("can not read directory: %s", err)
+	}
+
+	if len(entries) != 0 {
+		t.Errorf("got %d entries in data directory, want none", len(entries))
+	}
+}
+
+func TestUploadDeleteBucket(t *testing.T) {
+	s := newTestStore(t, t.TempDir(), nil)
+	if err := s.CreateBucket("test-bucket", store.BucketOptions{}); err != nil {
+		t.Fatalf("can not create bucket: %s", err)
+	}
+
+	uploadID, err := s.CreateUpload("test-bucket", "test-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	if _, err := s.PutPart("test-bucket", "test-object", uploadID, 1, strings.NewReader("content")); err != nil {
+		t.Fatalf("can not put part: %s", err)
+	}
+
+	if err := s.DeleteBucket("test-bucket", false); err != nil {
+		t.Fatalf("can not delete bucket: %s", err)
+	}
+
+	if _, err := s.CompleteUpload("test-bucket", "test-object", uploadID, nil); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	if s.BucketExists("test-bucket") {
+		t.Errorf("upload completed into deleted bucket")
+	}
+
+	if _, err := os.Stat(filepath.Join(s.dir, uploadsDir, uploadID)); !os.IsNotExist(err) {
+		t.Errorf("upload directory still exists: %v", err)
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	now := time.Date(2021, 6, 1, 11, 0, 0, 0, time.UTC)
+	s := newTestStore(t, t.TempDir(), nil)
+	s.now = func() time.Time {
+		return now
+	}
+
+	oldID, err := s.CreateUpload("test-bucket", "old-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	now = now.Add(store.UploadMaxAge)
+	newID, err := s.CreateUpload("test-bucket", "new-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := s.ExpireObjects(); err != nil {
+		t.Fatalf("can not expire objects: %s", err)
+	}
+
+	if _, err := s.ListParts("test-bucket", "old-object", oldID); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	if _, err := os.Stat(filepath.Join(s.dir, uploadsDir, oldID)); !os.IsNotExist(err) {
+		t.Errorf("upload directory still exists: %v", err)
+	}
+
+	if _, err := s.ListParts("test-bucket", "new-object", newID); err != nil {
+		t.Errorf("can not list parts of new upload: %s", err)
+	}
+}
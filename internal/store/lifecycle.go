@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrInvalidRule is returned when a lifecycle rule can not be applied.
+	ErrInvalidRule = errors.New("invalid lifecycle rule")
+)
+
+// LifecycleRule expires the objects of a bucket a number of days after they have been modified.
+type LifecycleRule struct {
+	// Prefix limits the rule to objects with IDs starting with it. The rule applies to all objects if it is empty.
+	Prefix string `json:"prefix,omitempty"`
+	Days   int    `json:"days"`
+}
+
+// ValidateRules checks that all rules expire objects after at least one day.
+func ValidateRules(rules []LifecycleRule) error {
+	for _, r := range rules {
+		if r.Days < 1 {
+			return ErrInvalidRule
+		}
+	}
+
+	return nil
+}
+
+// Expired returns true if the object has expired at now, either by its TTL or by one of the rules of its bucket.
+func Expired(objectID string, meta Metadata, rules []LifecycleRule, now time.Time) bool {
+	if meta.Expires != nil && !now.Before(*meta.Expires) {
+		return true
+	}
+
+	for _, r := range rules {
+		if !strings.HasPrefix(objectID, r.Prefix) {
+			continue
+		}
+
+		if !now.Before(meta.Modified.AddDate(0, 0, r.Days)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RunReaper removes expired objects from the store every interval until the context is cancelled.
+func RunReaper(ctx context.Context, log logrus.FieldLogger, s Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := s.ExpireObjects()
+		if err != nil {
+			log.Errorf("Error expiring objects: %s", err)
+			continue
+		}
+
+		if expired > 0 {
+			log.Debugf("Expired %d objects.", expired)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestExpired(t *testing.T) {
+	modified := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	expires := modified.Add(time.Hour)
+
+	tt := []struct {
+		desc        string
+		objectID    string
+		meta        Metadata
+		rules       []LifecycleRule
+		now         time.Time
+		wantExpired bool
+	}{
+		{
+			desc:     "no expiration",
+			objectID: "test-object",
+			meta: Metadata{
+				Modified: modified,
+			},
+			now:         modified.AddDate(1, 0, 0),
+			wantExpired: false,
+		},
+		{
+			desc:     "ttl not reached",
+			objectID: "test-object",
+			meta: Metadata{
+				Modified: modified,
+				Expires:  &expires,
+			},
+			now:         expires.Add(-time.Second),
+			wantExpired: false,
+		},
+		{
+			desc:     "ttl reached",
+			objectID: "test-object",
+			meta: Metadata{
+				Modified: modified,
+				Expires:  &expires,
+			},
+			now:         expires,
+			wantExpired: true,
+		},
+		{
+			desc:     "rule not reached",
+			objectID: "test-object",
+			meta: Metadata{
+				Modified: modified,
+			},
+			rules: []LifecycleRule{
+				{Days: 2},
+			},
+			now:         modified.AddDate(0, 0, 1),
+			wantExpired: false,
+		},
+		{
+			desc:     "rule reached",
+			objectID: "test-object",
+			meta: Metadata{
+				Modified: modified,
+			},
+			rules: []LifecycleRule{
+				{Days: 2},
+			},
+			now:         modified.AddDate(0, 0, 2),
+			wantExpired: true,
+		},
+		{
+			desc:     "other prefix",
+			objectID: "test-object",
+			meta: Metadata{
+				Modified: modified,
+			},
+			rules: []LifecycleRule{
+				{Prefix: "tmp/", Days: 1},
+			},
+			now:         modified.AddDate(0, 0, 2),
+			wantExpired: false,
+		},
+		{
+			desc:     "matching prefix",
+			objectID: "tmp/test-object",
+			meta: Metadata{
+				Modified: modified,
+			},
+			rules: []LifecycleRule{
+				{Days: 30},
+				{Prefix: "tmp/", Days: 1},
+			},
+			now:         modified.AddDate(0, 0, 2),
+			wantExpired: true,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			expired := Expired(tc.objectID, tc.meta, tc.rules, tc.now)
+			if expired != tc.wantExpired {
+				t.Errorf("got expired %v, want %v", expired, tc.wantExpired)
+			}
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	tt := []struct {
+		desc    string
+		rules   []LifecycleRule
+		wantErr error
+	}{
+		{
+			desc:  "no rules",
+			rules: nil,
+		},
+		{
+			desc: "valid",
+			rules: []LifecycleRule{
+				{Prefix: "tmp/", Days: 1},
+			},
+		},
+		{
+			desc: "zero days",
+			rules: []LifecycleRule{
+				{Days: 1},
+				{Prefix: "tmp/", Days: 0},
+			},
+			wantErr: ErrInvalidRule,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateRules(tc.rules)
+			if err != tc.wantErr {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxKeys is the number of entries returned by a listing if no other limit is requested.
+const DefaultMaxKeys = 1000
+
+var (
+	// ErrInvalidToken is returned when a listing is continued using a token which can not be decoded.
+	ErrInvalidToken = errors.New("invalid continuation token")
+)
+
+// ListOptions controls which objects of a bucket are returned by a listing.
+type ListOptions struct {
+	// Prefix limits the listing to object IDs starting with the prefix.
+	Prefix string
+	// Delimiter groups all object IDs which contain the delimiter after the prefix into a common prefix.
+	Delimiter string
+	// MaxKeys is the maximum number of objects and common prefixes returned. Defaults to DefaultMaxKeys.
+	MaxKeys int
+	// ContinuationToken continues a previous truncated listing.
+	ContinuationToken string
+	// StartAfter starts the listing after this object ID. If it is a common prefix, the whole group is skipped.
+	// The continuation token takes precedence if it is after StartAfter.
+	StartAfter string
+}
+
+// ListResult contains one page of a listing.
+type ListResult struct {
+	Objects               []string `json:"objects"`
+	CommonPrefixes        []string `json:"commonPrefixes,omitempty"`
+	Truncated             bool     `json:"truncated"`
+	NextContinuationToken string   `json:"nextContinuationToken,omitempty"`
+}
+
+// ListObjectIDs creates a listing from all object IDs of a bucket. The IDs are sorted in place.
+func ListObjectIDs(ids []string, opts ListOptions) (ListResult, error) {
+	marker, err := decodeToken(opts.ContinuationToken)
+	if err != nil {
+		return ListResult{}, err
+	}
+
+	maxKeys := opts.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = DefaultMaxKeys
+	}
+
+	sort.Strings(ids)
+	start := sort.SearchStrings(ids, opts.Prefix)
+	if opts.StartAfter > marker {
+		marker = opts.StartAfter
+		if isCommonPrefix(marker, opts) {
+			// The group of the common prefix has already been returned, so all IDs starting with it are skipped.
+			if afterGroup := sort.Search(len(ids), func(i int) bool {
+				return ids[i] > marker && !strings.HasPrefix(ids[i], marker)
+			}); afterGroup > start {
+				start = afterGroup
+			}
+		}
+	}
+	if marker != "" {
+		if afterMarker := sort.Search(len(ids), func(i int) bool { return ids[i] > marker }); afterMarker > start {
+			start = afterMarker
+		}
+	}
+
+	result := ListResult{
+		Objects: []string{},
+	}
+	count := 0
+	last := ""
+	for i := start; i < len(ids); i++ {
+		id := ids[i]
+		if !strings.HasPrefix(id, opts.Prefix) {
+			break
+		}
+
+		if count == maxKeys {
+			result.Truncated = true
+			result.NextContinuationToken = encodeToken(last)
+			break
+		}
+		count++
+
+		if opts.Delimiter != "" {
+			if idx := strings.Index(id[len(opts.Prefix):], opts.Delimiter); idx >= 0 {
+				commonPrefix := id[:len(opts.Prefix)+idx+len(opts.Delimiter)]
+				result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix)
+
+				// Skip the rest of the group, the last ID of it is used for continuing the listing.
+				for i+1 < len(ids) && strings.HasPrefix(ids[i+1], commonPrefix) {
+					i++
+				}
+				last = ids[i]
+				continue
+			}
+		}
+
+		result.Objects = append(result.Objects, id)
+		last = id
+	}
+
+	return result, nil
+}
+
+// isCommonPrefix returns true if the ID would be a common prefix of the listing.
+func isCommonPrefix(id string, opts ListOptions) bool {
+	if opts.Delimiter == "" || !strings.HasPrefix(id, opts.Prefix) {
+		return false
+	}
+
+	rest := id[len(opts.Prefix):]
+	return strings.Index(rest, opts.Delimiter) == len(rest)-len(opts.Delimiter)
+}
+
+func encodeToken(marker string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(marker))
+}
+
+func decodeToken(token string) (string, error) {
+	marker, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	return string(marker), nil
+}
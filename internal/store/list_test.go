@@ -0,0 +1,232 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestListObjectIDs(t *testing.T) {
+	ids := []string{
+		"photos/2021/b.jpg",
+		"readme.txt",
+		"photos/2021/a.jpg",
+		"photos/2022/c.jpg",
+		"photos/index.html",
+		"notes.txt",
+	}
+
+	tt := []struct {
+		desc       string
+		opts       ListOptions
+		wantResult ListResult
+		wantErr    error
+	}{
+		{
+			desc: "all",
+			opts: ListOptions{},
+			wantResult: ListResult{
+				Objects: []string{
+					"notes.txt",
+					"photos/2021/a.jpg",
+					"photos/2021/b.jpg",
+					"photos/2022/c.jpg",
+					"photos/index.html",
+					"readme.txt",
+				},
+			},
+		},
+		{
+			desc: "prefix",
+			opts: ListOptions{
+				Prefix: "photos/2021/",
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/2021/a.jpg",
+					"photos/2021/b.jpg",
+				},
+			},
+		},
+		{
+			desc: "delimiter",
+			opts: ListOptions{
+				Delimiter: "/",
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"notes.txt",
+					"readme.txt",
+				},
+				CommonPrefixes: []string{
+					"photos/",
+				},
+			},
+		},
+		{
+			desc: "prefix and delimiter",
+			opts: ListOptions{
+				Prefix:    "photos/",
+				Delimiter: "/",
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/index.html",
+				},
+				CommonPrefixes: []string{
+					"photos/2021/",
+					"photos/2022/",
+				},
+			},
+		},
+		{
+			desc: "truncated",
+			opts: ListOptions{
+				MaxKeys: 2,
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"notes.txt",
+					"photos/2021/a.jpg",
+				},
+				Truncated:             true,
+				NextContinuationToken: encodeToken("photos/2021/a.jpg"),
+			},
+		},
+		{
+			desc: "continued",
+			opts: ListOptions{
+				MaxKeys:           2,
+				ContinuationToken: encodeToken("photos/2021/a.jpg"),
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/2021/b.jpg",
+					"photos/2022/c.jpg",
+				},
+				Truncated:             true,
+				NextContinuationToken: encodeToken("photos/2022/c.jpg"),
+			},
+		},
+		{
+			desc: "truncated after common prefix",
+			opts: ListOptions{
+				Prefix:    "photos/",
+				Delimiter: "/",
+				MaxKeys:   1,
+			},
+			wantResult: ListResult{
+				Objects: []string{},
+				CommonPrefixes: []string{
+					"photos/2021/",
+				},
+				Truncated:             true,
+				NextContinuationToken: encodeToken("photos/2021/b.jpg"),
+			},
+		},
+		{
+			desc: "continued after common prefix",
+			opts: ListOptions{
+				Prefix:            "photos/",
+				Delimiter:         "/",
+				MaxKeys:           2,
+				ContinuationToken: encodeToken("photos/2021/b.jpg"),
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/index.html",
+				},
+				CommonPrefixes: []string{
+					"photos/2022/",
+				},
+			},
+		},
+		{
+			desc: "start after",
+			opts: ListOptions{
+				StartAfter: "photos/2021/a.jpg",
+				MaxKeys:    1,
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/2021/b.jpg",
+				},
+				Truncated:             true,
+				NextContinuationToken: encodeToken("photos/2021/b.jpg"),
+			},
+		},
+		{
+			desc: "start after common prefix",
+			opts: ListOptions{
+				Prefix:     "photos/",
+				Delimiter:  "/",
+				StartAfter: "photos/2021/",
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/index.html",
+				},
+				CommonPrefixes: []string{
+					"photos/2022/",
+				},
+			},
+		},
+		{
+			desc: "continuation token after start after",
+			opts: ListOptions{
+				StartAfter:        "notes.txt",
+				ContinuationToken: encodeToken("photos/2021/b.jpg"),
+				MaxKeys:           1,
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/2022/c.jpg",
+				},
+				Truncated:             true,
+				NextContinuationToken: encodeToken("photos/2022/c.jpg"),
+			},
+		},
+		{
+			desc: "exactly max keys",
+			opts: ListOptions{
+				Prefix:  "photos/2021/",
+				MaxKeys: 2,
+			},
+			wantResult: ListResult{
+				Objects: []string{
+					"photos/2021/a.jpg",
+					"photos/2021/b.jpg",
+				},
+			},
+		},
+		{
+			desc: "invalid token",
+			opts: ListOptions{
+				ContinuationToken: "!!!",
+			},
+			wantErr: ErrInvalidToken,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			input := append([]string{}, ids...)
+			result, err := ListObjectIDs(input, tc.opts)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(result, tc.wantResult); diff != "" {
+				t.Errorf("result differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
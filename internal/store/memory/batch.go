@@ -0,0 +1,220 @@
+package memory
+
+import (
+	"strings"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (s *Store) Batch(bucketName string, ops []store.BatchOperation, atomic bool) ([]store.BatchResult, error) {
+	if err := store.ValidateBatch(ops, atomic); err != nil {
+		return nil, err
+	}
+
+	// The contents are split and digested before acquiring the lock like in Put.
+	s.bucketMutex.RLock()
+	digester, verify := s.bucketDigester(s.buckets[bucketName])
+	s.bucketMutex.RUnlock()
+
+	results := make([]store.BatchResult, len(ops))
+	contents := make([]preparedContent, len(ops))
+	for i, op := range ops {
+		if op.Op == store.BatchPut {
+			contents[i], results[i].Err = s.prepareContent(digester, op.Content)
+		}
+	}
+
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	if atomic {
+		if !s.checkBatch(bucketName, ops, contents, verify, results) {
+			store.AbortBatch(results)
+			return results, nil
+		}
+
+		s.applyAtomicBatch(bucketName, ops, contents, results)
+		s.compact()
+		return results, nil
+	}
+
+	for i, op := range ops {
+		if results[i].Err != nil {
+			continue
+		}
+
+		results[i] = s.applyBatchOperation(bucketName, op, contents[i], verify)
+	}
+
+	s.compact()
+	return results, nil
+}
+
+// checkBatch checks that all operations of an atomic batch can be applied. The contents of all puts need to fit into
+// the quota at once, so that no objects are evicted while the batch is applied, and may not collide with each other or
+// existing contents. Returns false if the batch needs to be aborted, with the error set in the result of the failing
+// operation. It needs to be called with the lock held.
+func (s *Store) checkBatch(bucketName string, ops []store.BatchOperation, contents []preparedContent, verify bool, results []store.BatchResult) bool {
+	for _, r := range results {
+		if r.Err != nil {
+			return false
+		}
+	}
+
+	digests := make([]digest.Digest, len(ops))
+	var chunks []chunk
+	for i, c := range contents {
+		digests[i] = c.digest
+		chunks = append(chunks, c.chunks...)
+	}
+
+	if i, err := store.CheckBatch(ops, digests, func(objectID string) *store.Metadata {
+		return s.current(bucketName, objectID)
+	}); err != nil {
+		results[i].Err = err
+		return false
+	}
+
+	if verify {
+		if err := s.checkCollisions(s.buckets[bucketName], chunks); err != nil {
+			for i, op := range ops {
+				if op.Op == store.BatchPut {
+					results[i].Err = err
+				}
+			}
+			return false
+		}
+	}
+
+	if s.quota == nil {
+		return true
+	}
+
+	bucketBytes, globalBytes := s.required(s.buckets[bucketName], chunks)
+	if overBucket, overGlobal := s.overQuota(bucketName, bucketBytes, globalBytes); overBucket || overGlobal {
+		for i, op := range ops {
+			if op.Op == store.BatchPut {
+				results[i].Err = store.ErrInsufficientStorage
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// applyBatchOperation applies a single operation of a batch. It needs to be called with the lock held.
+func (s *Store) applyBatchOperation(bucketName string, op store.BatchOperation, c preparedContent, verify bool) store.BatchResult {
+	switch op.Op {
+	case store.BatchPut:
+		meta, err := s.putContent(bucketName, op.ObjectID, c, verify, op.PutOptions)
+		return store.BatchResult{Metadata: meta, Err: err}
+	case store.BatchDelete:
+		return store.BatchResult{Err: s.deleteWithOptions(bucketName, op.ObjectID, op.DeleteOptions)}
+	default:
+		contents, meta, err := s.getObject(bucketName, op.ObjectID, op.GetOptions)
+		if err != nil {
+			return store.BatchResult{Err: err}
+		}
+
+		return store.BatchResult{Metadata: meta, Content: []byte(strings.Join(contents, ""))}
+	}
+}
+
+// applyAtomicBatch applies all operations of a checked atomic batch. The records of all puts and deletes are created
+// up front and logged as a single record before anything is changed, so that the batch is neither partially applied
+// if writing the log fails nor when the log is replayed. It needs to be called with the lock held.
+func (s *Store) applyAtomicBatch(bucketName string, ops []store.BatchOperation, contents []preparedContent, results []store.BatchResult) {
+	records, err := s.batchRecords(bucketName, ops, contents)
+	if err != nil {
+		store.FailBatch(results, err)
+		return
+	}
+
+	logged := make([]walRecord, 0, len(records))
+	for _, rec := range records {
+		if rec.Op != "" {
+			logged = append(logged, rec)
+		}
+	}
+
+	if len(logged) > 0 {
+		if err := s.logRecord(walRecord{
+			Op:      opBatch,
+			Bucket:  bucketName,
+			Records: logged,
+		}); err != nil {
+			store.FailBatch(results, err)
+			return
+		}
+	}
+
+	for i, op := range ops {
+		rec := records[i]
+		switch op.Op {
+		case store.BatchPut:
+			s.putObject(bucketName, op.ObjectID, contents[i].chunks, *rec.Metadata)
+			s.touch(s.buckets[bucketName], op.ObjectID)
+			results[i] = store.BatchResult{Metadata: *rec.Metadata}
+		case store.BatchDelete:
+			s.removeObject(s.buckets[bucketName], op.ObjectID, rec.Metadata)
+		default:
+			results[i] = s.applyBatchOperation(bucketName, op, contents[i], false)
+		}
+	}
+}
+
+// batchRecords creates the log records of the puts and deletes of an atomic batch. Each record is created for the
+// objects as they are left by the previous operations. The records of gets are empty.
+func (s *Store) batchRecords(bucketName string, ops []store.BatchOperation, contents []preparedContent) ([]walRecord, error) {
+	b, ok := s.buckets[bucketName]
+	versioning := ok && b.versioning
+
+	objects := make(map[string]*store.Metadata)
+	records := make([]walRecord, len(ops))
+	for i, op := range ops {
+		current, ok := objects[op.ObjectID]
+		if !ok {
+			current = s.current(bucketName, op.ObjectID)
+		}
+
+		switch op.Op {
+		case store.BatchPut:
+			c := contents[i]
+			meta := store.NewMetadata(op.PutOptions, int64(len(c.data)), c.digest, s.now(), current)
+			if versioning {
+				var err error
+				meta.VersionID, err = store.NewVersionID()
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			records[i] = walRecord{
+				Op:       opPut,
+				Bucket:   bucketName,
+				ObjectID: op.ObjectID,
+				Content:  c.data,
+				Metadata: &meta,
+			}
+			objects[op.ObjectID] = &meta
+		case store.BatchDelete:
+			marker, err := s.deleteMarker(versioning, current)
+			if err != nil {
+				return nil, err
+			}
+
+			records[i] = walRecord{
+				Op:       opDelete,
+				Bucket:   bucketName,
+				ObjectID: op.ObjectID,
+				Metadata: marker,
+			}
+			objects[op.ObjectID] = nil
+		default:
+		}
+	}
+
+	return records, nil
+}
@@ -0,0 +1,302 @@
+package memory
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestBatch(t *testing.T) {
+	tt := []struct {
+		desc         string
+		opts         []Option
+		ops          []store.BatchOperation
+		atomic       bool
+		wantErr      error
+		wantErrs     []error
+		wantContents []string
+		wantObjects  map[string]string
+	}{
+		{
+			desc: "mixed operations",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchGet, ObjectID: "existing-object"},
+				{Op: store.BatchDelete, ObjectID: "existing-object"},
+				{Op: store.BatchGet, ObjectID: "existing-object"},
+			},
+			wantErrs:     []error{nil, nil, nil, store.ErrNotFound},
+			wantContents: []string{"", "existing-content", "", ""},
+			wantObjects:  map[string]string{"new-object": "new-content", "existing-object": ""},
+		},
+		{
+			desc: "read own put",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "existing-object", Content: []byte("new-content")},
+				{Op: store.BatchGet, ObjectID: "existing-object"},
+			},
+			wantErrs:     []error{nil, nil},
+			wantContents: []string{"", "new-content"},
+			wantObjects:  map[string]string{"existing-object": "new-content"},
+		},
+		{
+			desc: "continue after failure",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "existing-object", Content: []byte("new-content"), PutOptions: store.PutOptions{
+					Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+				}},
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+			},
+			wantErrs:    []error{store.ErrPreconditionFailed, nil},
+			wantObjects: map[string]string{"existing-object": "existing-content", "new-object": "new-content"},
+		},
+		{
+			desc: "atomic",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchDelete, ObjectID: "existing-object"},
+			},
+			atomic:      true,
+			wantErrs:    []error{nil, nil},
+			wantObjects: map[string]string{"new-object": "new-content", "existing-object": ""},
+		},
+		{
+			desc: "atomic object not found",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchDelete, ObjectID: "missing-object"},
+			},
+			atomic:      true,
+			wantErrs:    []error{store.ErrBatchAborted, store.ErrNotFound},
+			wantObjects: map[string]string{"new-object": "", "existing-object": "existing-content"},
+		},
+		{
+			desc: "atomic condition after put",
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("other-content"), PutOptions: store.PutOptions{
+					Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+				}},
+			},
+			atomic:      true,
+			wantErrs:    []error{store.ErrBatchAborted, store.ErrPreconditionFailed},
+			wantObjects: map[string]string{"new-object": ""},
+		},
+		{
+			desc: "atomic get after delete",
+			ops: []store.BatchOperation{
+				{Op: store.BatchDelete, ObjectID: "existing-object"},
+				{Op: store.BatchGet, ObjectID: "existing-object"},
+			},
+			atomic:      true,
+			wantErrs:    []error{store.ErrBatchAborted, store.ErrNotFound},
+			wantObjects: map[string]string{"existing-object": "existing-content"},
+		},
+		{
+			desc: "evict for put",
+			opts: []Option{WithQuota(Quota{Limit: 30, Policy: EvictLRU})},
+			ops: []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content-0123")},
+			},
+			wantErrs:    []error{nil},
+			wantObjects: map[string]string{"new-object": "new-content-0123", "existing-object": ""},
+		},
+		{
+			desc: "atomic does not evict",
+			opts: []Option{WithQuota(Quota{Limit: 30, Policy: EvictLRU})},
+			ops: []store.BatchOperation{
+				{Op: store.BatchGet, ObjectID: "existing-object"},
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content-0123")},
+			},
+			atomic:      true,
+			wantErrs:    []error{store.ErrBatchAborted, store.ErrInsufficientStorage},
+			wantObjects: map[string]string{"new-object": "", "existing-object": "existing-content"},
+		},
+		{
+			desc: "unknown operation",
+			ops: []store.BatchOperation{
+				{Op: "copy", ObjectID: "new-object"},
+			},
+			wantErr: errors.New(`unknown operation "copy" at index 0`),
+		},
+		{
+			desc: "atomic version",
+			ops: []store.BatchOperation{
+				{Op: store.BatchDelete, ObjectID: "existing-object", DeleteOptions: store.DeleteOptions{VersionID: "test-version"}},
+			},
+			atomic:  true,
+			wantErr: errors.New("can not select version in atomic batch at index 0"),
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log, tc.opts...)
+			applyOps(t, s, []walOp{
+				{opPut, "test-bucket", "existing-object", "existing-content"},
+			})
+
+			results, err := s.Batch("test-bucket", tc.ops, tc.atomic)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if len(results) != len(tc.wantErrs) {
+				t.Fatalf("got %d results, want %d", len(results), len(tc.wantErrs))
+			}
+
+			for i, r := range results {
+				if !testutil.EqualErrorMessage(r.Err, tc.wantErrs[i]) {
+					t.Errorf("got error %q for operation %d, want %q", r.Err, i, tc.wantErrs[i])
+				}
+
+				if tc.wantContents != nil && string(r.Content) != tc.wantContents[i] {
+					t.Errorf("got content %q for operation %d, want %q", r.Content, i, tc.wantContents[i])
+				}
+			}
+
+			for objectID, want := range tc.wantObjects {
+				reader, _, err := s.Get("test-bucket", objectID, store.GetOptions{})
+				if want == "" {
+					if err != store.ErrNotFound {
+						t.Errorf("got error %q for %s, want %q", err, objectID, store.ErrNotFound)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("can not get %s: %s", objectID, err)
+				}
+
+				if got := testutil.ReadAll(t, reader); got != want {
+					t.Errorf("got content %q for %s, want %q", got, objectID, want)
+				}
+			}
+		})
+	}
+}
+
+var errLogFailed = errors.New("log failed")
+
+// failingLogFile fails to write after the given number of writes.
+type failingLogFile struct {
+	logFile
+	writes int
+}
+
+func (f *failingLogFile) Write(p []byte) (int, error) {
+	if f.writes == 0 {
+		return 0, errLogFailed
+	}
+
+	f.writes--
+	return f.logFile.Write(p)
+}
+
+// readObject returns the content of the object or an empty string if it does not exist.
+func readObject(t *testing.T, s *Store, objectID string) string {
+	t.Helper()
+
+	reader, _, err := s.Get("test-bucket", objectID, store.GetOptions{})
+	if err == store.ErrNotFound {
+		return ""
+	}
+
+	if err != nil {
+		t.Fatalf("can not get %s: %s", objectID, err)
+	}
+
+	return testutil.ReadAll(t, reader)
+}
+
+func TestAtomicBatchLog(t *testing.T) {
+	tt := []struct {
+		desc        string
+		writes      int
+		wantApplied bool
+	}{
+		{
+			desc:        "log fails",
+			writes:      0,
+			wantApplied: false,
+		},
+		{
+			desc:        "log fails after first record",
+			writes:      1,
+			wantApplied: true,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			s, err := NewPersistentStore(log, dir, 0)
+			if err != nil {
+				t.Fatalf("can not create store: %s", err)
+			}
+
+			applyOps(t, s, []walOp{
+				{opPut, "test-bucket", "existing-object", "existing-content"},
+			})
+
+			file := s.wal.file
+			s.wal.file = &failingLogFile{logFile: file, writes: tc.writes}
+
+			results, err := s.Batch("test-bucket", []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "new-object", Content: []byte("new-content")},
+				{Op: store.BatchGet, ObjectID: "new-object"},
+				{Op: store.BatchDelete, ObjectID: "existing-object"},
+			}, true)
+			if err != nil {
+				t.Fatalf("can not apply batch: %s", err)
+			}
+			s.wal.file = file
+
+			// The batch is either applied completely or not at all.
+			for i, r := range results {
+				if applied := r.Err == nil; applied != tc.wantApplied {
+					t.Errorf("got error %q for operation %d, want applied %v", r.Err, i, tc.wantApplied)
+				}
+			}
+
+			wantObjects := map[string]string{"new-object": "", "existing-object": "existing-content"}
+			if tc.wantApplied {
+				wantObjects = map[string]string{"new-object": "new-content", "existing-object": ""}
+				if got := string(results[1].Content); got != "new-content" {
+					t.Errorf("got content %q for get, want %q", got, "new-content")
+				}
+			}
+
+			if err := s.Close(); err != nil {
+				t.Fatalf("can not close store: %s", err)
+			}
+
+			restored, err := NewPersistentStore(log, dir, 0)
+			if err != nil {
+				t.Fatalf("can not restore store: %s", err)
+			}
+			defer restored.Close()
+
+			for _, s := range []*Store{s, restored} {
+				for objectID, want := range wantObjects {
+					if got := readObject(t, s, objectID); got != want {
+						t.Errorf("got content %q for %s, want %q", got, objectID, want)
+					}
+				}
+			}
+		})
+	}
+}
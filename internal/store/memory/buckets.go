@@ -0,0 +1,90 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (s *Store) CreateBucket(bucketName string, opts store.BucketOptions) error {
+	algorithm, err := bucketAlgorithm(opts.Digest)
+	if err != nil {
+		return err
+	}
+
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	if _, ok := s.buckets[bucketName]; ok {
+		return store.ErrBucketExists
+	}
+
+	if err := s.logRecord(walRecord{
+		Op:     opCreateBucket,
+		Bucket: bucketName,
+		Digest: opts.Digest,
+	}); err != nil {
+		return err
+	}
+
+	b := newBucket()
+	b.algorithm = algorithm
+	s.buckets[bucketName] = b
+	s.compact()
+	return nil
+}
+
+func (s *Store) ListBuckets() ([]string, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (s *Store) DeleteBucket(bucketName string, force bool) error {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	if (len(b.objects) > 0 || len(b.versions) > 0) && !force {
+		return store.ErrBucketNotEmpty
+	}
+
+	if err := s.logRecord(walRecord{
+		Op:     opDeleteBucket,
+		Bucket: bucketName,
+	}); err != nil {
+		return err
+	}
+
+	s.deleteBucket(bucketName)
+	s.removeUploads(bucketName)
+	s.compact()
+	return nil
+}
+
+func (s *Store) deleteBucket(bucketName string) {
+	b := s.buckets[bucketName]
+	for d := range b.contents {
+		s.releaseContent(b, d)
+	}
+	delete(s.buckets, bucketName)
+}
+
+func (s *Store) BucketExists(bucketName string) bool {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	_, ok := s.buckets[bucketName]
+	return ok
+}
@@ -0,0 +1,167 @@
+package memory
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (s *Store) Copy(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	meta, err := s.copyObject(srcBucket, srcObjectID, dstBucket, dstObjectID, opts)
+	if err != nil {
+		return store.Metadata{}, err
+	}
+
+	s.compact()
+	return meta, nil
+}
+
+func (s *Store) Move(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	meta, err := s.copyObject(srcBucket, srcObjectID, dstBucket, dstObjectID, opts)
+	if err != nil {
+		return store.Metadata{}, err
+	}
+
+	switch {
+	case srcBucket == dstBucket && srcObjectID == dstObjectID:
+	case opts.VersionID != "":
+		if err := s.deleteVersion(srcBucket, srcObjectID, opts.VersionID); err != nil {
+			return store.Metadata{}, err
+		}
+	case s.current(srcBucket, srcObjectID) != nil:
+		// The source is missing if it has been evicted to make room for the copy.
+		if err := s.deleteCurrent(srcBucket, srcObjectID); err != nil {
+			return store.Metadata{}, err
+		}
+	default:
+	}
+
+	s.compact()
+	return meta, nil
+}
+
+// copyObject saves a copy of the source object. It needs to be called with the lock held.
+func (s *Store) copyObject(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	src, ok := s.buckets[srcBucket]
+	if !ok {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	keys, source, ok := src.object(srcObjectID, opts.VersionID)
+	if !ok {
+		return store.Metadata{}, store.ErrNotFound
+	}
+
+	previous := s.current(dstBucket, dstObjectID)
+	if err := opts.Condition.Check(previous); err != nil {
+		return store.Metadata{}, err
+	}
+
+	chunks, d, err := s.copyChunks(src, keys, source.Digest, s.buckets[dstBucket])
+	if err != nil {
+		return store.Metadata{}, err
+	}
+
+	// Copies within the bucket share all chunks, so they do not need to be reserved.
+	rec := walRecord{
+		Op:        opCopy,
+		Bucket:    dstBucket,
+		ObjectID:  dstObjectID,
+		Source:    srcObjectID,
+		VersionID: opts.VersionID,
+	}
+	if srcBucket != dstBucket {
+		if err := s.reserve(dstBucket, dstObjectID, chunks); err != nil {
+			return store.Metadata{}, err
+		}
+
+		// Copies to other buckets are logged with their content, because the source can be removed by the quota.
+		rec = walRecord{
+			Op:       opPut,
+			Bucket:   dstBucket,
+			ObjectID: dstObjectID,
+			Content:  []byte(joinChunks(chunks)),
+		}
+	}
+
+	meta := store.CopyMetadata(source, s.now(), previous)
+	meta.Digest = d
+	if b, ok := s.buckets[dstBucket]; ok && b.versioning {
+		meta.VersionID, err = store.NewVersionID()
+		if err != nil {
+			return store.Metadata{}, err
+		}
+	}
+
+	rec.Metadata = &meta
+	if err := s.logRecord(rec); err != nil {
+		return store.Metadata{}, err
+	}
+
+	s.putObject(dstBucket, dstObjectID, chunks, meta)
+	s.touch(s.buckets[dstBucket], dstObjectID)
+	return meta, nil
+}
+
+// copyChunks returns the chunks of the source object as they are stored in the destination bucket and the digest of
+// the copy. Within a bucket the chunks are shared. Other buckets reuse the digests, unless they use a different digest
+// algorithm, and reject contents colliding with their own. The destination bucket is nil if it does not exist yet.
+func (s *Store) copyChunks(src *bucket, keys []digest.Digest, d digest.Digest, dst *bucket) ([]chunk, digest.Digest, error) {
+	chunks := make([]chunk, 0, len(keys))
+	for _, key := range keys {
+		content, ok := src.contents[key]
+		if !ok {
+			return nil, "", fmt.Errorf("can not find content with digest %q", key)
+		}
+
+		chunks = append(chunks, chunk{
+			digest:  key,
+			content: content,
+		})
+	}
+
+	if src == dst {
+		return chunks, d, nil
+	}
+
+	digester, verify := s.bucketDigester(dst)
+	if algorithmName(src) != algorithmName(dst) {
+		data := []byte(joinChunks(chunks))
+
+		var err error
+		chunks, err = s.split(digester, data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		d, err = s.objectDigest(digester, data, chunks)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	if verify {
+		if err := s.checkCollisions(dst, chunks); err != nil {
+			return nil, "", err
+		}
+	}
+
+	return chunks, d, nil
+}
+
+func joinChunks(chunks []chunk) string {
+	var b strings.Builder
+	for _, c := range chunks {
+		b.WriteString(c.content)
+	}
+
+	return b.String()
+}
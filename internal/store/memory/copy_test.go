@@ -0,0 +1,283 @@
+package memory
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestCopy(t *testing.T) {
+	tt := []struct {
+		desc          string
+		move          bool
+		dstBucket     string
+		dstObjectID   string
+		srcObjectID   string
+		opts          store.CopyOptions
+		wantErr       error
+		wantAlgorithm string
+		wantSource    bool
+		wantContents  map[string]uint
+	}{
+		{
+			desc:          "within bucket",
+			dstBucket:     "test-bucket",
+			dstObjectID:   "copied-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantContents:  map[string]uint{"test-bucket": 2},
+		},
+		{
+			desc:          "other bucket",
+			dstBucket:     "other-bucket",
+			dstObjectID:   "copied-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantContents:  map[string]uint{"test-bucket": 2, "other-bucket": 1},
+		},
+		{
+			desc:          "bucket with other algorithm",
+			dstBucket:     "blake-bucket",
+			dstObjectID:   "copied-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: "blake3",
+			wantSource:    true,
+			wantContents:  map[string]uint{"test-bucket": 2, "blake-bucket": 1},
+		},
+		{
+			desc:        "source not found",
+			dstBucket:   "test-bucket",
+			dstObjectID: "copied-object",
+			srcObjectID: "missing-object",
+			wantErr:     store.ErrNotFound,
+		},
+		{
+			desc:        "precondition failed",
+			dstBucket:   "test-bucket",
+			dstObjectID: "other-object",
+			srcObjectID: "test-object",
+			opts: store.CopyOptions{
+				Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+		{
+			desc:          "overwrite",
+			dstBucket:     "test-bucket",
+			dstObjectID:   "other-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantContents:  map[string]uint{"test-bucket": 1},
+		},
+		{
+			desc:          "move within bucket",
+			move:          true,
+			dstBucket:     "test-bucket",
+			dstObjectID:   "moved-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantContents:  map[string]uint{"test-bucket": 2},
+		},
+		{
+			desc:          "move to other bucket",
+			move:          true,
+			dstBucket:     "other-bucket",
+			dstObjectID:   "moved-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantContents:  map[string]uint{"test-bucket": 1, "other-bucket": 1},
+		},
+		{
+			desc:          "move onto itself",
+			move:          true,
+			dstBucket:     "test-bucket",
+			dstObjectID:   "test-object",
+			srcObjectID:   "test-object",
+			wantAlgorithm: digest.DefaultAlgorithm,
+			wantSource:    true,
+			wantContents:  map[string]uint{"test-bucket": 2},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log)
+			if err := s.CreateBucket("blake-bucket", store.BucketOptions{Digest: "blake3"}); err != nil {
+				t.Fatalf("can not create bucket: %s", err)
+			}
+			if _, err := s.Put("test-bucket", "test-object", strings.NewReader("test-content"), store.PutOptions{
+				ContentType:  "text/plain",
+				UserMetadata: map[string]string{"Author": "test-author"},
+			}); err != nil {
+				t.Fatalf("can not put object: %s", err)
+			}
+			applyOps(t, s, []walOp{
+				{opPut, "test-bucket", "other-object", "other-content"},
+			})
+
+			copyObject := s.Copy
+			if tc.move {
+				copyObject = s.Move
+			}
+
+			meta, err := copyObject("test-bucket", tc.srcObjectID, tc.dstBucket, tc.dstObjectID, tc.opts)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if meta.ContentType != "text/plain" || meta.User["Author"] != "test-author" {
+				t.Errorf("got content type %q and user metadata %v, want metadata of source", meta.ContentType, meta.User)
+			}
+
+			if got := meta.Digest.Algorithm(); got != tc.wantAlgorithm {
+				t.Errorf("got algorithm %q, want %q", got, tc.wantAlgorithm)
+			}
+
+			reader, _, err := s.Get(tc.dstBucket, tc.dstObjectID, store.GetOptions{})
+			if err != nil {
+				t.Fatalf("can not get copy: %s", err)
+			}
+
+			if content := testutil.ReadAll(t, reader); content != "test-content" {
+				t.Errorf("got content %q, want %q", content, "test-content")
+			}
+
+			if _, err := s.Head("test-bucket", tc.srcObjectID, store.GetOptions{}); (err == nil) != tc.wantSource {
+				t.Errorf("got error %q for source, want source to exist: %v", err, tc.wantSource)
+			}
+
+			for bucket, want := range tc.wantContents {
+				if got := s.Stats().Buckets[bucket].NumContents; got != want {
+					t.Errorf("got %d contents in %s, want %d", got, bucket, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyRestart(t *testing.T) {
+	dir := t.TempDir()
+	before, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	if err := before.CreateBucket("blake-bucket", store.BucketOptions{Digest: "blake3"}); err != nil {
+		t.Fatalf("can not create bucket: %s", err)
+	}
+	applyOps(t, before, []walOp{
+		{opCreateBucket, "versioned-bucket", "", ""},
+		{opVersioning, "versioned-bucket", "", ""},
+		{opPut, "versioned-bucket", "test-object", "previous-content"},
+		{opPut, "versioned-bucket", "test-object", "test-content"},
+		{opPut, "test-bucket", "test-object", "test-content"},
+		{opPut, "test-bucket", "moved-object", "moved-content"},
+	})
+
+	versions, err := before.ListVersions("versioned-bucket", "")
+	if err != nil {
+		t.Fatalf("can not list versions: %s", err)
+	}
+
+	copies := []struct {
+		move        bool
+		srcBucket   string
+		srcObjectID string
+		dstBucket   string
+		dstObjectID string
+		opts        store.CopyOptions
+	}{
+		{false, "test-bucket", "test-object", "test-bucket", "copied-object", store.CopyOptions{}},
+		{false, "test-bucket", "test-object", "other-bucket", "copied-object", store.CopyOptions{}},
+		{false, "test-bucket", "test-object", "blake-bucket", "copied-object", store.CopyOptions{}},
+		{false, "versioned-bucket", "test-object", "versioned-bucket", "restored-object", store.CopyOptions{
+			VersionID: versions[len(versions)-1].VersionID,
+		}},
+		{true, "test-bucket", "moved-object", "test-bucket", "renamed-object", store.CopyOptions{}},
+		{true, "test-bucket", "renamed-object", "other-bucket", "moved-object", store.CopyOptions{}},
+	}
+	for _, c := range copies {
+		copyObject := before.Copy
+		if c.move {
+			copyObject = before.Move
+		}
+
+		if _, err := copyObject(c.srcBucket, c.srcObjectID, c.dstBucket, c.dstObjectID, c.opts); err != nil {
+			t.Fatalf("can not copy %s/%s: %s", c.srcBucket, c.srcObjectID, err)
+		}
+	}
+
+	if err := before.Close(); err != nil {
+		t.Fatalf("can not close store: %s", err)
+	}
+
+	after, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+	defer after.Close()
+
+	if diff := cmp.Diff(after.Stats(), before.Stats()); diff != "" {
+		t.Errorf("stats differ: -got+want\n%s", diff)
+	}
+
+	// The digester of the algorithm is a function, which can not be compared.
+	if diff := cmp.Diff(after.buckets, before.buckets, cmp.AllowUnexported(bucket{}, version{}),
+		cmpopts.IgnoreFields(bucket{}, "algorithm")); diff != "" {
+		t.Errorf("buckets differ: -got+want\n%s", diff)
+	}
+
+	reader, _, err := after.Get("versioned-bucket", "restored-object", store.GetOptions{})
+	if err != nil {
+		t.Fatalf("can not get restored object: %s", err)
+	}
+
+	if content := testutil.ReadAll(t, reader); content != "previous-content" {
+		t.Errorf("got content %q, want %q", content, "previous-content")
+	}
+}
+
+func TestCopyCollisions(t *testing.T) {
+	s := NewStore(log, WithDigest(digest.Algorithm{
+		Name:     "colliding",
+		Digester: collidingDigester,
+		Verify:   true,
+	}))
+
+	// Without global deduplication every bucket has its own contents, so the colliding contents can coexist.
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "object-a", "content-a"},
+		{opPut, "other-bucket", "object-b", "content-b"},
+	})
+
+	if _, err := s.Copy("test-bucket", "object-a", "new-bucket", "copied-object", store.CopyOptions{}); err != nil {
+		t.Fatalf("can not copy object: %s", err)
+	}
+
+	for _, dstBucket := range []string{"test-bucket", "new-bucket"} {
+		_, err := s.Copy("other-bucket", "object-b", dstBucket, "colliding-copy", store.CopyOptions{})
+		if !errors.Is(err, digest.ErrCollision) {
+			t.Errorf("got error %q for copy to %s, want %q", err, dstBucket, digest.ErrCollision)
+		}
+
+		if _, err := s.Head(dstBucket, "colliding-copy", store.GetOptions{}); err != store.ErrNotFound {
+			t.Errorf("got error %q for colliding copy in %s, want %q", err, dstBucket, store.ErrNotFound)
+		}
+	}
+}
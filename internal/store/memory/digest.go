@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"fmt"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+// WithVerification compares contents with identical digests before deduplicating them, even if the digest
+// algorithm is collision resistant.
+func WithVerification() Option {
+	return func(s *Store) {
+		s.alwaysVerify = true
+	}
+}
+
+// WithDigest uses the algorithm for the contents of all buckets which do not select their own algorithm.
+func WithDigest(algorithm digest.Algorithm) Option {
+	return func(s *Store) {
+		s.digester = algorithm.Digester
+		s.verify = algorithm.Verify
+	}
+}
+
+// bucketAlgorithm looks up the algorithm selected for a bucket. An empty name selects the default algorithm,
+// which is returned as nil.
+func bucketAlgorithm(name string) (*digest.Algorithm, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	algorithm, err := digest.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &algorithm, nil
+}
+
+// algorithmName returns the name of the algorithm selected for the bucket or an empty string for the default algorithm.
+func algorithmName(b *bucket) string {
+	if b == nil || b.algorithm == nil {
+		return ""
+	}
+
+	return b.algorithm.Name
+}
+
+// bucketDigester returns the digester used for the contents of the bucket and whether identical digests need to be
+// verified by comparing the contents. Buckets which do not exist yet use the default algorithm.
+func (s *Store) bucketDigester(b *bucket) (digest.Digester, bool) {
+	if b == nil || b.algorithm == nil {
+		return s.digester, s.verify || s.alwaysVerify
+	}
+
+	return b.algorithm.Digester, b.algorithm.Verify || s.alwaysVerify
+}
+
+// checkCollisions compares the chunks with the contents already stored under their digests. Contents are stored
+// under their digest like in the disk store, so a content having the same digest as a different one is rejected with
+// digest.ErrCollision. It needs to be called with the lock held.
+func (s *Store) checkCollisions(b *bucket, chunks []chunk) error {
+	pending := make(map[digest.Digest]string, len(chunks))
+	for _, c := range chunks {
+		existing, ok := pending[c.digest]
+		if !ok {
+			existing, ok = s.lookupContent(b, c.digest)
+		}
+
+		if ok && existing != c.content {
+			return fmt.Errorf("%w: %s", digest.ErrCollision, c.digest)
+		}
+
+		pending[c.digest] = c.content
+	}
+
+	return nil
+}
+
+// lookupContent returns the content stored under the key. Contents are looked up in the shared pool when
+// deduplicating globally, because keys need to be unique across all buckets then.
+func (s *Store) lookupContent(b *bucket, key digest.Digest) (string, bool) {
+	if s.shared != nil {
+		content, ok := s.shared.contents[key]
+		return content, ok
+	}
+
+	if b == nil {
+		return "", false
+	}
+
+	content, ok := b.contents[key]
+	return content, ok
+}
@@ -0,0 +1,251 @@
+package memory
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/xperimental/bukky/internal/chunker"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestBucketDigest(t *testing.T) {
+	tt := []struct {
+		desc          string
+		snapshotEvery int
+	}{
+		{
+			desc:          "log",
+			snapshotEvery: 0,
+		},
+		{
+			desc:          "snapshot",
+			snapshotEvery: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			before, err := NewPersistentStore(log, dir, tc.snapshotEvery)
+			if err != nil {
+				t.Fatalf("can not create store: %s", err)
+			}
+
+			if err := before.CreateBucket("blake-bucket", store.BucketOptions{Digest: "blake3"}); err != nil {
+				t.Fatalf("can not create bucket: %s", err)
+			}
+
+			for _, bucket := range []string{"blake-bucket", "default-bucket"} {
+				if _, err := before.Put(bucket, "test-object", strings.NewReader("test-content"), store.PutOptions{}); err != nil {
+					t.Fatalf("can not put object: %s", err)
+				}
+			}
+
+			if err := before.Close(); err != nil {
+				t.Fatalf("can not close store: %s", err)
+			}
+
+			after, err := NewPersistentStore(log, dir, tc.snapshotEvery)
+			if err != nil {
+				t.Fatalf("can not restore store: %s", err)
+			}
+			defer after.Close()
+
+			for bucket, want := range map[string]string{
+				"blake-bucket":   "blake3",
+				"default-bucket": digest.DefaultAlgorithm,
+			} {
+				meta, err := after.Head(bucket, "test-object", store.GetOptions{})
+				if err != nil {
+					t.Fatalf("can not get metadata: %s", err)
+				}
+
+				if got := meta.Digest.Algorithm(); got != want {
+					t.Errorf("got algorithm %q in %s, want %q", got, bucket, want)
+				}
+
+				if _, err := after.Put(bucket, "test-object2", strings.NewReader("test-content"), store.PutOptions{}); err != nil {
+					t.Fatalf("can not put object: %s", err)
+				}
+
+				if got := after.Stats().Buckets[bucket].NumContents; got != 1 {
+					t.Errorf("got %d contents in %s, want 1", got, bucket)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateBucketUnknownDigest(t *testing.T) {
+	s := NewStore(log)
+	err := s.CreateBucket("test-bucket", store.BucketOptions{Digest: "md5"})
+	if !errors.Is(err, digest.ErrUnknownAlgorithm) {
+		t.Errorf("got error %q, want %q", err, digest.ErrUnknownAlgorithm)
+	}
+
+	if s.BucketExists("test-bucket") {
+		t.Error("bucket exists after error")
+	}
+}
+
+// collidingDigester returns the same digest for every content.
+func collidingDigester(r io.Reader) (digest.Digest, error) {
+	if _, err := ioutil.ReadAll(r); err != nil {
+		return "", err
+	}
+
+	return "collision", nil
+}
+
+func TestCollisions(t *testing.T) {
+	colliding := digest.Algorithm{
+		Name:     "colliding",
+		Digester: collidingDigester,
+		Verify:   true,
+	}
+
+	tt := []struct {
+		desc string
+		opts []Option
+	}{
+		{
+			desc: "verified algorithm",
+			opts: []Option{WithDigest(colliding)},
+		},
+		{
+			desc: "verification",
+			opts: []Option{WithDigest(digest.Algorithm{
+				Name:     "colliding",
+				Digester: collidingDigester,
+			}), WithVerification()},
+		},
+		{
+			desc: "global deduplication",
+			opts: []Option{WithDigest(colliding), WithGlobalDeduplication()},
+		},
+		{
+			desc: "chunking",
+			opts: []Option{WithDigest(colliding), WithChunking(chunker.DefaultConfig)},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			before, err := NewPersistentStore(log, dir, 0, tc.opts...)
+			if err != nil {
+				t.Fatalf("can not create store: %s", err)
+			}
+
+			for _, objectID := range []string{"object-a", "object-a2"} {
+				if _, err := before.Put("test-bucket", objectID, strings.NewReader("content-a"), store.PutOptions{}); err != nil {
+					t.Fatalf("can not put %s: %s", objectID, err)
+				}
+			}
+
+			_, err = before.Put("test-bucket", "object-b", strings.NewReader("content-b"), store.PutOptions{})
+			if !errors.Is(err, digest.ErrCollision) {
+				t.Errorf("got error %q, want %q", err, digest.ErrCollision)
+			}
+
+			if err := before.Close(); err != nil {
+				t.Fatalf("can not close store: %s", err)
+			}
+
+			after, err := NewPersistentStore(log, dir, 0, tc.opts...)
+			if err != nil {
+				t.Fatalf("can not restore store: %s", err)
+			}
+			defer after.Close()
+
+			for _, s := range []*Store{before, after} {
+				for _, objectID := range []string{"object-a", "object-a2"} {
+					reader, _, err := s.Get("test-bucket", objectID, store.GetOptions{})
+					if err != nil {
+						t.Fatalf("can not get %s: %s", objectID, err)
+					}
+
+					if got := testutil.ReadAll(t, reader); got != "content-a" {
+						t.Errorf("got content %q for %s, want %q", got, objectID, "content-a")
+					}
+				}
+
+				if _, err := s.Head("test-bucket", "object-b", store.GetOptions{}); err != store.ErrNotFound {
+					t.Errorf("got error %q for colliding object, want %q", err, store.ErrNotFound)
+				}
+
+				if got := s.Stats().Buckets["test-bucket"].NumContents; got != 1 {
+					t.Errorf("got %d contents, want 1", got)
+				}
+			}
+
+			// Colliding contents are also rejected by batches and uploads.
+			results, err := after.Batch("test-bucket", []store.BatchOperation{
+				{Op: store.BatchPut, ObjectID: "batch-object", Content: []byte("content-b")},
+			}, true)
+			if err != nil {
+				t.Fatalf("can not apply batch: %s", err)
+			}
+			if !errors.Is(results[0].Err, digest.ErrCollision) {
+				t.Errorf("got error %q for batch, want %q", results[0].Err, digest.ErrCollision)
+			}
+
+			uploadID, err := after.CreateUpload("test-bucket", "upload-object", store.PutOptions{})
+			if err != nil {
+				t.Fatalf("can not create upload: %s", err)
+			}
+			if _, err := after.PutPart("test-bucket", "upload-object", uploadID, 1, strings.NewReader("content-b")); err != nil {
+				t.Fatalf("can not put part: %s", err)
+			}
+			if _, err := after.CompleteUpload("test-bucket", "upload-object", uploadID, nil); !errors.Is(err, digest.ErrCollision) {
+				t.Errorf("got error %q for upload, want %q", err, digest.ErrCollision)
+			}
+
+			// The content can be saved once the colliding one is gone.
+			for _, objectID := range []string{"object-a", "object-a2"} {
+				if err := after.Delete("test-bucket", objectID, store.DeleteOptions{}); err != nil {
+					t.Fatalf("can not delete %s: %s", objectID, err)
+				}
+			}
+
+			if _, err := after.Put("test-bucket", "object-b", strings.NewReader("content-b"), store.PutOptions{}); err != nil {
+				t.Fatalf("can not put object after delete: %s", err)
+			}
+
+			meta, err := after.Head("test-bucket", "object-b", store.GetOptions{})
+			if err != nil {
+				t.Fatalf("can not get metadata: %s", err)
+			}
+
+			if meta.Digest != "collision" {
+				t.Errorf("got digest %q, want %q", meta.Digest, "collision")
+			}
+		})
+	}
+}
+
+func TestCollisionsWithoutVerification(t *testing.T) {
+	s := NewStore(log)
+	s.digester = collidingDigester
+
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "object-a", "content-a"},
+		{opPut, "test-bucket", "object-b", "content-b"},
+	})
+
+	// Without verification the digest is trusted, so the second object is deduplicated with the first.
+	if got := s.Stats().Buckets["test-bucket"].NumContents; got != 1 {
+		t.Errorf("got %d contents, want 1", got)
+	}
+}
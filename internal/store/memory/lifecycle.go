@@ -0,0 +1,86 @@
+package memory
+
+import (
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (s *Store) SetLifecycle(bucketName string, rules []store.LifecycleRule) error {
+	if err := store.ValidateRules(rules); err != nil {
+		return err
+	}
+
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	if err := s.logRecord(walRecord{
+		Op:     opLifecycle,
+		Bucket: bucketName,
+		Rules:  rules,
+	}); err != nil {
+		return err
+	}
+
+	b.lifecycle = rules
+	s.compact()
+	return nil
+}
+
+func (s *Store) Lifecycle(bucketName string) ([]store.LifecycleRule, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	return b.lifecycle, nil
+}
+
+// ExpireObjects deletes the expired objects like Delete, so that the deletions are recorded in the write-ahead log.
+func (s *Store) ExpireObjects() (uint, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	now := s.now()
+	var expired uint
+	for bucketName, b := range s.buckets {
+		for objectID, meta := range b.metadata {
+			if !store.Expired(objectID, meta, b.lifecycle, now) {
+				continue
+			}
+
+			marker, err := s.deleteMarker(b.versioning, &meta)
+			if err != nil {
+				return expired, err
+			}
+
+			if err := s.logRecord(walRecord{
+				Op:       opDelete,
+				Bucket:   bucketName,
+				ObjectID: objectID,
+				Metadata: marker,
+			}); err != nil {
+				return expired, err
+			}
+
+			s.removeObject(b, objectID, marker)
+			b.expired++
+			expired++
+		}
+	}
+
+	if uploads := s.expireUploads(now); uploads > 0 {
+		s.log.Debugf("Discarded %d uploads older than %s.", uploads, store.UploadMaxAge)
+	}
+
+	if expired > 0 {
+		s.compact()
+	}
+	return expired, nil
+}
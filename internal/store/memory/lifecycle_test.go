@@ -0,0 +1,150 @@
+package memory
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func TestExpireObjects(t *testing.T) {
+	now := testTime
+	s := NewStore(log)
+	s.now = func() time.Time {
+		return now
+	}
+
+	puts := []struct {
+		bucket   string
+		objectID string
+		ttl      time.Duration
+	}{
+		{"test-bucket", "short-ttl", time.Hour},
+		{"test-bucket", "long-ttl", 48 * time.Hour},
+		{"test-bucket", "tmp/object", 0},
+		{"test-bucket", "object", 0},
+		{"versioned-bucket", "short-ttl", time.Hour},
+	}
+	for _, p := range puts {
+		if _, err := s.Put(p.bucket, p.objectID, strings.NewReader("test-content"), store.PutOptions{TTL: p.ttl}); err != nil {
+			t.Fatalf("can not put %s/%s: %s", p.bucket, p.objectID, err)
+		}
+	}
+
+	if err := s.SetLifecycle("test-bucket", []store.LifecycleRule{{Prefix: "tmp/", Days: 1}}); err != nil {
+		t.Fatalf("can not set lifecycle: %s", err)
+	}
+
+	if err := s.SetLifecycle("test-bucket", []store.LifecycleRule{{Days: 0}}); err != store.ErrInvalidRule {
+		t.Errorf("got error %q for invalid rule, want %q", err, store.ErrInvalidRule)
+	}
+
+	if err := s.SetVersioning("versioned-bucket", true); err != nil {
+		t.Fatalf("can not enable versioning: %s", err)
+	}
+
+	steps := []struct {
+		after       time.Duration
+		wantExpired uint
+		wantObjects []string
+	}{
+		{
+			after:       time.Minute,
+			wantExpired: 0,
+			wantObjects: []string{"long-ttl", "object", "short-ttl", "tmp/object"},
+		},
+		{
+			after:       time.Hour,
+			wantExpired: 2,
+			wantObjects: []string{"long-ttl", "object", "tmp/object"},
+		},
+		{
+			after:       24 * time.Hour,
+			wantExpired: 1,
+			wantObjects: []string{"long-ttl", "object"},
+		},
+		{
+			after:       48 * time.Hour,
+			wantExpired: 1,
+			wantObjects: []string{"object"},
+		},
+	}
+
+	for _, step := range steps {
+		now = testTime.Add(step.after)
+		expired, err := s.ExpireObjects()
+		if err != nil {
+			t.Fatalf("can not expire objects after %s: %s", step.after, err)
+		}
+
+		if expired != step.wantExpired {
+			t.Errorf("got %d expired objects after %s, want %d", expired, step.after, step.wantExpired)
+		}
+
+		list, err := s.List("test-bucket", store.ListOptions{})
+		if err != nil {
+			t.Fatalf("can not list objects: %s", err)
+		}
+
+		if diff := cmp.Diff(list.Objects, step.wantObjects); diff != "" {
+			t.Errorf("objects after %s differ: -got+want\n%s", step.after, diff)
+		}
+	}
+
+	stats := s.Stats()
+	if stats.Expired != 4 {
+		t.Errorf("got %d expired objects in stats, want 4", stats.Expired)
+	}
+
+	if got := stats.Buckets["test-bucket"].Expired; got != 3 {
+		t.Errorf("got %d expired objects in bucket stats, want 3", got)
+	}
+
+	versions, err := s.ListVersions("versioned-bucket", "")
+	if err != nil {
+		t.Fatalf("can not list versions: %s", err)
+	}
+
+	if len(versions) != 2 || !versions[0].DeleteMarker {
+		t.Errorf("got versions %#v, want delete marker and expired version", versions)
+	}
+}
+
+func TestPersistentLifecycle(t *testing.T) {
+	dir := t.TempDir()
+	before, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	rules := []store.LifecycleRule{
+		{Prefix: "tmp/", Days: 1},
+	}
+	applyOps(t, before, []walOp{
+		{opCreateBucket, "test-bucket", "", ""},
+	})
+	if err := before.SetLifecycle("test-bucket", rules); err != nil {
+		t.Fatalf("can not set lifecycle: %s", err)
+	}
+
+	if err := before.Close(); err != nil {
+		t.Fatalf("can not close store: %s", err)
+	}
+
+	after, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+	defer after.Close()
+
+	got, err := after.Lifecycle("test-bucket")
+	if err != nil {
+		t.Fatalf("can not get lifecycle: %s", err)
+	}
+
+	if diff := cmp.Diff(got, rules); diff != "" {
+		t.Errorf("rules differ: -got+want\n%s", diff)
+	}
+}
@@ -1,17 +1,96 @@
 package memory
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/chunker"
 	"github.com/xperimental/bukky/internal/digest"
 	"github.com/xperimental/bukky/internal/store"
 )
 
+// bucket maps every object to the ordered list of digests of its chunks.
+// Without chunking every object consists of a single chunk.
 type bucket struct {
-	objects  map[string]digest.Digest
+	objects  map[string][]digest.Digest
 	contents map[digest.Digest]string
+	// refs counts how often each content is referenced by the objects of the bucket.
+	refs     map[digest.Digest]uint
+	metadata map[string]store.Metadata
+	// versioning keeps the previous versions of objects when they are overwritten or deleted.
+	versioning bool
+	// versions contains the previous versions of each object, oldest first. The current version is kept in objects.
+	versions  map[string][]version
+	lifecycle []store.LifecycleRule
+	// expired counts the objects removed by expiration since the store was started.
+	expired uint
+	// evicted counts the objects removed to stay within the quota since the store was started.
+	evicted uint
+	// size is the number of bytes of the contents of the bucket.
+	size uint64
+	// chunks is the number of chunks referenced by the current versions of all objects.
+	chunks uint
+	usage  store.Usage
+	access *accessQueue
+	// algorithm is the digest algorithm selected when the bucket was created. It is nil for the default algorithm.
+	algorithm *digest.Algorithm
+}
+
+func newBucket() *bucket {
+	return &bucket{
+		objects:  make(map[string][]digest.Digest),
+		contents: make(map[digest.Digest]string),
+		refs:     make(map[digest.Digest]uint),
+		metadata: make(map[string]store.Metadata),
+	}
+}
+
+// setObject makes the chunks the current version of the object and updates the usage of the bucket.
+func (b *bucket) setObject(objectID string, chunks []digest.Digest, meta store.Metadata) {
+	var previous *store.Metadata
+	if old, ok := b.objects[objectID]; ok {
+		b.chunks -= uint(len(old))
+		previousMeta := b.metadata[objectID]
+		previous = &previousMeta
+	}
+
+	b.objects[objectID] = chunks
+	b.metadata[objectID] = meta
+	b.chunks += uint(len(chunks))
+	b.usage.Update(objectID, previous, &meta, b.metadata)
+}
+
+// unsetObject removes the current version of the object and updates the usage of the bucket.
+// The contents are not released.
+func (b *bucket) unsetObject(objectID string) {
+	chunks, ok := b.objects[objectID]
+	if !ok {
+		return
+	}
+
+	previous := b.metadata[objectID]
+	delete(b.objects, objectID)
+	delete(b.metadata, objectID)
+	b.chunks -= uint(len(chunks))
+	b.usage.Update(objectID, &previous, nil, b.metadata)
+}
+
+// pool contains the contents shared by all buckets when deduplicating globally.
+// Buckets reference contents from the pool, so that identical contents are only kept in memory once.
+type pool struct {
+	contents map[digest.Digest]string
+	// refs counts the buckets referencing a content.
+	refs map[digest.Digest]uint
+}
+
+type chunk struct {
+	digest  digest.Digest
+	content string
 }
 
 type Store struct {
@@ -19,110 +98,694 @@ type Store struct {
 	buckets     map[string]*bucket
 	bucketMutex *sync.RWMutex
 	digester    digest.Digester
+	// verify compares contents with identical digests of the default digester before deduplicating them.
+	verify      bool
+	chunking    *chunker.Config
+	shared      *pool
+	wal         *wal
+	now         func() time.Time
+	uploads     map[string]*upload
+	uploadMutex *sync.Mutex
+	quota       *Quota
+	// size is the number of bytes of all contents, counting shared contents once.
+	size        uint64
+	clock       uint64
+	accessMutex *sync.Mutex
+
+	// alwaysVerify compares contents with identical digests of all digesters before deduplicating them.
+	alwaysVerify bool
+}
+
+// Option configures optional behavior of a Store.
+type Option func(s *Store)
+
+// WithChunking splits contents into content-defined chunks which are deduplicated separately.
+// The configuration needs to be valid.
+func WithChunking(config chunker.Config) Option {
+	return func(s *Store) {
+		s.chunking = &config
+	}
+}
+
+// WithGlobalDeduplication shares identical contents between all buckets instead of only within a bucket.
+// Object IDs and deletes stay isolated per bucket.
+func WithGlobalDeduplication() Option {
+	return func(s *Store) {
+		s.shared = &pool{
+			contents: make(map[digest.Digest]string),
+			refs:     make(map[digest.Digest]uint),
+		}
+	}
 }
 
-func NewStore(log logrus.FieldLogger) *Store {
-	return &Store{
+func NewStore(log logrus.FieldLogger, opts ...Option) *Store {
+	s := &Store{
 		log:         log,
 		buckets:     make(map[string]*bucket),
 		bucketMutex: &sync.RWMutex{},
 		digester:    digest.SHA256,
+		now:         time.Now,
+		uploads:     make(map[string]*upload),
+		uploadMutex: &sync.Mutex{},
+		accessMutex: &sync.Mutex{},
+	}
+
+	for _, o := range opts {
+		o(s)
 	}
+
+	return s
 }
 
 func (s *Store) Stats() store.StoreStats {
 	s.bucketMutex.RLock()
 	defer s.bucketMutex.RUnlock()
 
-	buckets := map[string]store.BucketStats{}
-	for k, b := range s.buckets {
-		buckets[k] = store.BucketStats{
-			NumObjects:  uint(len(b.objects)),
-			NumContents: uint(len(b.contents)),
-		}
+	stats := store.StoreStats{
+		Buckets:       make(map[string]store.BucketStats, len(s.buckets)),
+		PhysicalBytes: s.size,
+	}
+	for name, b := range s.buckets {
+		stats.AddBucket(name, s.bucketStats(b))
+	}
+	stats.DedupRatio = store.DedupRatio(stats.LogicalBytes, stats.PhysicalBytes)
+
+	if s.shared != nil {
+		stats.SharedContents = uint(len(s.shared.contents))
+	}
+
+	return stats
+}
+
+func (s *Store) BucketStats(bucketName string) (store.BucketStats, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return store.BucketStats{}, store.ErrNotFound
+	}
+
+	return s.bucketStats(b), nil
+}
+
+// bucketStats collects the statistics of the bucket. Only the reference counts are copied, everything else is
+// kept up to date while objects are saved and removed.
+func (s *Store) bucketStats(b *bucket) store.BucketStats {
+	references := make(map[digest.Digest]uint, len(b.refs))
+	for d, r := range b.refs {
+		references[d] = r
+	}
+
+	stats := b.usage.BucketStats(b.size)
+	stats.NumContents = uint(len(b.contents))
+	stats.NumChunks = b.chunks
+	stats.References = references
+	stats.Expired = b.expired
+	stats.Evicted = b.evicted
+
+	return stats
+}
+
+func (s *Store) Get(bucketName, objectID string, opts store.GetOptions) (io.ReadSeekCloser, store.Metadata, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	contents, meta, err := s.getObject(bucketName, objectID, opts)
+	if err != nil {
+		return nil, store.Metadata{}, err
+	}
+
+	return newObjectReader(contents), meta, nil
+}
+
+// getObject returns the contents of the chunks of the object and its metadata. It needs to be called with the lock
+// held for reading at least.
+func (s *Store) getObject(bucketName, objectID string, opts store.GetOptions) ([]string, store.Metadata, error) {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, store.Metadata{}, store.ErrNotFound
+	}
+
+	chunks, meta, ok := b.object(objectID, opts.VersionID)
+	if !ok {
+		return nil, store.Metadata{}, store.ErrNotFound
 	}
+	s.touch(b, objectID)
+
+	contents := make([]string, 0, len(chunks))
+	for _, d := range chunks {
+		content, ok := b.contents[d]
+		if !ok {
+			return nil, store.Metadata{}, fmt.Errorf("can not find content with digest %q", d)
+		}
 
-	return store.StoreStats{
-		Buckets: buckets,
+		contents = append(contents, content)
 	}
+
+	return contents, meta, nil
 }
 
-func (s *Store) Get(bucketName, objectID string) (string, error) {
+func (s *Store) Head(bucketName, objectID string, opts store.GetOptions) (store.Metadata, error) {
 	s.bucketMutex.RLock()
 	defer s.bucketMutex.RUnlock()
 
 	b, ok := s.buckets[bucketName]
 	if !ok {
-		return "", store.ErrNotFound
+		return store.Metadata{}, store.ErrNotFound
 	}
 
-	obj, ok := b.objects[objectID]
+	_, meta, ok := b.object(objectID, opts.VersionID)
 	if !ok {
-		return "", store.ErrNotFound
+		return store.Metadata{}, store.ErrNotFound
 	}
+	s.touch(b, objectID)
+
+	return meta, nil
+}
+
+func (s *Store) GetContent(bucketName string, d digest.Digest) (io.ReadSeekCloser, int64, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
 
-	content, ok := b.contents[obj]
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, 0, store.ErrNotFound
+	}
+
+	chunks, ok := s.contentChunks(b, d)
 	if !ok {
-		return "", fmt.Errorf("can not find content with digest %q", obj)
+		return nil, 0, store.ErrNotFound
+	}
+
+	contents := make([]string, 0, len(chunks))
+	for _, c := range chunks {
+		content, ok := b.contents[c]
+		if !ok {
+			return nil, 0, fmt.Errorf("can not find content with digest %q", c)
+		}
+
+		contents = append(contents, content)
 	}
 
-	return content, nil
+	r := newObjectReader(contents)
+	return r, r.size, nil
 }
 
-func (s *Store) Put(bucketName string, objectID string, content string) (string, error) {
+// contentChunks returns the chunks of a content of the bucket by the digest of the complete content.
+// Without chunking the content is stored under its digest. Otherwise the chunks are taken from an object or version
+// with that digest.
+func (s *Store) contentChunks(b *bucket, d digest.Digest) ([]digest.Digest, bool) {
+	if s.chunking == nil {
+		if b.refs[d] == 0 {
+			return nil, false
+		}
+
+		return []digest.Digest{d}, true
+	}
+
+	for objectID, meta := range b.metadata {
+		if meta.Digest == d {
+			return b.objects[objectID], true
+		}
+	}
+
+	for _, history := range b.versions {
+		for _, v := range history {
+			if v.meta.Digest == d && !v.deleteMarker {
+				return v.chunks, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func (s *Store) Put(bucketName string, objectID string, content io.Reader, opts store.PutOptions) (store.Metadata, error) {
+	// The content is read before acquiring the lock, so that slow uploads do not block other requests.
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return store.Metadata{}, fmt.Errorf("can not read content: %w", err)
+	}
+
+	// Contents of a bucket which is recreated with a different algorithm in the meantime are still saved with the
+	// previous algorithm. This is harmless, because the digests of different algorithms do not overlap.
+	s.bucketMutex.RLock()
+	digester, verify := s.bucketDigester(s.buckets[bucketName])
+	s.bucketMutex.RUnlock()
+
+	c, err := s.prepareContent(digester, data)
+	if err != nil {
+		return store.Metadata{}, err
+	}
+
 	s.bucketMutex.Lock()
 	defer s.bucketMutex.Unlock()
 
-	contentDigest, err := s.digester(content)
+	meta, err := s.putContent(bucketName, objectID, c, verify, opts)
 	if err != nil {
-		return "", fmt.Errorf("can not create digest: %w", err)
+		return store.Metadata{}, err
 	}
 
-	b, ok := s.buckets[bucketName]
-	if !ok {
-		b = &bucket{
-			objects:  make(map[string]digest.Digest),
-			contents: make(map[digest.Digest]string),
+	s.compact()
+	return meta, nil
+}
+
+// preparedContent is a content which has been split and digested before the lock is acquired.
+type preparedContent struct {
+	data   []byte
+	chunks []chunk
+	digest digest.Digest
+}
+
+func (s *Store) prepareContent(digester digest.Digester, data []byte) (preparedContent, error) {
+	chunks, err := s.split(digester, data)
+	if err != nil {
+		return preparedContent{}, err
+	}
+
+	contentDigest, err := s.objectDigest(digester, data, chunks)
+	if err != nil {
+		return preparedContent{}, err
+	}
+
+	return preparedContent{
+		data:   data,
+		chunks: chunks,
+		digest: contentDigest,
+	}, nil
+}
+
+// putContent saves the prepared content as the object. It needs to be called with the lock held.
+func (s *Store) putContent(bucketName, objectID string, c preparedContent, verify bool, opts store.PutOptions) (store.Metadata, error) {
+	previous := s.current(bucketName, objectID)
+	if err := opts.Condition.Check(previous); err != nil {
+		return store.Metadata{}, err
+	}
+
+	if verify {
+		if err := s.checkCollisions(s.buckets[bucketName], c.chunks); err != nil {
+			return store.Metadata{}, err
+		}
+	}
+
+	chunks := c.chunks
+	if err := s.reserve(bucketName, objectID, chunks); err != nil {
+		return store.Metadata{}, err
+	}
+
+	meta := store.NewMetadata(opts, int64(len(c.data)), c.digest, s.now(), previous)
+	if b, ok := s.buckets[bucketName]; ok && b.versioning {
+		var err error
+		meta.VersionID, err = store.NewVersionID()
+		if err != nil {
+			return store.Metadata{}, err
 		}
-		s.buckets[bucketName] = b
 	}
 
-	if _, ok := b.contents[contentDigest]; !ok {
-		b.contents[contentDigest] = content
+	if err := s.logRecord(walRecord{
+		Op:       opPut,
+		Bucket:   bucketName,
+		ObjectID: objectID,
+		Content:  c.data,
+		Metadata: &meta,
+	}); err != nil {
+		return store.Metadata{}, err
 	}
-	b.objects[objectID] = contentDigest
 
-	return objectID, nil
+	s.putObject(bucketName, objectID, chunks, meta)
+	s.touch(s.buckets[bucketName], objectID)
+	return meta, nil
 }
 
-func (s *Store) Delete(bucketName, objectID string) error {
+func (s *Store) PutReference(bucketName, objectID string, d digest.Digest, opts store.PutOptions) (store.Metadata, error) {
 	s.bucketMutex.Lock()
 	defer s.bucketMutex.Unlock()
 
 	b, ok := s.buckets[bucketName]
 	if !ok {
-		return store.ErrNotFound
+		return store.Metadata{}, store.ErrNotFound
 	}
 
-	contentDigest, ok := b.objects[objectID]
+	chunks, ok := s.referencedChunks(b, d)
 	if !ok {
-		return store.ErrNotFound
+		return store.Metadata{}, store.ErrNotFound
 	}
 
-	delete(b.objects, objectID)
+	previous := s.current(bucketName, objectID)
+	if err := opts.Condition.Check(previous); err != nil {
+		return store.Metadata{}, err
+	}
+	if err := s.reserve(bucketName, objectID, chunks); err != nil {
+		return store.Metadata{}, err
+	}
+
+	var size int64
+	for _, c := range chunks {
+		size += int64(len(c.content))
+	}
 
-	found := false
-loop:
-	for _, d := range b.objects {
-		if d == contentDigest {
-			found = true
-			break loop
+	meta := store.NewMetadata(opts, size, d, s.now(), previous)
+	if b.versioning {
+		var err error
+		meta.VersionID, err = store.NewVersionID()
+		if err != nil {
+			return store.Metadata{}, err
 		}
 	}
 
-	if !found {
-		delete(b.contents, contentDigest)
+	if err := s.logRecord(walRecord{
+		Op:       opLink,
+		Bucket:   bucketName,
+		ObjectID: objectID,
+		Metadata: &meta,
+	}); err != nil {
+		return store.Metadata{}, err
+	}
+
+	s.putObject(bucketName, objectID, chunks, meta)
+	s.touch(b, objectID)
+	s.compact()
+
+	return meta, nil
+}
+
+// referencedChunks returns the chunks of the content with the digest including their contents, so that they can be
+// added to another object. The contents are kept even if the quota evicts the objects they are taken from.
+func (s *Store) referencedChunks(b *bucket, d digest.Digest) ([]chunk, bool) {
+	keys, ok := s.contentChunks(b, d)
+	if !ok {
+		return nil, false
+	}
+
+	chunks := make([]chunk, 0, len(keys))
+	for _, key := range keys {
+		content, ok := b.contents[key]
+		if !ok {
+			return nil, false
+		}
+
+		chunks = append(chunks, chunk{
+			digest:  key,
+			content: content,
+		})
+	}
+
+	return chunks, true
+}
+
+// current returns the metadata of the object or nil if it does not exist. It needs to be called with the lock held.
+func (s *Store) current(bucketName, objectID string) *store.Metadata {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil
+	}
+
+	if _, ok := b.objects[objectID]; !ok {
+		return nil
+	}
+
+	meta := b.metadata[objectID]
+	return &meta
+}
+
+// objectDigest returns the digest of the complete content of an object.
+// Without chunking it is the digest of the only chunk.
+func (s *Store) objectDigest(digester digest.Digester, data []byte, chunks []chunk) (digest.Digest, error) {
+	if len(chunks) == 1 {
+		return chunks[0].digest, nil
+	}
+
+	d, err := digester(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("can not create digest: %w", err)
+	}
+
+	return d, nil
+}
+
+// split divides the data into chunks and creates their digests.
+func (s *Store) split(digester digest.Digester, data []byte) ([]chunk, error) {
+	parts := [][]byte{data}
+	if s.chunking != nil {
+		parts = s.chunking.Split(data)
+	}
+
+	chunks := make([]chunk, 0, len(parts))
+	for _, p := range parts {
+		d, err := digester(bytes.NewReader(p))
+		if err != nil {
+			return nil, fmt.Errorf("can not create digest: %w", err)
+		}
+
+		chunks = append(chunks, chunk{
+			digest:  d,
+			content: string(p),
+		})
+	}
+
+	return chunks, nil
+}
+
+func (s *Store) putObject(bucketName, objectID string, chunks []chunk, meta store.Metadata) {
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		b = newBucket()
+		s.buckets[bucketName] = b
+	}
+
+	digests := make([]digest.Digest, 0, len(chunks))
+	for _, c := range chunks {
+		s.storeContent(b, c.digest, c.content)
+		b.refs[c.digest]++
+		digests = append(digests, c.digest)
+	}
+
+	// The new references are added first, so that contents shared with the previous version are kept.
+	// Versions with an ID are kept even if versioning has been suspended since, only the version without ID is
+	// replaced like in S3.
+	if previous, ok := b.objects[objectID]; ok {
+		if b.versioning || b.metadata[objectID].VersionID != "" {
+			s.keepVersion(b, objectID)
+		} else {
+			s.unreference(b, previous)
+		}
+	}
+	if meta.VersionID == "" {
+		s.releaseNullVersion(b, objectID)
+	}
+	b.setObject(objectID, digests, meta)
+	s.track(b, objectID)
+}
+
+func (s *Store) List(bucketName string, opts store.ListOptions) (store.ListResult, error) {
+	s.bucketMutex.RLock()
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		s.bucketMutex.RUnlock()
+		return store.ListResult{}, store.ErrNotFound
+	}
+
+	ids := make([]string, 0, len(b.objects))
+	for id := range b.objects {
+		ids = append(ids, id)
+	}
+	s.bucketMutex.RUnlock()
+
+	return store.ListObjectIDs(ids, opts)
+}
+
+func (s *Store) Delete(bucketName, objectID string, opts store.DeleteOptions) error {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	if err := s.deleteWithOptions(bucketName, objectID, opts); err != nil {
+		return err
+	}
+
+	s.compact()
+	return nil
+}
+
+// deleteWithOptions deletes the current version or the selected version of the object if the condition matches.
+// It needs to be called with the lock held.
+func (s *Store) deleteWithOptions(bucketName, objectID string, opts store.DeleteOptions) error {
+	current := s.current(bucketName, objectID)
+	if err := opts.Condition.Check(current); err != nil {
+		return err
+	}
+
+	if opts.VersionID != "" {
+		return s.deleteVersion(bucketName, objectID, opts.VersionID)
+	}
+
+	if current == nil {
+		return store.ErrNotFound
+	}
+
+	return s.deleteCurrent(bucketName, objectID)
+}
+
+// deleteCurrent logs and removes the current version of the object. It needs to be called with the lock held.
+func (s *Store) deleteCurrent(bucketName, objectID string) error {
+	b := s.buckets[bucketName]
+	marker, err := s.deleteMarker(b.versioning, s.current(bucketName, objectID))
+	if err != nil {
+		return err
+	}
+
+	if err := s.logRecord(walRecord{
+		Op:       opDelete,
+		Bucket:   bucketName,
+		ObjectID: objectID,
+		Metadata: marker,
+	}); err != nil {
+		return err
 	}
 
+	s.removeObject(b, objectID, marker)
 	return nil
 }
+
+// deleteMarker creates the metadata of a delete marker in buckets with versioning or if the current version of the
+// object has an ID. It returns nil otherwise.
+func (s *Store) deleteMarker(versioning bool, current *store.Metadata) (*store.Metadata, error) {
+	if !versioning {
+		// A version with an ID is kept behind a delete marker without ID if versioning has been suspended.
+		if current == nil || current.VersionID == "" {
+			return nil, nil
+		}
+
+		return &store.Metadata{
+			Modified: s.now(),
+		}, nil
+	}
+
+	versionID, err := store.NewVersionID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.Metadata{
+		Modified:  s.now(),
+		VersionID: versionID,
+	}, nil
+}
+
+// removeObject deletes the current version of the object. If marker is set, the current version is kept as a
+// previous version and the marker is added as a delete marker.
+func (s *Store) removeObject(b *bucket, objectID string, marker *store.Metadata) {
+	if marker == nil {
+		s.deleteObject(b, objectID)
+		return
+	}
+
+	s.keepVersion(b, objectID)
+	if marker.VersionID == "" {
+		s.releaseNullVersion(b, objectID)
+	}
+	b.addVersion(objectID, version{
+		meta:         *marker,
+		deleteMarker: true,
+	})
+	b.unsetObject(objectID)
+	s.forget(b, objectID)
+}
+
+func (s *Store) deleteObject(b *bucket, objectID string) {
+	s.unreference(b, b.objects[objectID])
+	b.unsetObject(objectID)
+	s.forget(b, objectID)
+}
+
+// unreference removes one reference to each of the digests and releases contents which are not referenced anymore.
+func (s *Store) unreference(b *bucket, digests []digest.Digest) {
+	for _, d := range digests {
+		if b.refs[d] > 1 {
+			b.refs[d]--
+			continue
+		}
+
+		delete(b.refs, d)
+		s.releaseContent(b, d)
+	}
+}
+
+// storeContent adds the content to the bucket unless it is already present.
+func (s *Store) storeContent(b *bucket, d digest.Digest, content string) {
+	if _, ok := b.contents[d]; ok {
+		return
+	}
+
+	if s.shared != nil {
+		if shared, ok := s.shared.contents[d]; ok {
+			content = shared
+		} else {
+			s.shared.contents[d] = content
+			s.size += uint64(len(content))
+		}
+		s.shared.refs[d]++
+	} else {
+		s.size += uint64(len(content))
+	}
+
+	b.contents[d] = content
+	b.size += uint64(len(content))
+}
+
+// releaseContent removes the content from the bucket and from the shared pool once no bucket references it anymore.
+func (s *Store) releaseContent(b *bucket, d digest.Digest) {
+	content, ok := b.contents[d]
+	if !ok {
+		return
+	}
+	delete(b.contents, d)
+	b.size -= uint64(len(content))
+
+	if s.shared == nil {
+		s.size -= uint64(len(content))
+		return
+	}
+
+	s.shared.refs[d]--
+	if s.shared.refs[d] == 0 {
+		delete(s.shared.refs, d)
+		delete(s.shared.contents, d)
+		s.size -= uint64(len(content))
+	}
+}
+
+// CollectGarbage removes all contents which are not referenced by any object and returns the number of removed contents.
+// The reference counts are recalculated from the objects in the process.
+func (s *Store) CollectGarbage() (uint, error) {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	var removed uint
+	for _, b := range s.buckets {
+		refs := make(map[digest.Digest]uint)
+		for _, chunks := range b.objects {
+			for _, d := range chunks {
+				refs[d]++
+			}
+		}
+
+		for _, history := range b.versions {
+			for _, v := range history {
+				for _, d := range v.chunks {
+					refs[d]++
+				}
+			}
+		}
+		b.refs = refs
+
+		for d := range b.contents {
+			if refs[d] == 0 {
+				s.releaseContent(b, d)
+				removed++
+			}
+		}
+	}
+
+	return removed, nil
+}
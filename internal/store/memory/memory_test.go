@@ -3,55 +3,114 @@ package memory
 import (
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/chunker"
 	"github.com/xperimental/bukky/internal/digest"
 	"github.com/xperimental/bukky/internal/store"
 	"github.com/xperimental/bukky/internal/testutil"
 )
 
 var (
-	log = logrus.New()
+	log      = logrus.New()
+	testTime = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	// ignoreSize ignores the usage counters of buckets, which are not kept up to date for buckets created by the tests.
+	ignoreSize = cmpopts.IgnoreFields(bucket{}, "size", "chunks", "usage")
 )
 
 func TestStats(t *testing.T) {
 	tt := []struct {
 		desc      string
-		buckets   map[string]*bucket
+		ops       []walOp
 		wantStats store.StoreStats
 	}{
 		{
-			desc:    "empty",
-			buckets: map[string]*bucket{},
+			desc: "empty",
+			ops:  []walOp{},
 			wantStats: store.StoreStats{
 				Buckets: map[string]store.BucketStats{},
 			},
 		},
 		{
 			desc: "one bucket",
-			buckets: map[string]*bucket{
-				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object":  "test-digest",
-						"test-object2": "test-digest",
-						"test-object3": "test-digest2",
-						"test-object4": "test-digest2",
-					},
-					contents: map[digest.Digest]string{
-						"test-digest":  "test-content",
-						"test-digest2": "test-content2",
-					},
-				},
+			ops: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "test-bucket", "test-object2", "test-content"},
+				{opPut, "test-bucket", "test-object3", "test-content2"},
+				{opPut, "test-bucket", "test-object4", "test-content2"},
 			},
 			wantStats: store.StoreStats{
 				Buckets: map[string]store.BucketStats{
 					"test-bucket": {
 						NumObjects:  4,
 						NumContents: 2,
+						NumChunks:   4,
+						BytesSaved:  25,
+						References: map[digest.Digest]uint{
+							"0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e": 2,
+							"94ba6a98c5123b53c471da92c82a9a469bf8d6c598fc12ba044414ed12ca432f": 2,
+						},
+						LogicalBytes:  50,
+						PhysicalBytes: 25,
+						DedupRatio:    2,
+						LargestObject: &store.ObjectSize{ObjectID: "test-object3", Size: 13},
+					},
+				},
+				NumObjects:    4,
+				LogicalBytes:  50,
+				PhysicalBytes: 25,
+				DedupRatio:    2,
+				LargestObject: &store.ObjectSize{Bucket: "test-bucket", ObjectID: "test-object3", Size: 13},
+			},
+		},
+		{
+			desc: "largest object deleted",
+			ops: []walOp{
+				{opPut, "bucket1", "test-object", "test-content"},
+				{opPut, "bucket2", "test-object", "test-content2"},
+				{opPut, "bucket2", "test-object2", "test"},
+				{opDelete, "bucket2", "test-object", ""},
+			},
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{
+					"bucket1": {
+						NumObjects:  1,
+						NumContents: 1,
+						NumChunks:   1,
+						References: map[digest.Digest]uint{
+							"0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e": 1,
+						},
+						LogicalBytes:  12,
+						PhysicalBytes: 12,
+						DedupRatio:    1,
+						LargestObject: &store.ObjectSize{ObjectID: "test-object", Size: 12},
+					},
+					"bucket2": {
+						NumObjects:  1,
+						NumContents: 1,
+						NumChunks:   1,
+						References: map[digest.Digest]uint{
+							"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08": 1,
+						},
+						LogicalBytes:  4,
+						PhysicalBytes: 4,
+						DedupRatio:    1,
+						LargestObject: &store.ObjectSize{ObjectID: "test-object2", Size: 4},
 					},
 				},
+				NumObjects:    2,
+				LogicalBytes:  16,
+				PhysicalBytes: 16,
+				DedupRatio:    1,
+				LargestObject: &store.ObjectSize{Bucket: "bucket1", ObjectID: "test-object", Size: 12},
 			},
 		},
 	}
@@ -62,7 +121,7 @@ func TestStats(t *testing.T) {
 			t.Parallel()
 
 			s := NewStore(log)
-			s.buckets = tc.buckets
+			applyOps(t, s, tc.ops)
 
 			stats := s.Stats()
 
@@ -73,6 +132,27 @@ func TestStats(t *testing.T) {
 	}
 }
 
+func TestBucketStats(t *testing.T) {
+	s := NewStore(log)
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "test-object", "test-content"},
+		{opPut, "test-bucket", "test-object2", "test-content"},
+	})
+
+	stats, err := s.BucketStats("test-bucket")
+	if err != nil {
+		t.Fatalf("can not get bucket stats: %s", err)
+	}
+
+	if diff := cmp.Diff(stats, s.Stats().Buckets["test-bucket"]); diff != "" {
+		t.Errorf("stats differ: -got+want\n%s", diff)
+	}
+
+	if _, err := s.BucketStats("other-bucket"); err != store.ErrNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrNotFound)
+	}
+}
+
 func TestGet(t *testing.T) {
 	tt := []struct {
 		desc        string
@@ -106,10 +186,13 @@ func TestGet(t *testing.T) {
 			objectID: "test-object",
 			buckets: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object": "digest",
+					objects: map[string][]digest.Digest{
+						"test-object": {"digest"},
 					},
 					contents: map[digest.Digest]string{},
+					refs: map[digest.Digest]uint{
+						"digest": 1,
+					},
 				},
 			},
 			wantContent: "",
@@ -121,12 +204,15 @@ func TestGet(t *testing.T) {
 			objectID: "test-object",
 			buckets: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object": "digest",
+					objects: map[string][]digest.Digest{
+						"test-object": {"digest"},
 					},
 					contents: map[digest.Digest]string{
 						"digest": "content",
 					},
+					refs: map[digest.Digest]uint{
+						"digest": 1,
+					},
 				},
 			},
 			wantContent: "content",
@@ -142,7 +228,7 @@ func TestGet(t *testing.T) {
 			s := NewStore(log)
 			s.buckets = tc.buckets
 
-			content, err := s.Get(tc.bucket, tc.objectID)
+			reader, _, err := s.Get(tc.bucket, tc.objectID, store.GetOptions{})
 			if !testutil.EqualErrorMessage(err, tc.wantErr) {
 				t.Errorf("got error %q, want %q", err, tc.wantErr)
 			}
@@ -151,6 +237,7 @@ func TestGet(t *testing.T) {
 				return
 			}
 
+			content := testutil.ReadAll(t, reader)
 			if content != tc.wantContent {
 				t.Errorf("got content %q, want %q", content, tc.wantContent)
 			}
@@ -165,10 +252,10 @@ func TestPut(t *testing.T) {
 		bucket        string
 		objectID      string
 		content       string
+		opts          store.PutOptions
 		digester      digest.Digester
 		bucketsBefore map[string]*bucket
 		wantBuckets   map[string]*bucket
-		wantID        string
 		wantErr       error
 	}{
 		{
@@ -176,7 +263,14 @@ func TestPut(t *testing.T) {
 			bucket:   "test-bucket",
 			objectID: "test-object",
 			content:  "test-content",
-			digester: func(content string) (digest.Digest, error) {
+			opts: store.PutOptions{
+				ContentType: "text/plain",
+				UserMetadata: map[string]string{
+					"Author": "test-author",
+				},
+			},
+			digester: func(r io.Reader) (digest.Digest, error) {
+				content := testutil.ReadAll(t, r)
 				if content != "test-content" {
 					t.Errorf("got content to digest %q, want %q", content, "test-content")
 				}
@@ -186,15 +280,29 @@ func TestPut(t *testing.T) {
 			bucketsBefore: map[string]*bucket{},
 			wantBuckets: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object": "test-digest",
+					objects: map[string][]digest.Digest{
+						"test-object": {"test-digest"},
 					},
 					contents: map[digest.Digest]string{
 						"test-digest": "test-content",
 					},
+					refs: map[digest.Digest]uint{
+						"test-digest": 1,
+					},
+					metadata: map[string]store.Metadata{
+						"test-object": {
+							ContentType: "text/plain",
+							Size:        12,
+							Created:     testTime,
+							Modified:    testTime,
+							Digest:      "test-digest",
+							User: map[string]string{
+								"Author": "test-author",
+							},
+						},
+					},
 				},
 			},
-			wantID:  "test-object",
 			wantErr: nil,
 		},
 		{
@@ -202,31 +310,113 @@ func TestPut(t *testing.T) {
 			bucket:   "test-bucket",
 			objectID: "test-object-two",
 			content:  "test-content",
-			digester: func(content string) (digest.Digest, error) {
+			digester: func(r io.Reader) (digest.Digest, error) {
+				content := testutil.ReadAll(t, r)
 				return digest.Digest(fmt.Sprintf("%s-digest", content)), nil
 			},
 			bucketsBefore: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object": "test-content-digest",
+					objects: map[string][]digest.Digest{
+						"test-object": {"test-content-digest"},
 					},
 					contents: map[digest.Digest]string{
 						"test-content-digest": "test-content",
 					},
+					refs: map[digest.Digest]uint{
+						"test-content-digest": 1,
+					},
+					metadata: map[string]store.Metadata{
+						"test-object": {
+							Size:     12,
+							Created:  testTime.Add(-time.Hour),
+							Modified: testTime.Add(-time.Hour),
+							Digest:   "test-content-digest",
+						},
+					},
 				},
 			},
 			wantBuckets: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object":     "test-content-digest",
-						"test-object-two": "test-content-digest",
+					objects: map[string][]digest.Digest{
+						"test-object":     {"test-content-digest"},
+						"test-object-two": {"test-content-digest"},
 					},
 					contents: map[digest.Digest]string{
 						"test-content-digest": "test-content",
 					},
+					refs: map[digest.Digest]uint{
+						"test-content-digest": 2,
+					},
+					metadata: map[string]store.Metadata{
+						"test-object": {
+							Size:     12,
+							Created:  testTime.Add(-time.Hour),
+							Modified: testTime.Add(-time.Hour),
+							Digest:   "test-content-digest",
+						},
+						"test-object-two": {
+							Size:     12,
+							Created:  testTime,
+							Modified: testTime,
+							Digest:   "test-content-digest",
+						},
+					},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			desc:     "overwrite",
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			content:  "new-content",
+			digester: func(r io.Reader) (digest.Digest, error) {
+				content := testutil.ReadAll(t, r)
+				return digest.Digest(fmt.Sprintf("%s-digest", content)), nil
+			},
+			bucketsBefore: map[string]*bucket{
+				"test-bucket": {
+					objects: map[string][]digest.Digest{
+						"test-object": {"test-content-digest"},
+					},
+					contents: map[digest.Digest]string{
+						"test-content-digest": "test-content",
+					},
+					refs: map[digest.Digest]uint{
+						"test-content-digest": 1,
+					},
+					metadata: map[string]store.Metadata{
+						"test-object": {
+							ContentType: "text/plain",
+							Size:        12,
+							Created:     testTime.Add(-time.Hour),
+							Modified:    testTime.Add(-time.Hour),
+							Digest:      "test-content-digest",
+						},
+					},
+				},
+			},
+			wantBuckets: map[string]*bucket{
+				"test-bucket": {
+					objects: map[string][]digest.Digest{
+						"test-object": {"new-content-digest"},
+					},
+					contents: map[digest.Digest]string{
+						"new-content-digest": "new-content",
+					},
+					refs: map[digest.Digest]uint{
+						"new-content-digest": 1,
+					},
+					metadata: map[string]store.Metadata{
+						"test-object": {
+							Size:     11,
+							Created:  testTime.Add(-time.Hour),
+							Modified: testTime,
+							Digest:   "new-content-digest",
+						},
+					},
 				},
 			},
-			wantID:  "test-object-two",
 			wantErr: nil,
 		},
 		{
@@ -234,12 +424,11 @@ func TestPut(t *testing.T) {
 			bucket:   "test-bucket",
 			objectID: "test-object",
 			content:  "test-content",
-			digester: func(content string) (digest.Digest, error) {
+			digester: func(r io.Reader) (digest.Digest, error) {
 				return "", digestError
 			},
 			bucketsBefore: map[string]*bucket{},
 			wantBuckets:   map[string]*bucket{},
-			wantID:        "",
 			wantErr:       errors.New("can not create digest: test-digest-error"),
 		},
 	}
@@ -252,13 +441,16 @@ func TestPut(t *testing.T) {
 			s := NewStore(log)
 			s.buckets = tc.bucketsBefore
 			s.digester = tc.digester
+			s.now = func() time.Time {
+				return testTime
+			}
 
-			id, err := s.Put(tc.bucket, tc.objectID, tc.content)
+			meta, err := s.Put(tc.bucket, tc.objectID, strings.NewReader(tc.content), tc.opts)
 			if !testutil.EqualErrorMessage(err, tc.wantErr) {
 				t.Errorf("got error %q, want %q", err, tc.wantErr)
 			}
 
-			if diff := cmp.Diff(s.buckets, tc.wantBuckets, cmp.AllowUnexported(bucket{})); diff != "" {
+			if diff := cmp.Diff(s.buckets, tc.wantBuckets, cmp.AllowUnexported(bucket{}), ignoreSize); diff != "" {
 				t.Errorf("resulting buckets differ: -got+want\n%s", diff)
 			}
 
@@ -266,8 +458,8 @@ func TestPut(t *testing.T) {
 				return
 			}
 
-			if id != tc.wantID {
-				t.Errorf("got id %q, want %q", id, tc.wantID)
+			if diff := cmp.Diff(meta, tc.wantBuckets[tc.bucket].metadata[tc.objectID]); diff != "" {
+				t.Errorf("returned metadata differs: -got+want\n%s", diff)
 			}
 		})
 	}
@@ -308,18 +500,22 @@ func TestDelete(t *testing.T) {
 			objectID: "test-object",
 			bucketsBefore: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object": "test-digest",
+					objects: map[string][]digest.Digest{
+						"test-object": {"test-digest"},
 					},
 					contents: map[digest.Digest]string{
 						"test-digest": "test-content",
 					},
+					refs: map[digest.Digest]uint{
+						"test-digest": 1,
+					},
 				},
 			},
 			wantBuckets: map[string]*bucket{
 				"test-bucket": {
-					objects:  map[string]digest.Digest{},
+					objects:  map[string][]digest.Digest{},
 					contents: map[digest.Digest]string{},
+					refs:     map[digest.Digest]uint{},
 				},
 			},
 			wantErr: nil,
@@ -330,23 +526,29 @@ func TestDelete(t *testing.T) {
 			objectID: "test-object",
 			bucketsBefore: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object":  "test-digest",
-						"test-object2": "test-digest",
+					objects: map[string][]digest.Digest{
+						"test-object":  {"test-digest"},
+						"test-object2": {"test-digest"},
 					},
 					contents: map[digest.Digest]string{
 						"test-digest": "test-content",
 					},
+					refs: map[digest.Digest]uint{
+						"test-digest": 2,
+					},
 				},
 			},
 			wantBuckets: map[string]*bucket{
 				"test-bucket": {
-					objects: map[string]digest.Digest{
-						"test-object2": "test-digest",
+					objects: map[string][]digest.Digest{
+						"test-object2": {"test-digest"},
 					},
 					contents: map[digest.Digest]string{
 						"test-digest": "test-content",
 					},
+					refs: map[digest.Digest]uint{
+						"test-digest": 1,
+					},
 				},
 			},
 			wantErr: nil,
@@ -361,12 +563,12 @@ func TestDelete(t *testing.T) {
 			s := NewStore(log)
 			s.buckets = tc.bucketsBefore
 
-			err := s.Delete(tc.bucket, tc.objectID)
+			err := s.Delete(tc.bucket, tc.objectID, store.DeleteOptions{})
 			if !testutil.EqualErrorMessage(err, tc.wantErr) {
 				t.Errorf("got error %q, want %q", err, tc.wantErr)
 			}
 
-			if diff := cmp.Diff(s.buckets, tc.wantBuckets, cmp.AllowUnexported(bucket{})); diff != "" {
+			if diff := cmp.Diff(s.buckets, tc.wantBuckets, cmp.AllowUnexported(bucket{}), ignoreSize); diff != "" {
 				t.Errorf("resulting buckets differ: -got+want\n%s", diff)
 			}
 
@@ -376,3 +578,879 @@ func TestDelete(t *testing.T) {
 		})
 	}
 }
+
+func TestChunking(t *testing.T) {
+	config := chunker.Config{
+		MinSize: 16,
+		AvgSize: 64,
+		MaxSize: 256,
+	}
+
+	base := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(base)
+	modified := append([]byte("prefix"), base...)
+
+	tt := []struct {
+		desc      string
+		contents  []string
+		wantStats store.BucketStats
+	}{
+		{
+			desc:     "identical content",
+			contents: []string{string(base), string(base)},
+			wantStats: store.BucketStats{
+				NumObjects:    2,
+				NumContents:   52,
+				NumChunks:     104,
+				BytesSaved:    4096,
+				LogicalBytes:  8192,
+				PhysicalBytes: 4096,
+				DedupRatio:    2,
+				LargestObject: &store.ObjectSize{ObjectID: "test-object0", Size: 4096},
+			},
+		},
+		{
+			desc:     "modified content",
+			contents: []string{string(base), string(modified)},
+			wantStats: store.BucketStats{
+				NumObjects:    2,
+				NumContents:   58,
+				NumChunks:     103,
+				BytesSaved:    3562,
+				LogicalBytes:  8198,
+				PhysicalBytes: 4636,
+				DedupRatio:    8198.0 / 4636,
+				LargestObject: &store.ObjectSize{ObjectID: "test-object1", Size: 4102},
+			},
+		},
+		{
+			desc:     "small content",
+			contents: []string{"small", ""},
+			wantStats: store.BucketStats{
+				NumObjects:    2,
+				NumContents:   1,
+				NumChunks:     1,
+				BytesSaved:    0,
+				LogicalBytes:  5,
+				PhysicalBytes: 5,
+				DedupRatio:    1,
+				LargestObject: &store.ObjectSize{ObjectID: "test-object0", Size: 5},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log, WithChunking(config))
+			for i, c := range tc.contents {
+				objectID := fmt.Sprintf("test-object%d", i)
+				if _, err := s.Put("test-bucket", objectID, strings.NewReader(c), store.PutOptions{}); err != nil {
+					t.Fatalf("can not put object: %s", err)
+				}
+			}
+
+			for i, c := range tc.contents {
+				objectID := fmt.Sprintf("test-object%d", i)
+				reader, _, err := s.Get("test-bucket", objectID, store.GetOptions{})
+				if err != nil {
+					t.Fatalf("can not get object: %s", err)
+				}
+
+				if content := testutil.ReadAll(t, reader); content != c {
+					t.Errorf("content of %s differs", objectID)
+				}
+			}
+
+			stats := s.Stats().Buckets["test-bucket"]
+			if diff := cmp.Diff(stats, tc.wantStats, cmpopts.IgnoreFields(store.BucketStats{}, "References")); diff != "" {
+				t.Errorf("stats differ: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGlobalDeduplication(t *testing.T) {
+	type op struct {
+		op       string
+		bucket   string
+		objectID string
+		content  string
+	}
+
+	tt := []struct {
+		desc       string
+		ops        []op
+		wantStats  store.StoreStats
+		wantShared map[digest.Digest]uint
+	}{
+		{
+			desc: "same content in two buckets",
+			ops: []op{
+				{opPut, "bucket1", "test-object", "test-content"},
+				{opPut, "bucket2", "test-object", "test-content"},
+			},
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{
+					"bucket1": {
+						NumObjects:    1,
+						NumContents:   1,
+						NumChunks:     1,
+						References:    map[digest.Digest]uint{"test-content-digest": 1},
+						LogicalBytes:  12,
+						PhysicalBytes: 12,
+						DedupRatio:    1,
+						LargestObject: &store.ObjectSize{ObjectID: "test-object", Size: 12},
+					},
+					"bucket2": {
+						NumObjects:    1,
+						NumContents:   1,
+						NumChunks:     1,
+						References:    map[digest.Digest]uint{"test-content-digest": 1},
+						LogicalBytes:  12,
+						PhysicalBytes: 12,
+						DedupRatio:    1,
+						LargestObject: &store.ObjectSize{ObjectID: "test-object", Size: 12},
+					},
+				},
+				SharedContents: 1,
+				NumObjects:     2,
+				LogicalBytes:   24,
+				PhysicalBytes:  12,
+				DedupRatio:     2,
+				LargestObject:  &store.ObjectSize{Bucket: "bucket1", ObjectID: "test-object", Size: 12},
+			},
+			wantShared: map[digest.Digest]uint{
+				"test-content-digest": 2,
+			},
+		},
+		{
+			desc: "delete in one bucket",
+			ops: []op{
+				{opPut, "bucket1", "test-object", "test-content"},
+				{opPut, "bucket2", "test-object", "test-content"},
+				{opPut, "bucket2", "test-object2", "test-content2"},
+				{opDelete, "bucket1", "test-object", ""},
+			},
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{
+					"bucket1": {References: map[digest.Digest]uint{}},
+					"bucket2": {
+						NumObjects:  2,
+						NumContents: 2,
+						NumChunks:   2,
+						References: map[digest.Digest]uint{
+							"test-content-digest":  1,
+							"test-content2-digest": 1,
+						},
+						LogicalBytes:  25,
+						PhysicalBytes: 25,
+						DedupRatio:    1,
+						LargestObject: &store.ObjectSize{ObjectID: "test-object2", Size: 13},
+					},
+				},
+				SharedContents: 2,
+				NumObjects:     2,
+				LogicalBytes:   25,
+				PhysicalBytes:  25,
+				DedupRatio:     1,
+				LargestObject:  &store.ObjectSize{Bucket: "bucket2", ObjectID: "test-object2", Size: 13},
+			},
+			wantShared: map[digest.Digest]uint{
+				"test-content-digest":  1,
+				"test-content2-digest": 1,
+			},
+		},
+		{
+			desc: "delete in all buckets",
+			ops: []op{
+				{opPut, "bucket1", "test-object", "test-content"},
+				{opPut, "bucket2", "test-object", "test-content"},
+				{opDelete, "bucket1", "test-object", ""},
+				{opDelete, "bucket2", "test-object", ""},
+			},
+			wantStats: store.StoreStats{
+				Buckets: map[string]store.BucketStats{
+					"bucket1": {References: map[digest.Digest]uint{}},
+					"bucket2": {References: map[digest.Digest]uint{}},
+				},
+				SharedContents: 0,
+			},
+			wantShared: map[digest.Digest]uint{},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log, WithGlobalDeduplication())
+			s.digester = func(r io.Reader) (digest.Digest, error) {
+				content := testutil.ReadAll(t, r)
+				return digest.Digest(fmt.Sprintf("%s-digest", content)), nil
+			}
+
+			for _, o := range tc.ops {
+				var err error
+				switch o.op {
+				case opPut:
+					_, err = s.Put(o.bucket, o.objectID, strings.NewReader(o.content), store.PutOptions{})
+				case opDelete:
+					err = s.Delete(o.bucket, o.objectID, store.DeleteOptions{})
+				}
+
+				if err != nil {
+					t.Fatalf("can not %s %s/%s: %s", o.op, o.bucket, o.objectID, err)
+				}
+			}
+
+			if diff := cmp.Diff(s.Stats(), tc.wantStats); diff != "" {
+				t.Errorf("stats differ: -got+want\n%s", diff)
+			}
+
+			if diff := cmp.Diff(s.shared.refs, tc.wantShared); diff != "" {
+				t.Errorf("shared references differ: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOverwrite(t *testing.T) {
+	tt := []struct {
+		desc            string
+		puts            []walOp
+		wantNumContents uint
+	}{
+		{
+			desc: "overwrite with new content",
+			puts: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "test-bucket", "test-object", "test-content2"},
+			},
+			wantNumContents: 1,
+		},
+		{
+			desc: "overwrite with same content",
+			puts: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "test-bucket", "test-object", "test-content"},
+			},
+			wantNumContents: 1,
+		},
+		{
+			desc: "overwrite shared content",
+			puts: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "test-bucket", "test-object2", "test-content"},
+				{opPut, "test-bucket", "test-object", "test-content2"},
+			},
+			wantNumContents: 2,
+		},
+		{
+			desc: "overwrite sequence",
+			puts: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "test-bucket", "test-object", "test-content2"},
+				{opPut, "test-bucket", "test-object", "test-content3"},
+				{opPut, "test-bucket", "test-object2", "test-content3"},
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "test-bucket", "test-object2", "test-content"},
+			},
+			wantNumContents: 1,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log)
+			applyOps(t, s, tc.puts)
+
+			stats := s.Stats().Buckets["test-bucket"]
+			if stats.NumContents != tc.wantNumContents {
+				t.Errorf("got %d contents, want %d", stats.NumContents, tc.wantNumContents)
+			}
+		})
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	s := NewStore(log)
+	s.buckets = map[string]*bucket{
+		"test-bucket": {
+			objects: map[string][]digest.Digest{
+				"test-object": {"test-digest"},
+			},
+			contents: map[digest.Digest]string{
+				"test-digest":        "test-content",
+				"test-leaked-digest": "test-leaked-content",
+			},
+			refs: map[digest.Digest]uint{
+				"test-digest": 1,
+			},
+		},
+	}
+
+	removed, err := s.CollectGarbage()
+	if err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if removed != 1 {
+		t.Errorf("got %d removed contents, want %d", removed, 1)
+	}
+
+	wantBuckets := map[string]*bucket{
+		"test-bucket": {
+			objects: map[string][]digest.Digest{
+				"test-object": {"test-digest"},
+			},
+			contents: map[digest.Digest]string{
+				"test-digest": "test-content",
+			},
+			refs: map[digest.Digest]uint{
+				"test-digest": 1,
+			},
+		},
+	}
+	if diff := cmp.Diff(s.buckets, wantBuckets, cmp.AllowUnexported(bucket{}), ignoreSize); diff != "" {
+		t.Errorf("resulting buckets differ: -got+want\n%s", diff)
+	}
+}
+
+func TestList(t *testing.T) {
+	s := NewStore(log)
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "dir/test-object", "test-content"},
+		{opPut, "test-bucket", "test-object", "test-content"},
+	})
+
+	tt := []struct {
+		desc       string
+		bucket     string
+		wantResult store.ListResult
+		wantErr    error
+	}{
+		{
+			desc:    "bucket not found",
+			bucket:  "other-bucket",
+			wantErr: store.ErrNotFound,
+		},
+		{
+			desc:   "success",
+			bucket: "test-bucket",
+			wantResult: store.ListResult{
+				Objects:        []string{"test-object"},
+				CommonPrefixes: []string{"dir/"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := s.List(tc.bucket, store.ListOptions{Delimiter: "/"})
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if diff := cmp.Diff(result, tc.wantResult); diff != "" {
+				t.Errorf("result differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteBucket(t *testing.T) {
+	tt := []struct {
+		desc        string
+		opts        []Option
+		ops         []walOp
+		bucket      string
+		force       bool
+		wantErr     error
+		wantBuckets []string
+		wantShared  map[digest.Digest]uint
+	}{
+		{
+			desc:        "bucket not found",
+			bucket:      "test-bucket",
+			wantErr:     store.ErrNotFound,
+			wantBuckets: []string{},
+		},
+		{
+			desc: "empty bucket",
+			ops: []walOp{
+				{opCreateBucket, "test-bucket", "", ""},
+			},
+			bucket:      "test-bucket",
+			wantBuckets: []string{},
+		},
+		{
+			desc: "bucket not empty",
+			ops: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+			},
+			bucket:      "test-bucket",
+			wantErr:     store.ErrBucketNotEmpty,
+			wantBuckets: []string{"test-bucket"},
+		},
+		{
+			desc: "forced",
+			ops: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "other-bucket", "test-object", "test-content"},
+			},
+			bucket:      "test-bucket",
+			force:       true,
+			wantBuckets: []string{"other-bucket"},
+		},
+		{
+			desc: "forced with global deduplication",
+			opts: []Option{WithGlobalDeduplication()},
+			ops: []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+				{opPut, "test-bucket", "test-object2", "test-content2"},
+				{opPut, "other-bucket", "test-object", "test-content"},
+			},
+			bucket:      "test-bucket",
+			force:       true,
+			wantBuckets: []string{"other-bucket"},
+			wantShared: map[digest.Digest]uint{
+				"0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e": 1,
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log, tc.opts...)
+			applyOps(t, s, tc.ops)
+
+			err := s.DeleteBucket(tc.bucket, tc.force)
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			buckets, err := s.ListBuckets()
+			if err != nil {
+				t.Fatalf("can not list buckets: %s", err)
+			}
+
+			if diff := cmp.Diff(buckets, tc.wantBuckets); diff != "" {
+				t.Errorf("buckets differ: -got+want\n%s", diff)
+			}
+
+			if s.shared == nil {
+				return
+			}
+
+			if diff := cmp.Diff(s.shared.refs, tc.wantShared); diff != "" {
+				t.Errorf("shared references differ: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCreateBucket(t *testing.T) {
+	s := NewStore(log)
+	if err := s.CreateBucket("test-bucket", store.BucketOptions{}); err != nil {
+		t.Fatalf("got error: %s", err)
+	}
+
+	if !s.BucketExists("test-bucket") {
+		t.Errorf("bucket does not exist after creation")
+	}
+
+	if err := s.CreateBucket("test-bucket", store.BucketOptions{}); err != store.ErrBucketExists {
+		t.Errorf("got error %q, want %q", err, store.ErrBucketExists)
+	}
+
+	if s.BucketExists("other-bucket") {
+		t.Errorf("bucket exists without creation")
+	}
+}
+
+func TestHead(t *testing.T) {
+	s := NewStore(log)
+	s.now = func() time.Time {
+		return testTime
+	}
+	if _, err := s.Put("test-bucket", "test-object", strings.NewReader("test-content"), store.PutOptions{
+		ContentType: "text/plain",
+	}); err != nil {
+		t.Fatalf("can not put object: %s", err)
+	}
+
+	tt := []struct {
+		desc     string
+		bucket   string
+		objectID string
+		wantMeta store.Metadata
+		wantErr  error
+	}{
+		{
+			desc:     "bucket not found",
+			bucket:   "other-bucket",
+			objectID: "test-object",
+			wantErr:  store.ErrNotFound,
+		},
+		{
+			desc:     "object not found",
+			bucket:   "test-bucket",
+			objectID: "other-object",
+			wantErr:  store.ErrNotFound,
+		},
+		{
+			desc:     "success",
+			bucket:   "test-bucket",
+			objectID: "test-object",
+			wantMeta: store.Metadata{
+				ContentType: "text/plain",
+				Size:        12,
+				Created:     testTime,
+				Modified:    testTime,
+				Digest:      "0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e",
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			meta, err := s.Head(tc.bucket, tc.objectID, store.GetOptions{})
+			if !testutil.EqualErrorMessage(err, tc.wantErr) {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if diff := cmp.Diff(meta, tc.wantMeta); diff != "" {
+				t.Errorf("metadata differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetContent(t *testing.T) {
+	large := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(large)
+	contents := map[string]string{
+		"small":    "test-content",
+		"large":    string(large),
+		"previous": "previous-content",
+		"deleted":  "deleted-content",
+	}
+
+	digests := make(map[string]digest.Digest, len(contents))
+	for name, content := range contents {
+		d, err := digest.SHA256(strings.NewReader(content))
+		if err != nil {
+			t.Fatalf("can not create digest: %s", err)
+		}
+		digests[name] = d
+	}
+
+	for _, opts := range [][]Option{nil, {WithChunking(chunker.Config{MinSize: 16, AvgSize: 64, MaxSize: 256})}} {
+		s := NewStore(log, opts...)
+		applyOps(t, s, []walOp{
+			{opCreateBucket, "test-bucket", "", ""},
+			{opVersioning, "test-bucket", "", ""},
+			{opPut, "test-bucket", "small", contents["small"]},
+			{opPut, "test-bucket", "large", contents["large"]},
+			{opPut, "test-bucket", "versioned", contents["previous"]},
+			{opPut, "test-bucket", "versioned", contents["small"]},
+			{opPut, "other-bucket", "deleted", contents["deleted"]},
+			{opDelete, "other-bucket", "deleted", ""},
+		})
+
+		tt := []struct {
+			desc        string
+			bucket      string
+			digest      digest.Digest
+			wantContent string
+			wantErr     error
+		}{
+			{
+				desc:    "bucket not found",
+				bucket:  "missing-bucket",
+				digest:  digests["small"],
+				wantErr: store.ErrNotFound,
+			},
+			{
+				desc:    "content not found",
+				bucket:  "test-bucket",
+				digest:  "missing-digest",
+				wantErr: store.ErrNotFound,
+			},
+			{
+				desc:    "content of other bucket",
+				bucket:  "test-bucket",
+				digest:  digests["deleted"],
+				wantErr: store.ErrNotFound,
+			},
+			{
+				desc:    "deleted content",
+				bucket:  "other-bucket",
+				digest:  digests["deleted"],
+				wantErr: store.ErrNotFound,
+			},
+			{
+				desc:        "small content",
+				bucket:      "test-bucket",
+				digest:      digests["small"],
+				wantContent: contents["small"],
+			},
+			{
+				desc:        "large content",
+				bucket:      "test-bucket",
+				digest:      digests["large"],
+				wantContent: contents["large"],
+			},
+			{
+				desc:        "previous version",
+				bucket:      "test-bucket",
+				digest:      digests["previous"],
+				wantContent: contents["previous"],
+			},
+		}
+
+		for _, tc := range tt {
+			tc := tc
+			t.Run(fmt.Sprintf("%s chunking=%v", tc.desc, s.chunking != nil), func(t *testing.T) {
+				t.Parallel()
+
+				reader, size, err := s.GetContent(tc.bucket, tc.digest)
+				if !testutil.EqualErrorMessage(err, tc.wantErr) {
+					t.Errorf("got error %q, want %q", err, tc.wantErr)
+				}
+
+				if err != nil {
+					return
+				}
+
+				if size != int64(len(tc.wantContent)) {
+					t.Errorf("got size %d, want %d", size, len(tc.wantContent))
+				}
+
+				if content := testutil.ReadAll(t, reader); content != tc.wantContent {
+					t.Errorf("got content of length %d, want %d", len(content), len(tc.wantContent))
+				}
+			})
+		}
+	}
+}
+
+func TestPutReference(t *testing.T) {
+	large := make([]byte, 4096)
+	rand.New(rand.NewSource(1)).Read(large)
+
+	largeDigest, err := digest.SHA256(strings.NewReader(string(large)))
+	if err != nil {
+		t.Fatalf("can not create digest: %s", err)
+	}
+
+	tt := []struct {
+		desc        string
+		objectID    string
+		digest      digest.Digest
+		opts        store.PutOptions
+		wantContent string
+		wantErr     error
+	}{
+		{
+			desc:     "content not found",
+			objectID: "linked-object",
+			digest:   "missing-digest",
+			wantErr:  store.ErrNotFound,
+		},
+		{
+			desc:     "precondition failed",
+			objectID: "test-object",
+			digest:   largeDigest,
+			opts: store.PutOptions{
+				Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+		{
+			desc:        "new object",
+			objectID:    "linked-object",
+			digest:      largeDigest,
+			wantContent: string(large),
+		},
+		{
+			desc:        "overwrite object",
+			objectID:    "small-object",
+			digest:      largeDigest,
+			wantContent: string(large),
+		},
+	}
+
+	for _, opts := range [][]Option{nil, {WithChunking(chunker.Config{MinSize: 16, AvgSize: 64, MaxSize: 256})}} {
+		opts := opts
+		for _, tc := range tt {
+			tc := tc
+			t.Run(fmt.Sprintf("%s chunking=%v", tc.desc, len(opts) > 0), func(t *testing.T) {
+				t.Parallel()
+
+				s := NewStore(log, opts...)
+				applyOps(t, s, []walOp{
+					{opPut, "test-bucket", "test-object", string(large)},
+					{opPut, "test-bucket", "small-object", "small-content"},
+				})
+				before := s.Stats().Buckets["test-bucket"]
+
+				meta, err := s.PutReference("test-bucket", tc.objectID, tc.digest, tc.opts)
+				if !testutil.EqualErrorMessage(err, tc.wantErr) {
+					t.Errorf("got error %q, want %q", err, tc.wantErr)
+				}
+
+				if err != nil {
+					return
+				}
+
+				if meta.Digest != tc.digest || meta.Size != int64(len(tc.wantContent)) {
+					t.Errorf("got digest %q and size %d, want %q and %d", meta.Digest, meta.Size, tc.digest, len(tc.wantContent))
+				}
+
+				reader, _, err := s.Get("test-bucket", tc.objectID, store.GetOptions{})
+				if err != nil {
+					t.Fatalf("can not get object: %s", err)
+				}
+
+				if content := testutil.ReadAll(t, reader); content != tc.wantContent {
+					t.Errorf("got content of length %d, want %d", len(content), len(tc.wantContent))
+				}
+
+				after := s.Stats().Buckets["test-bucket"]
+				if after.PhysicalBytes > before.PhysicalBytes {
+					t.Errorf("got %d physical bytes, want at most %d", after.PhysicalBytes, before.PhysicalBytes)
+				}
+			})
+		}
+	}
+}
+
+func TestConditions(t *testing.T) {
+	const testDigest = "0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e"
+
+	tt := []struct {
+		desc      string
+		op        string
+		objectID  string
+		condition store.Condition
+		wantErr   error
+	}{
+		{
+			desc:     "create existing",
+			op:       opPut,
+			objectID: "test-object",
+			condition: store.Condition{
+				IfNoneMatch: []digest.Digest{store.MatchAny},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+		{
+			desc:     "create new",
+			op:       opPut,
+			objectID: "other-object",
+			condition: store.Condition{
+				IfNoneMatch: []digest.Digest{store.MatchAny},
+			},
+		},
+		{
+			desc:     "replace matching",
+			op:       opPut,
+			objectID: "test-object",
+			condition: store.Condition{
+				IfMatch: []digest.Digest{testDigest},
+			},
+		},
+		{
+			desc:     "replace changed",
+			op:       opPut,
+			objectID: "test-object",
+			condition: store.Condition{
+				IfMatch: []digest.Digest{"other-digest"},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+		{
+			desc:     "delete matching",
+			op:       opDelete,
+			objectID: "test-object",
+			condition: store.Condition{
+				IfMatch: []digest.Digest{testDigest},
+			},
+		},
+		{
+			desc:     "delete changed",
+			op:       opDelete,
+			objectID: "test-object",
+			condition: store.Condition{
+				IfMatch: []digest.Digest{"other-digest"},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+		{
+			desc:     "delete missing",
+			op:       opDelete,
+			objectID: "other-object",
+			condition: store.Condition{
+				IfMatch: []digest.Digest{store.MatchAny},
+			},
+			wantErr: store.ErrPreconditionFailed,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log)
+			applyOps(t, s, []walOp{
+				{opPut, "test-bucket", "test-object", "test-content"},
+			})
+			before := s.Stats()
+
+			var err error
+			switch tc.op {
+			case opPut:
+				_, err = s.Put("test-bucket", tc.objectID, strings.NewReader("new-content"), store.PutOptions{
+					Condition: tc.condition,
+				})
+			case opDelete:
+				err = s.Delete("test-bucket", tc.objectID, store.DeleteOptions{
+					Condition: tc.condition,
+				})
+			}
+			if err != tc.wantErr {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if err == nil {
+				return
+			}
+
+			if diff := cmp.Diff(s.Stats(), before); diff != "" {
+				t.Errorf("failed operation changed store: -got+want\n%s", diff)
+			}
+		})
+	}
+}
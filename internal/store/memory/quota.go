@@ -0,0 +1,398 @@
+package memory
+
+import (
+	"container/heap"
+	"fmt"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+// Policy decides how the store handles a Put which exceeds the quota.
+type Policy int
+
+const (
+	// Reject fails the Put with store.ErrInsufficientStorage.
+	Reject Policy = iota
+	// EvictLRU removes the least recently used objects until the content fits.
+	EvictLRU
+	// EvictLFU removes the least frequently used objects until the content fits.
+	EvictLFU
+)
+
+// ParsePolicy returns the policy named "reject", "lru" or "lfu".
+func ParsePolicy(name string) (Policy, error) {
+	switch name {
+	case "reject":
+		return Reject, nil
+	case "lru":
+		return EvictLRU, nil
+	case "lfu":
+		return EvictLFU, nil
+	default:
+		return Reject, fmt.Errorf("unknown policy %q", name)
+	}
+}
+
+// Quota limits the number of content bytes kept in memory. Contents shared by deduplication are only counted once.
+type Quota struct {
+	// Limit is the maximum number of bytes of all buckets. Zero disables the limit.
+	Limit uint64
+	// BucketLimit is the maximum number of bytes of each bucket. Zero disables the limit.
+	BucketLimit uint64
+	Policy      Policy
+}
+
+// WithQuota limits the memory used for contents. The contents of a replaced object are only released after the
+// new contents have been stored, so they count towards the quota during the Put.
+func WithQuota(q Quota) Option {
+	return func(s *Store) {
+		s.quota = &q
+	}
+}
+
+// access records how an object has been used for choosing the objects to evict.
+type access struct {
+	objectID string
+	// last is the logical time of the last access.
+	last  uint64
+	count uint64
+	// index is the position of the record in the queue.
+	index int
+}
+
+// accessQueue is a heap of the access records of all objects of a bucket. The object to evict first according to the
+// policy is at the top, so that the objects do not need to be scanned for every eviction.
+type accessQueue struct {
+	records []*access
+	objects map[string]*access
+	lfu     bool
+}
+
+func newAccessQueue(policy Policy) *accessQueue {
+	return &accessQueue{
+		objects: make(map[string]*access),
+		lfu:     policy == EvictLFU,
+	}
+}
+
+// evictBefore returns true if an object with access a should be evicted before one with access other.
+func (q *accessQueue) evictBefore(a, other *access) bool {
+	if q.lfu && a.count != other.count {
+		return a.count < other.count
+	}
+
+	return a.last < other.last
+}
+
+func (q *accessQueue) Len() int {
+	return len(q.records)
+}
+
+func (q *accessQueue) Less(i, j int) bool {
+	return q.evictBefore(q.records[i], q.records[j])
+}
+
+func (q *accessQueue) Swap(i, j int) {
+	q.records[i], q.records[j] = q.records[j], q.records[i]
+	q.records[i].index = i
+	q.records[j].index = j
+}
+
+func (q *accessQueue) Push(x interface{}) {
+	a := x.(*access)
+	a.index = len(q.records)
+	q.records = append(q.records, a)
+}
+
+func (q *accessQueue) Pop() interface{} {
+	last := len(q.records) - 1
+	a := q.records[last]
+	q.records[last] = nil
+	q.records = q.records[:last]
+	return a
+}
+
+func (s *Store) evicting() bool {
+	return s.quota != nil && s.quota.Policy != Reject
+}
+
+// record returns the access record of the object and adds a new one if it has none yet. It needs to be called with
+// the access lock held.
+func (s *Store) record(b *bucket, objectID string) *access {
+	if b.access == nil {
+		b.access = newAccessQueue(s.quota.Policy)
+	}
+
+	a, ok := b.access.objects[objectID]
+	if !ok {
+		a = &access{objectID: objectID}
+		b.access.objects[objectID] = a
+		heap.Push(b.access, a)
+	}
+
+	return a
+}
+
+// track adds an object to the candidates for eviction. Objects which have not been accessed yet are evicted first.
+func (s *Store) track(b *bucket, objectID string) {
+	if !s.evicting() {
+		return
+	}
+
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+
+	s.record(b, objectID)
+}
+
+// touch records an access of the object. It can be called with the read lock held.
+func (s *Store) touch(b *bucket, objectID string) {
+	if !s.evicting() {
+		return
+	}
+
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+
+	s.clock++
+	a := s.record(b, objectID)
+	a.last = s.clock
+	a.count++
+	heap.Fix(b.access, a.index)
+}
+
+// forget removes the access record of a deleted object.
+func (s *Store) forget(b *bucket, objectID string) {
+	if b.access == nil {
+		return
+	}
+
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+
+	a, ok := b.access.objects[objectID]
+	if !ok {
+		return
+	}
+
+	heap.Remove(b.access, a.index)
+	delete(b.access.objects, objectID)
+}
+
+// reserve makes sure that the chunks of an object fit into the quota, evicting other objects if the policy allows it.
+// It needs to be called with the write lock held before the object is saved.
+func (s *Store) reserve(bucketName, objectID string, chunks []chunk) error {
+	return s.reserveBytes(bucketName, objectID, contentSize(chunks), func(b *bucket) (uint64, uint64) {
+		return s.required(b, chunks)
+	})
+}
+
+// reserveBytes evicts objects until the bytes returned by required fit into the quota. Nothing is evicted if size, the
+// number of bytes needed even if no other object was stored, does not fit on its own next to the staged uploads.
+// It needs to be called with the write lock held.
+func (s *Store) reserveBytes(bucketName, objectID string, size uint64, required func(b *bucket) (bucketBytes, globalBytes uint64)) error {
+	if s.quota == nil {
+		return nil
+	}
+
+	for {
+		bucketBytes, globalBytes := required(s.buckets[bucketName])
+		overBucket, overGlobal := s.overQuota(bucketName, bucketBytes, globalBytes)
+		if !overBucket && !overGlobal {
+			return nil
+		}
+
+		if s.quota.Policy == Reject || s.exceedsQuota(bucketName, size) {
+			return store.ErrInsufficientStorage
+		}
+
+		victimBucket, victim, ok := s.victim(bucketName, objectID, !overBucket)
+		if !ok {
+			return store.ErrInsufficientStorage
+		}
+
+		if err := s.logRecord(walRecord{
+			Op:       opEvict,
+			Bucket:   victimBucket,
+			ObjectID: victim,
+		}); err != nil {
+			return err
+		}
+
+		vb := s.buckets[victimBucket]
+		s.evictObject(vb, victim)
+		vb.evicted++
+	}
+}
+
+// overQuota returns which limits of the quota would be exceeded by adding the bytes to the bucket and the store.
+// The parts of uploads in progress count towards the limits.
+func (s *Store) overQuota(bucketName string, bucketBytes, globalBytes uint64) (overBucket, overGlobal bool) {
+	stagedBucket, stagedGlobal := s.staged(bucketName)
+
+	var bucketSize uint64
+	if b, ok := s.buckets[bucketName]; ok {
+		bucketSize = b.size
+	}
+
+	overBucket = s.quota.BucketLimit > 0 && bucketSize+stagedBucket+bucketBytes > s.quota.BucketLimit
+	overGlobal = s.quota.Limit > 0 && s.size+stagedGlobal+globalBytes > s.quota.Limit
+	return overBucket, overGlobal
+}
+
+// exceedsQuota returns true if size bytes do not fit into the quota even after evicting all objects.
+func (s *Store) exceedsQuota(bucketName string, size uint64) bool {
+	stagedBucket, stagedGlobal := s.staged(bucketName)
+	return (s.quota.BucketLimit > 0 && stagedBucket+size > s.quota.BucketLimit) ||
+		(s.quota.Limit > 0 && stagedGlobal+size > s.quota.Limit)
+}
+
+// staged returns the number of bytes of the parts of uploads in progress into the bucket and into all buckets.
+func (s *Store) staged(bucketName string) (bucketBytes, globalBytes uint64) {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	for _, u := range s.uploads {
+		for _, content := range u.contents {
+			size := uint64(len(content))
+			globalBytes += size
+			if u.bucket == bucketName {
+				bucketBytes += size
+			}
+		}
+	}
+
+	return bucketBytes, globalBytes
+}
+
+// contentSize returns the number of bytes of the distinct chunks.
+func contentSize(chunks []chunk) uint64 {
+	seen := make(map[digest.Digest]bool, len(chunks))
+	var size uint64
+	for _, c := range chunks {
+		if seen[c.digest] {
+			continue
+		}
+		seen[c.digest] = true
+
+		size += uint64(len(c.content))
+	}
+
+	return size
+}
+
+// required returns the number of bytes the chunks add to the bucket and to the whole store.
+func (s *Store) required(b *bucket, chunks []chunk) (bucketBytes, globalBytes uint64) {
+	seen := make(map[digest.Digest]bool, len(chunks))
+	for _, c := range chunks {
+		if seen[c.digest] {
+			continue
+		}
+		seen[c.digest] = true
+
+		if b != nil {
+			if _, ok := b.contents[c.digest]; ok {
+				continue
+			}
+		}
+
+		size := uint64(len(c.content))
+		bucketBytes += size
+		if s.shared != nil {
+			if _, ok := s.shared.contents[c.digest]; ok {
+				continue
+			}
+		}
+		globalBytes += size
+	}
+
+	return bucketBytes, globalBytes
+}
+
+// victim selects the object to evict according to the policy. Objects are only taken from the bucket being written
+// to, unless anyBucket is set. The object being written is never selected and neither are objects whose contents are
+// all still referenced by other objects, as evicting them would not free any bytes.
+func (s *Store) victim(bucketName, objectID string, anyBucket bool) (string, string, bool) {
+	s.accessMutex.Lock()
+	defer s.accessMutex.Unlock()
+
+	// The records are taken from the queues in the order of the policy and put back once the victim has been found.
+	type candidate struct {
+		b *bucket
+		a *access
+	}
+	var taken []candidate
+	defer func() {
+		for _, c := range taken {
+			heap.Push(c.b.access, c.a)
+		}
+	}()
+
+	for {
+		var victimBucket string
+		var next *bucket
+		for name, b := range s.buckets {
+			if (!anyBucket && name != bucketName) || b.access == nil || b.access.Len() == 0 {
+				continue
+			}
+
+			if next == nil || b.access.evictBefore(b.access.records[0], next.access.records[0]) {
+				victimBucket, next = name, b
+			}
+		}
+
+		if next == nil {
+			return "", "", false
+		}
+
+		a := heap.Pop(next.access).(*access)
+		taken = append(taken, candidate{b: next, a: a})
+		if victimBucket == bucketName && a.objectID == objectID {
+			continue
+		}
+
+		if s.frees(next, a.objectID, anyBucket) {
+			return victimBucket, a.objectID, true
+		}
+	}
+}
+
+// frees returns true if evicting the object releases any content of the bucket or, if global is set, of the store.
+// Contents stay in the bucket while other objects or versions reference them, and in the store while other buckets
+// do when deduplicating globally.
+func (s *Store) frees(b *bucket, objectID string, global bool) bool {
+	refs := make(map[digest.Digest]uint)
+	for _, d := range b.objects[objectID] {
+		refs[d]++
+	}
+	for _, v := range b.versions[objectID] {
+		for _, d := range v.chunks {
+			refs[d]++
+		}
+	}
+
+	for d, count := range refs {
+		if b.refs[d] > count || len(b.contents[d]) == 0 {
+			continue
+		}
+
+		if !global || s.shared == nil || s.shared.refs[d] <= 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// evictObject removes the object together with all of its previous versions.
+func (s *Store) evictObject(b *bucket, objectID string) {
+	for _, v := range b.versions[objectID] {
+		s.unreference(b, v.chunks)
+	}
+	delete(b.versions, objectID)
+
+	if _, ok := b.objects[objectID]; ok {
+		s.deleteObject(b, objectID)
+	}
+}
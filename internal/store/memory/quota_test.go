@@ -0,0 +1,322 @@
+package memory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+type quotaOp struct {
+	op       string
+	bucket   string
+	objectID string
+	content  string
+	wantErr  error
+}
+
+const (
+	opGet    = "get"
+	opUpload = "upload"
+	opPart   = "part"
+)
+
+func TestQuota(t *testing.T) {
+	tt := []struct {
+		desc        string
+		quota       Quota
+		ops         []quotaOp
+		wantObjects map[string][]string
+		wantEvicted uint
+	}{
+		{
+			desc:  "reject global",
+			quota: Quota{Limit: 25, Policy: Reject},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-b", "object-2", "content-02", nil},
+				{opPut, "bucket-b", "object-3", "content-03", store.ErrInsufficientStorage},
+				{opPut, "bucket-a", "object-4", "content-02", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1", "object-4"},
+				"bucket-b": {"object-2"},
+			},
+		},
+		{
+			desc:  "reject bucket",
+			quota: Quota{BucketLimit: 15, Policy: Reject},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-02", store.ErrInsufficientStorage},
+				{opPut, "bucket-a", "object-2", "content-01", nil},
+				{opPut, "bucket-b", "object-3", "content-03", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1", "object-2"},
+				"bucket-b": {"object-3"},
+			},
+		},
+		{
+			desc:  "larger than quota",
+			quota: Quota{Limit: 5, Policy: EvictLRU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", store.ErrInsufficientStorage},
+			},
+			wantObjects: map[string][]string{},
+		},
+		{
+			desc:  "larger than quota with objects",
+			quota: Quota{Limit: 25, Policy: EvictLRU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-b", "object-2", "content-02", nil},
+				{opPut, "bucket-a", "object-3", strings.Repeat("content-03", 10), store.ErrInsufficientStorage},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1"},
+				"bucket-b": {"object-2"},
+			},
+		},
+		{
+			desc:  "larger than bucket quota with objects",
+			quota: Quota{BucketLimit: 25, Policy: EvictLFU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-02", nil},
+				{opPut, "bucket-a", "object-3", strings.Repeat("content-03", 3), store.ErrInsufficientStorage},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1", "object-2"},
+			},
+		},
+		{
+			desc:  "reject staged parts",
+			quota: Quota{Limit: 25, Policy: Reject},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opUpload, "bucket-a", "object-2", "", nil},
+				{opPart, "bucket-a", "object-2", "content-02", nil},
+				{opPart, "bucket-a", "object-2", "content-03", store.ErrInsufficientStorage},
+				{opPut, "bucket-a", "object-3", "content-03", store.ErrInsufficientStorage},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1"},
+			},
+		},
+		{
+			desc:  "evict for staged parts",
+			quota: Quota{Limit: 25, Policy: EvictLRU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-02", nil},
+				{opUpload, "bucket-a", "object-3", "", nil},
+				{opPart, "bucket-a", "object-3", "content-03", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-2"},
+			},
+			wantEvicted: 1,
+		},
+		{
+			desc:  "evict least recently used",
+			quota: Quota{Limit: 30, Policy: EvictLRU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-02", nil},
+				{opPut, "bucket-b", "object-3", "content-03", nil},
+				{opGet, "bucket-a", "object-1", "", nil},
+				{opPut, "bucket-b", "object-4", "content-04", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1"},
+				"bucket-b": {"object-3", "object-4"},
+			},
+			wantEvicted: 1,
+		},
+		{
+			desc:  "evict least frequently used",
+			quota: Quota{Limit: 30, Policy: EvictLFU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-02", nil},
+				{opPut, "bucket-a", "object-3", "content-03", nil},
+				{opGet, "bucket-a", "object-1", "", nil},
+				{opGet, "bucket-a", "object-2", "", nil},
+				{opGet, "bucket-a", "object-2", "", nil},
+				{opPut, "bucket-a", "object-4", "content-04", nil},
+				{opGet, "bucket-a", "object-4", "", nil},
+				{opPut, "bucket-a", "object-5", "content-05", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-2", "object-4", "object-5"},
+			},
+			wantEvicted: 2,
+		},
+		{
+			desc:  "evict within bucket",
+			quota: Quota{BucketLimit: 20, Policy: EvictLRU},
+			ops: []quotaOp{
+				{opPut, "bucket-b", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-02", nil},
+				{opPut, "bucket-a", "object-3", "content-03", nil},
+				{opPut, "bucket-a", "object-4", "content-04", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-3", "object-4"},
+				"bucket-b": {"object-1"},
+			},
+			wantEvicted: 1,
+		},
+		{
+			desc:  "skip objects with contents shared between buckets",
+			quota: Quota{Limit: 25, Policy: EvictLRU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-b", "object-2", "content-01", nil},
+				{opPut, "bucket-a", "object-3", "content-03", nil},
+				{opPut, "bucket-b", "object-4", "content-04", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1"},
+				"bucket-b": {"object-2", "object-4"},
+			},
+			wantEvicted: 1,
+		},
+		{
+			desc:  "skip objects with contents shared within bucket",
+			quota: Quota{BucketLimit: 25, Policy: EvictLFU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-01", nil},
+				{opPut, "bucket-a", "object-3", "content-03", nil},
+				{opGet, "bucket-a", "object-3", "", nil},
+				{opPut, "bucket-a", "object-4", "content-04", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1", "object-2", "object-4"},
+			},
+			wantEvicted: 1,
+		},
+		{
+			desc:  "shared content counted once",
+			quota: Quota{Limit: 20, Policy: EvictLRU},
+			ops: []quotaOp{
+				{opPut, "bucket-a", "object-1", "content-01", nil},
+				{opPut, "bucket-a", "object-2", "content-02", nil},
+				{opPut, "bucket-b", "object-3", "content-01", nil},
+				{opPut, "bucket-b", "object-4", "content-02", nil},
+			},
+			wantObjects: map[string][]string{
+				"bucket-a": {"object-1", "object-2"},
+				"bucket-b": {"object-3", "object-4"},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			s := NewStore(log, WithGlobalDeduplication(), WithQuota(tc.quota))
+			uploads := make(map[string]string)
+			parts := make(map[string]int)
+			for i, o := range tc.ops {
+				var err error
+				switch o.op {
+				case opPut:
+					_, err = s.Put(o.bucket, o.objectID, strings.NewReader(o.content), store.PutOptions{})
+				case opGet:
+					_, err = s.Head(o.bucket, o.objectID, store.GetOptions{})
+				case opUpload:
+					uploads[o.objectID], err = s.CreateUpload(o.bucket, o.objectID, store.PutOptions{})
+				case opPart:
+					parts[o.objectID]++
+					_, err = s.PutPart(o.bucket, o.objectID, uploads[o.objectID], parts[o.objectID], strings.NewReader(o.content))
+				}
+
+				if err != o.wantErr {
+					t.Errorf("got error %q in operation %d, want %q", err, i, o.wantErr)
+				}
+			}
+
+			objects := make(map[string][]string)
+			for name := range s.buckets {
+				list, err := s.List(name, store.ListOptions{})
+				if err != nil {
+					t.Fatalf("can not list %s: %s", name, err)
+				}
+				objects[name] = list.Objects
+			}
+
+			if diff := cmp.Diff(objects, tc.wantObjects); diff != "" {
+				t.Errorf("objects differ: -got+want\n%s", diff)
+			}
+
+			if evicted := s.Stats().Evicted; evicted != tc.wantEvicted {
+				t.Errorf("got %d evicted objects, want %d", evicted, tc.wantEvicted)
+			}
+		})
+	}
+}
+
+func TestPersistentEviction(t *testing.T) {
+	dir := t.TempDir()
+	before, err := NewPersistentStore(log, dir, 0, WithQuota(Quota{Limit: 20, Policy: EvictLRU}))
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	applyOps(t, before, []walOp{
+		{opPut, "test-bucket", "object-1", "content-01"},
+		{opPut, "test-bucket", "object-2", "content-02"},
+		{opPut, "test-bucket", "object-3", "content-03"},
+	})
+
+	if err := before.Close(); err != nil {
+		t.Fatalf("can not close store: %s", err)
+	}
+
+	quota := WithQuota(Quota{Limit: 20, Policy: EvictLRU})
+	after, err := NewPersistentStore(log, dir, 0, quota)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+
+	list, err := after.List("test-bucket", store.ListOptions{})
+	if err != nil {
+		t.Fatalf("can not list objects: %s", err)
+	}
+
+	if diff := cmp.Diff(list.Objects, []string{"object-2", "object-3"}); diff != "" {
+		t.Errorf("objects differ: -got+want\n%s", diff)
+	}
+
+	if after.size != 20 {
+		t.Errorf("got size %d, want 20", after.size)
+	}
+
+	// Restored objects are evicted before the objects which have been accessed since.
+	if _, err := after.Head("test-bucket", "object-2", store.GetOptions{}); err != nil {
+		t.Fatalf("can not get object: %s", err)
+	}
+
+	applyOps(t, after, []walOp{
+		{opPut, "test-bucket", "object-4", "content-04"},
+	})
+
+	list, err = after.List("test-bucket", store.ListOptions{})
+	if err != nil {
+		t.Fatalf("can not list objects: %s", err)
+	}
+
+	if diff := cmp.Diff(list.Objects, []string{"object-2", "object-4"}); diff != "" {
+		t.Errorf("objects differ after eviction: -got+want\n%s", diff)
+	}
+
+	if err := after.Close(); err != nil {
+		t.Fatalf("can not close store: %s", err)
+	}
+}
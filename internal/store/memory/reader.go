@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// objectReader reads the chunks of an object as one continuous content.
+// Seeking only moves the offset, so that parts of large objects can be read without copying all chunks.
+type objectReader struct {
+	chunks []string
+	// starts contains the offset of the first byte of each chunk.
+	starts []int64
+	size   int64
+	offset int64
+}
+
+func newObjectReader(chunks []string) *objectReader {
+	starts := make([]int64, 0, len(chunks))
+	var size int64
+	for _, c := range chunks {
+		starts = append(starts, size)
+		size += int64(len(c))
+	}
+
+	return &objectReader{
+		chunks: chunks,
+		starts: starts,
+		size:   size,
+	}
+}
+
+func (r *objectReader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	// Find the last chunk starting at or before the offset. Empty chunks are skipped that way.
+	i := sort.Search(len(r.starts), func(i int) bool {
+		return r.starts[i] > r.offset
+	}) - 1
+
+	n := copy(p, r.chunks[i][r.offset-r.starts[i]:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *objectReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *objectReader) Close() error {
+	return nil
+}
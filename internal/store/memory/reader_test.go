@@ -0,0 +1,92 @@
+package memory
+
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestObjectReader(t *testing.T) {
+	chunks := []string{"abc", "", "defg", "h"}
+
+	tt := []struct {
+		desc       string
+		offset     int64
+		whence     int
+		wantPos    int64
+		wantErr    bool
+		wantRemain string
+	}{
+		{
+			desc:       "start",
+			offset:     0,
+			whence:     io.SeekStart,
+			wantPos:    0,
+			wantRemain: "abcdefgh",
+		},
+		{
+			desc:       "chunk boundary",
+			offset:     3,
+			whence:     io.SeekStart,
+			wantPos:    3,
+			wantRemain: "defgh",
+		},
+		{
+			desc:       "inside chunk",
+			offset:     5,
+			whence:     io.SeekStart,
+			wantPos:    5,
+			wantRemain: "fgh",
+		},
+		{
+			desc:       "from end",
+			offset:     -2,
+			whence:     io.SeekEnd,
+			wantPos:    6,
+			wantRemain: "gh",
+		},
+		{
+			desc:       "past end",
+			offset:     10,
+			whence:     io.SeekStart,
+			wantPos:    10,
+			wantRemain: "",
+		},
+		{
+			desc:    "negative",
+			offset:  -1,
+			whence:  io.SeekStart,
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := newObjectReader(chunks)
+			pos, err := r.Seek(tc.offset, tc.whence)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tc.wantErr)
+			}
+
+			if err != nil {
+				return
+			}
+
+			if pos != tc.wantPos {
+				t.Errorf("got position %d, want %d", pos, tc.wantPos)
+			}
+
+			remain, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("can not read: %s", err)
+			}
+
+			if string(remain) != tc.wantRemain {
+				t.Errorf("got content %q, want %q", remain, tc.wantRemain)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/xperimental/bukky/internal/store"
+)
+
+// upload is a multipart upload in progress. Uploads are not written to the write-ahead log.
+type upload struct {
+	bucket   string
+	objectID string
+	opts     store.PutOptions
+	parts    map[int]store.Part
+	contents map[int][]byte
+	created  time.Time
+}
+
+func (s *Store) CreateUpload(bucketName, objectID string, opts store.PutOptions) (string, error) {
+	uploadID, err := store.NewUploadID()
+	if err != nil {
+		return "", err
+	}
+
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	s.uploads[uploadID] = &upload{
+		bucket:   bucketName,
+		objectID: objectID,
+		opts:     opts,
+		parts:    make(map[int]store.Part),
+		contents: make(map[int][]byte),
+		created:  s.now(),
+	}
+	return uploadID, nil
+}
+
+// upload returns the upload if it belongs to the object. It needs to be called with the upload lock held.
+func (s *Store) upload(bucketName, objectID, uploadID string) (*upload, error) {
+	u, ok := s.uploads[uploadID]
+	if !ok || u.bucket != bucketName || u.objectID != objectID {
+		return nil, store.ErrUploadNotFound
+	}
+
+	return u, nil
+}
+
+func (s *Store) PutPart(bucketName, objectID, uploadID string, number int, content io.Reader) (store.Part, error) {
+	if err := store.ValidatePartNumber(number); err != nil {
+		return store.Part{}, err
+	}
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return store.Part{}, fmt.Errorf("can not read content: %w", err)
+	}
+
+	d, err := s.digester(bytes.NewReader(data))
+	if err != nil {
+		return store.Part{}, fmt.Errorf("can not create digest: %w", err)
+	}
+
+	// Staged parts count towards the quota, so that uploads can not exceed it before they are completed.
+	if s.quota != nil {
+		s.bucketMutex.Lock()
+		defer s.bucketMutex.Unlock()
+
+		if err := s.reservePart(bucketName, objectID, uploadID, number, uint64(len(data))); err != nil {
+			return store.Part{}, err
+		}
+	}
+
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	u, err := s.upload(bucketName, objectID, uploadID)
+	if err != nil {
+		return store.Part{}, err
+	}
+
+	part := store.Part{
+		Number: number,
+		Size:   int64(len(data)),
+		Digest: d,
+	}
+	u.parts[number] = part
+	u.contents[number] = data
+	return part, nil
+}
+
+// reservePart makes sure that the part fits into the quota, evicting other objects if the policy allows it. A part
+// replacing one with the same number only needs the additional bytes. It needs to be called with the write lock held.
+func (s *Store) reservePart(bucketName, objectID, uploadID string, number int, size uint64) error {
+	s.uploadMutex.Lock()
+	u, err := s.upload(bucketName, objectID, uploadID)
+	var replaced uint64
+	if err == nil {
+		replaced = uint64(len(u.contents[number]))
+	}
+	s.uploadMutex.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	if size <= replaced {
+		return nil
+	}
+
+	additional := size - replaced
+	return s.reserveBytes(bucketName, objectID, additional, func(*bucket) (uint64, uint64) {
+		return additional, additional
+	})
+}
+
+func (s *Store) ListParts(bucketName, objectID, uploadID string) ([]store.Part, error) {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	u, err := s.upload(bucketName, objectID, uploadID)
+	if err != nil {
+		return nil, err
+	}
+
+	return store.SortedParts(u.parts), nil
+}
+
+func (s *Store) CompleteUpload(bucketName, objectID, uploadID string, requested []store.Part) (store.Metadata, error) {
+	s.uploadMutex.Lock()
+	u, err := s.upload(bucketName, objectID, uploadID)
+	if err != nil {
+		s.uploadMutex.Unlock()
+		return store.Metadata{}, err
+	}
+
+	parts, err := store.SelectParts(u.parts, requested)
+	if err != nil {
+		s.uploadMutex.Unlock()
+		return store.Metadata{}, err
+	}
+
+	// The upload is removed while the object is saved, so that it can not be completed twice.
+	delete(s.uploads, uploadID)
+	s.uploadMutex.Unlock()
+
+	readers := make([]io.Reader, 0, len(parts))
+	for _, p := range parts {
+		readers = append(readers, bytes.NewReader(u.contents[p.Number]))
+	}
+
+	meta, err := s.Put(bucketName, objectID, io.MultiReader(readers...), u.opts)
+	if err != nil {
+		s.uploadMutex.Lock()
+		s.uploads[uploadID] = u
+		s.uploadMutex.Unlock()
+		return store.Metadata{}, err
+	}
+
+	return meta, nil
+}
+
+func (s *Store) AbortUpload(bucketName, objectID, uploadID string) error {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	if _, err := s.upload(bucketName, objectID, uploadID); err != nil {
+		return err
+	}
+
+	delete(s.uploads, uploadID)
+	return nil
+}
+
+// removeUploads discards all uploads of the bucket, so that they can not be completed after the bucket has been
+// deleted. It needs to be called with the write lock held.
+func (s *Store) removeUploads(bucketName string) {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	for uploadID, u := range s.uploads {
+		if u.bucket == bucketName {
+			delete(s.uploads, uploadID)
+		}
+	}
+}
+
+// expireUploads discards all uploads started more than store.UploadMaxAge ago and returns their number.
+// It needs to be called with the write lock held.
+func (s *Store) expireUploads(now time.Time) uint {
+	s.uploadMutex.Lock()
+	defer s.uploadMutex.Unlock()
+
+	var expired uint
+	for uploadID, u := range s.uploads {
+		if now.Sub(u.created) > store.UploadMaxAge {
+			delete(s.uploads, uploadID)
+			expired++
+		}
+	}
+
+	return expired
+}
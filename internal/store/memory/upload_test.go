@@ -0,0 +1,165 @@
+package memory
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/testutil"
+)
+
+func TestMultipartUpload(t *testing.T) {
+	s := NewStore(log)
+	uploadID, err := s.CreateUpload("test-bucket", "test-object", store.PutOptions{
+		ContentType: "text/plain",
+	})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	for _, p := range []struct {
+		number  int
+		content string
+	}{
+		{2, "content"},
+		{1, "old-"},
+		{1, "test-"},
+	} {
+		if _, err := s.PutPart("test-bucket", "test-object", uploadID, p.number, strings.NewReader(p.content)); err != nil {
+			t.Fatalf("can not put part %d: %s", p.number, err)
+		}
+	}
+
+	if _, err := s.PutPart("test-bucket", "test-object", uploadID, 0, strings.NewReader("")); err != store.ErrInvalidPart {
+		t.Errorf("got error %q, want %q", err, store.ErrInvalidPart)
+	}
+
+	if _, err := s.ListParts("test-bucket", "other-object", uploadID); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	parts, err := s.ListParts("test-bucket", "test-object", uploadID)
+	if err != nil {
+		t.Fatalf("can not list parts: %s", err)
+	}
+
+	wantParts := []store.Part{
+		{Number: 1, Size: 5, Digest: "e4942d940986fe9be0174838636685a1cec37394cbcf2bb5b9eb0eb19ebbd030"},
+		{Number: 2, Size: 7, Digest: "ed7002b439e9ac845f22357d822bac1444730fbdb6016d3ec9432297b9ec9f73"},
+	}
+	if diff := cmp.Diff(parts, wantParts); diff != "" {
+		t.Errorf("parts differ: -got+want\n%s", diff)
+	}
+
+	if _, err := s.CompleteUpload("test-bucket", "test-object", uploadID, nil); err != nil {
+		t.Fatalf("can not complete upload: %s", err)
+	}
+
+	if _, err := s.CompleteUpload("test-bucket", "test-object", uploadID, nil); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	reader, meta, err := s.Get("test-bucket", "test-object", store.GetOptions{})
+	if err != nil {
+		t.Fatalf("can not get object: %s", err)
+	}
+
+	if content := testutil.ReadAll(t, reader); content != "test-content" {
+		t.Errorf("got content %q, want %q", content, "test-content")
+	}
+
+	wantDigest := "0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e"
+	if string(meta.Digest) != wantDigest || meta.ContentType != "text/plain" {
+		t.Errorf("got metadata %#v, want digest %q and content type %q", meta, wantDigest, "text/plain")
+	}
+}
+
+func TestAbortUpload(t *testing.T) {
+	s := NewStore(log)
+	uploadID, err := s.CreateUpload("test-bucket", "test-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	if _, err := s.PutPart("test-bucket", "test-object", uploadID, 1, strings.NewReader("content")); err != nil {
+		t.Fatalf("can not put part: %s", err)
+	}
+
+	if err := s.AbortUpload("test-bucket", "test-object", uploadID); err != nil {
+		t.Fatalf("can not abort upload: %s", err)
+	}
+
+	if _, err := s.PutPart("test-bucket", "test-object", uploadID, 2, strings.NewReader("content")); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	if len(s.uploads) != 0 {
+		t.Errorf("got %d uploads, want none", len(s.uploads))
+	}
+
+	if s.BucketExists("test-bucket") {
+		t.Errorf("aborted upload created bucket")
+	}
+}
+
+func TestUploadDeleteBucket(t *testing.T) {
+	s := NewStore(log)
+	if err := s.CreateBucket("test-bucket", store.BucketOptions{}); err != nil {
+		t.Fatalf("can not create bucket: %s", err)
+	}
+
+	uploadID, err := s.CreateUpload("test-bucket", "test-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	if _, err := s.PutPart("test-bucket", "test-object", uploadID, 1, strings.NewReader("content")); err != nil {
+		t.Fatalf("can not put part: %s", err)
+	}
+
+	if err := s.DeleteBucket("test-bucket", false); err != nil {
+		t.Fatalf("can not delete bucket: %s", err)
+	}
+
+	if _, err := s.CompleteUpload("test-bucket", "test-object", uploadID, nil); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	if s.BucketExists("test-bucket") {
+		t.Errorf("upload completed into deleted bucket")
+	}
+}
+
+func TestExpireUploads(t *testing.T) {
+	now := testTime
+	s := NewStore(log)
+	s.now = func() time.Time {
+		return now
+	}
+
+	oldID, err := s.CreateUpload("test-bucket", "old-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	now = now.Add(store.UploadMaxAge)
+	newID, err := s.CreateUpload("test-bucket", "new-object", store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not create upload: %s", err)
+	}
+
+	now = now.Add(time.Second)
+	if _, err := s.ExpireObjects(); err != nil {
+		t.Fatalf("can not expire objects: %s", err)
+	}
+
+	if _, err := s.ListParts("test-bucket", "old-object", oldID); err != store.ErrUploadNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrUploadNotFound)
+	}
+
+	if _, err := s.ListParts("test-bucket", "new-object", newID); err != nil {
+		t.Errorf("can not list parts of new upload: %s", err)
+	}
+}
@@ -0,0 +1,272 @@
+package memory
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+// version is a previous version of an object. Its chunks stay referenced until the version is deleted.
+type version struct {
+	chunks       []digest.Digest
+	meta         store.Metadata
+	deleteMarker bool
+}
+
+func (b *bucket) addVersion(objectID string, v version) {
+	if b.versions == nil {
+		b.versions = make(map[string][]version)
+	}
+
+	b.versions[objectID] = append(b.versions[objectID], v)
+}
+
+// matchVersion returns true if the metadata belongs to the version with the ID. Objects saved while versioning was not
+// enabled have no version ID and are selected using store.NullVersionID.
+func matchVersion(meta store.Metadata, versionID string) bool {
+	return meta.VersionID == versionID || (meta.VersionID == "" && versionID == store.NullVersionID)
+}
+
+// keepVersion moves the current object into the history. There is only a single version without ID like in S3, so
+// an older one is released.
+func (s *Store) keepVersion(b *bucket, objectID string) {
+	meta := b.metadata[objectID]
+	if meta.VersionID == "" {
+		s.releaseNullVersion(b, objectID)
+	}
+
+	b.addVersion(objectID, version{
+		chunks: b.objects[objectID],
+		meta:   meta,
+	})
+}
+
+// releaseNullVersion removes the version without ID from the history of the object, because it is replaced.
+func (s *Store) releaseNullVersion(b *bucket, objectID string) {
+	history := b.versions[objectID]
+	for i, v := range history {
+		if v.meta.VersionID != "" {
+			continue
+		}
+
+		s.unreference(b, v.chunks)
+		remaining := make([]version, 0, len(history)-1)
+		remaining = append(remaining, history[:i]...)
+		remaining = append(remaining, history[i+1:]...)
+		b.setVersions(objectID, remaining)
+		return
+	}
+}
+
+// object returns the chunks and metadata of the current version of the object or of the version with the given ID.
+// Delete markers can not be read.
+func (b *bucket) object(objectID, versionID string) ([]digest.Digest, store.Metadata, bool) {
+	if chunks, ok := b.objects[objectID]; ok {
+		meta := b.metadata[objectID]
+		if versionID == "" || matchVersion(meta, versionID) {
+			return chunks, meta, true
+		}
+	}
+
+	if versionID == "" {
+		return nil, store.Metadata{}, false
+	}
+
+	for _, v := range b.versions[objectID] {
+		if matchVersion(v.meta, versionID) && !v.deleteMarker {
+			return v.chunks, v.meta, true
+		}
+	}
+
+	return nil, store.Metadata{}, false
+}
+
+// hasVersion returns true if the object has a version or delete marker with the given ID.
+func (b *bucket) hasVersion(objectID, versionID string) bool {
+	if meta, ok := b.metadata[objectID]; ok && matchVersion(meta, versionID) {
+		return true
+	}
+
+	for _, v := range b.versions[objectID] {
+		if matchVersion(v.meta, versionID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// deleteVersion permanently deletes a single version of the object. It needs to be called with the write lock held.
+func (s *Store) deleteVersion(bucketName, objectID, versionID string) error {
+	b, ok := s.buckets[bucketName]
+	if !ok || !b.hasVersion(objectID, versionID) {
+		return store.ErrNotFound
+	}
+
+	if err := s.logRecord(walRecord{
+		Op:        opDelete,
+		Bucket:    bucketName,
+		ObjectID:  objectID,
+		VersionID: versionID,
+	}); err != nil {
+		return err
+	}
+
+	s.removeVersion(b, objectID, versionID)
+	s.compact()
+	return nil
+}
+
+// removeVersion deletes the version and makes the previous version current if the latest version was deleted.
+func (s *Store) removeVersion(b *bucket, objectID, versionID string) {
+	if meta, ok := b.metadata[objectID]; ok && matchVersion(meta, versionID) {
+		s.deleteObject(b, objectID)
+		s.promoteVersion(b, objectID)
+		return
+	}
+
+	history := b.versions[objectID]
+	for i, v := range history {
+		if !matchVersion(v.meta, versionID) {
+			continue
+		}
+
+		s.unreference(b, v.chunks)
+		remaining := make([]version, 0, len(history)-1)
+		remaining = append(remaining, history[:i]...)
+		remaining = append(remaining, history[i+1:]...)
+		b.setVersions(objectID, remaining)
+
+		if _, ok := b.objects[objectID]; !ok && i == len(history)-1 {
+			s.promoteVersion(b, objectID)
+		}
+		return
+	}
+}
+
+// promoteVersion makes the latest previous version of a deleted object current unless it is a delete marker.
+func (s *Store) promoteVersion(b *bucket, objectID string) {
+	history := b.versions[objectID]
+	if len(history) == 0 {
+		return
+	}
+
+	latest := history[len(history)-1]
+	if latest.deleteMarker {
+		return
+	}
+
+	b.setObject(objectID, latest.chunks, latest.meta)
+	s.track(b, objectID)
+	b.setVersions(objectID, history[:len(history)-1])
+}
+
+func (b *bucket) setVersions(objectID string, history []version) {
+	if len(history) == 0 {
+		delete(b.versions, objectID)
+		return
+	}
+
+	b.versions[objectID] = history
+}
+
+func (s *Store) SetVersioning(bucketName string, enabled bool) error {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return store.ErrNotFound
+	}
+
+	if b.versioning == enabled {
+		return nil
+	}
+
+	if err := s.logRecord(walRecord{
+		Op:      opVersioning,
+		Bucket:  bucketName,
+		Enabled: enabled,
+	}); err != nil {
+		return err
+	}
+
+	b.versioning = enabled
+	s.compact()
+	return nil
+}
+
+func (s *Store) Versioning(bucketName string) (bool, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return false, store.ErrNotFound
+	}
+
+	return b.versioning, nil
+}
+
+func (s *Store) ListVersions(bucketName, prefix string) ([]store.Version, error) {
+	s.bucketMutex.RLock()
+	defer s.bucketMutex.RUnlock()
+
+	b, ok := s.buckets[bucketName]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+
+	ids := make(map[string]bool)
+	for id := range b.objects {
+		ids[id] = true
+	}
+	for id := range b.versions {
+		ids[id] = true
+	}
+
+	sorted := make([]string, 0, len(ids))
+	for id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			sorted = append(sorted, id)
+		}
+	}
+	sort.Strings(sorted)
+
+	var versions []store.Version
+	for _, id := range sorted {
+		if _, ok := b.objects[id]; ok {
+			versions = append(versions, newVersion(id, b.metadata[id], false))
+		}
+
+		history := b.versions[id]
+		for i := len(history) - 1; i >= 0; i-- {
+			versions = append(versions, newVersion(id, history[i].meta, history[i].deleteMarker))
+		}
+	}
+
+	// The first version of each object is the latest one.
+	for i := range versions {
+		versions[i].IsLatest = i == 0 || versions[i-1].ObjectID != versions[i].ObjectID
+	}
+
+	return versions, nil
+}
+
+func newVersion(objectID string, meta store.Metadata, deleteMarker bool) store.Version {
+	versionID := meta.VersionID
+	if versionID == "" {
+		versionID = store.NullVersionID
+	}
+
+	return store.Version{
+		ObjectID:     objectID,
+		VersionID:    versionID,
+		DeleteMarker: deleteMarker,
+		Modified:     meta.Modified,
+		Size:         meta.Size,
+		Digest:       meta.Digest,
+	}
+}
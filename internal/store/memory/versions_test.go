@@ -0,0 +1,335 @@
+package memory
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func readVersion(t *testing.T, s *Store, objectID, versionID string) string {
+	t.Helper()
+
+	reader, _, err := s.Get("test-bucket", objectID, store.GetOptions{VersionID: versionID})
+	if err != nil {
+		t.Fatalf("can not get version %q: %s", versionID, err)
+	}
+	defer reader.Close()
+
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("can not read version %q: %s", versionID, err)
+	}
+
+	return string(data)
+}
+
+func TestVersioning(t *testing.T) {
+	s := NewStore(log)
+	if err := s.CreateBucket("test-bucket", store.BucketOptions{}); err != nil {
+		t.Fatalf("can not create bucket: %s", err)
+	}
+
+	if err := s.SetVersioning("test-bucket", true); err != nil {
+		t.Fatalf("can not enable versioning: %s", err)
+	}
+
+	var versionIDs []string
+	for _, content := range []string{"content-a", "content-b", "content-a"} {
+		meta, err := s.Put("test-bucket", "test-object", strings.NewReader(content), store.PutOptions{})
+		if err != nil {
+			t.Fatalf("can not put object: %s", err)
+		}
+
+		if meta.VersionID == "" {
+			t.Fatalf("got empty version ID")
+		}
+		versionIDs = append(versionIDs, meta.VersionID)
+	}
+
+	if got := s.Stats().Buckets["test-bucket"].NumContents; got != 2 {
+		t.Errorf("got %d contents, want 2", got)
+	}
+
+	for i, want := range []string{"content-a", "content-b", "content-a"} {
+		if got := readVersion(t, s, "test-object", versionIDs[i]); got != want {
+			t.Errorf("got version %d content %q, want %q", i, got, want)
+		}
+	}
+
+	meta, err := s.Head("test-bucket", "test-object", store.GetOptions{})
+	if err != nil {
+		t.Fatalf("can not get metadata: %s", err)
+	}
+
+	if meta.VersionID != versionIDs[2] {
+		t.Errorf("got current version %q, want %q", meta.VersionID, versionIDs[2])
+	}
+
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{}); err != nil {
+		t.Fatalf("can not delete object: %s", err)
+	}
+
+	if _, err := s.Head("test-bucket", "test-object", store.GetOptions{}); err != store.ErrNotFound {
+		t.Errorf("got error %q after delete, want %q", err, store.ErrNotFound)
+	}
+
+	if got := readVersion(t, s, "test-object", versionIDs[1]); got != "content-b" {
+		t.Errorf("got previous content %q after delete, want %q", got, "content-b")
+	}
+
+	if err := s.DeleteBucket("test-bucket", false); err != store.ErrBucketNotEmpty {
+		t.Errorf("got error %q deleting bucket with versions, want %q", err, store.ErrBucketNotEmpty)
+	}
+
+	versions, err := s.ListVersions("test-bucket", "")
+	if err != nil {
+		t.Fatalf("can not list versions: %s", err)
+	}
+
+	wantVersions := []store.Version{
+		{ObjectID: "test-object", IsLatest: true, DeleteMarker: true},
+		{ObjectID: "test-object", VersionID: versionIDs[2], Size: 9},
+		{ObjectID: "test-object", VersionID: versionIDs[1], Size: 9},
+		{ObjectID: "test-object", VersionID: versionIDs[0], Size: 9},
+	}
+	if len(versions) > 0 {
+		// The ID of the delete marker is not returned by Delete.
+		wantVersions[0].VersionID = versions[0].VersionID
+	}
+
+	ignore := cmpopts.IgnoreFields(store.Version{}, "Modified", "Digest")
+	if diff := cmp.Diff(versions, wantVersions, ignore); diff != "" {
+		t.Errorf("versions differ: -got+want\n%s", diff)
+	}
+
+	// Removing the delete marker restores the latest version.
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{VersionID: versions[0].VersionID}); err != nil {
+		t.Fatalf("can not delete marker: %s", err)
+	}
+
+	if got := readVersion(t, s, "test-object", ""); got != "content-a" {
+		t.Errorf("got content %q after removing marker, want %q", got, "content-a")
+	}
+
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{VersionID: versionIDs[1]}); err != nil {
+		t.Fatalf("can not delete version: %s", err)
+	}
+
+	if got := s.Stats().Buckets["test-bucket"].NumContents; got != 1 {
+		t.Errorf("got %d contents after deleting version, want 1", got)
+	}
+
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{VersionID: versionIDs[1]}); err != store.ErrNotFound {
+		t.Errorf("got error %q deleting version again, want %q", err, store.ErrNotFound)
+	}
+
+	versions, err = s.ListVersions("test-bucket", "")
+	if err != nil {
+		t.Fatalf("can not list versions: %s", err)
+	}
+
+	wantVersions = []store.Version{
+		{ObjectID: "test-object", VersionID: versionIDs[2], IsLatest: true, Size: 9},
+		{ObjectID: "test-object", VersionID: versionIDs[0], Size: 9},
+	}
+	if diff := cmp.Diff(versions, wantVersions, ignore); diff != "" {
+		t.Errorf("versions differ: -got+want\n%s", diff)
+	}
+}
+
+func TestVersioningDisabled(t *testing.T) {
+	s := NewStore(log)
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "test-object", "content-a"},
+		{opPut, "test-bucket", "test-object", "content-b"},
+	})
+
+	meta, err := s.Put("test-bucket", "test-object", strings.NewReader("content-c"), store.PutOptions{})
+	if err != nil {
+		t.Fatalf("can not put object: %s", err)
+	}
+
+	if meta.VersionID != "" {
+		t.Errorf("got version ID %q, want none", meta.VersionID)
+	}
+
+	if got := s.Stats().Buckets["test-bucket"].NumContents; got != 1 {
+		t.Errorf("got %d contents, want 1", got)
+	}
+
+	if _, err := s.Head("test-bucket", "test-object", store.GetOptions{VersionID: "unknown"}); err != store.ErrNotFound {
+		t.Errorf("got error %q, want %q", err, store.ErrNotFound)
+	}
+
+	if err := s.SetVersioning("other-bucket", true); err != store.ErrNotFound {
+		t.Errorf("got error %q enabling versioning of missing bucket, want %q", err, store.ErrNotFound)
+	}
+}
+
+func TestNullVersion(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	// The object saved before versioning was enabled becomes the version without ID.
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "test-object", "content-a"},
+		{opVersioning, "test-bucket", "", ""},
+		{opPut, "test-bucket", "test-object", "content-b"},
+	})
+
+	versions, err := s.ListVersions("test-bucket", "")
+	if err != nil {
+		t.Fatalf("can not list versions: %s", err)
+	}
+
+	if len(versions) != 2 || versions[1].VersionID != store.NullVersionID {
+		t.Fatalf("got versions %v, want previous version %q", versions, store.NullVersionID)
+	}
+
+	if got := readVersion(t, s, "test-object", store.NullVersionID); got != "content-a" {
+		t.Errorf("got content %q, want %q", got, "content-a")
+	}
+
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{VersionID: store.NullVersionID}); err != nil {
+		t.Fatalf("can not delete version: %s", err)
+	}
+
+	if got := s.Stats().Buckets["test-bucket"].NumContents; got != 1 {
+		t.Errorf("got %d contents after deleting version, want 1", got)
+	}
+
+	if err := s.Delete("test-bucket", "test-object", store.DeleteOptions{VersionID: store.NullVersionID}); err != store.ErrNotFound {
+		t.Errorf("got error %q deleting version again, want %q", err, store.ErrNotFound)
+	}
+
+	// Objects saved while versioning is suspended replace the previous version without ID.
+	for _, content := range []string{"content-c", "content-d", "content-e", "content-f"} {
+		if err := s.SetVersioning("test-bucket", content == "content-d" || content == "content-f"); err != nil {
+			t.Fatalf("can not set versioning: %s", err)
+		}
+
+		if _, err := s.Put("test-bucket", "test-object", strings.NewReader(content), store.PutOptions{}); err != nil {
+			t.Fatalf("can not put object: %s", err)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("can not close store: %s", err)
+	}
+
+	restored, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+	defer restored.Close()
+
+	for _, s := range []*Store{s, restored} {
+		versions, err := s.ListVersions("test-bucket", "")
+		if err != nil {
+			t.Fatalf("can not list versions: %s", err)
+		}
+
+		// The versions with ID of content-b and content-d are kept, while content-c is replaced by content-e.
+		if len(versions) != 4 || versions[1].VersionID != store.NullVersionID {
+			t.Fatalf("got versions %v, want previous version %q", versions, store.NullVersionID)
+		}
+
+		if got := readVersion(t, s, "test-object", store.NullVersionID); got != "content-e" {
+			t.Errorf("got content %q, want %q", got, "content-e")
+		}
+
+		if got := s.Stats().Buckets["test-bucket"].NumContents; got != 4 {
+			t.Errorf("got %d contents, want 4", got)
+		}
+	}
+}
+
+func TestVersioningSuspended(t *testing.T) {
+	s := NewStore(log)
+	applyOps(t, s, []walOp{
+		{opCreateBucket, "test-bucket", "", ""},
+		{opVersioning, "test-bucket", "", ""},
+		{opPut, "test-bucket", "test-object", "content-a"},
+	})
+
+	if err := s.SetVersioning("test-bucket", false); err != nil {
+		t.Fatalf("can not suspend versioning: %s", err)
+	}
+
+	listVersions := func() []store.Version {
+		versions, err := s.ListVersions("test-bucket", "")
+		if err != nil {
+			t.Fatalf("can not list versions: %s", err)
+		}
+
+		return versions
+	}
+
+	steps := []struct {
+		desc             string
+		op               string
+		content          string
+		wantIDs          []bool
+		wantDeleteMarker []bool
+	}{
+		{
+			desc:             "delete version with ID",
+			op:               opDelete,
+			wantIDs:          []bool{false, true},
+			wantDeleteMarker: []bool{true, false},
+		},
+		{
+			desc:             "overwrite",
+			op:               opPut,
+			content:          "content-b",
+			wantIDs:          []bool{false, true},
+			wantDeleteMarker: []bool{false, false},
+		},
+		{
+			desc:             "overwrite again",
+			op:               opPut,
+			content:          "content-c",
+			wantIDs:          []bool{false, true},
+			wantDeleteMarker: []bool{false, false},
+		},
+		{
+			desc:             "delete version without ID",
+			op:               opDelete,
+			wantIDs:          []bool{true},
+			wantDeleteMarker: []bool{false},
+		},
+	}
+
+	for _, step := range steps {
+		applyOps(t, s, []walOp{
+			{step.op, "test-bucket", "test-object", step.content},
+		})
+
+		versions := listVersions()
+		if len(versions) != len(step.wantIDs) {
+			t.Fatalf("%s: got versions %v, want %d", step.desc, versions, len(step.wantIDs))
+		}
+
+		for i, v := range versions {
+			if hasID := v.VersionID != store.NullVersionID; hasID != step.wantIDs[i] {
+				t.Errorf("%s: got version ID %q at %d", step.desc, v.VersionID, i)
+			}
+
+			if v.DeleteMarker != step.wantDeleteMarker[i] {
+				t.Errorf("%s: got delete marker %v at %d, want %v", step.desc, v.DeleteMarker, i, step.wantDeleteMarker[i])
+			}
+		}
+	}
+
+	versions := listVersions()
+	if got := readVersion(t, s, "test-object", versions[0].VersionID); got != "content-a" {
+		t.Errorf("got content %q for kept version, want %q", got, "content-a")
+	}
+}
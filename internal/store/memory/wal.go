@@ -0,0 +1,505 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/fileutil"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+const (
+	walFile      = "wal.log"
+	snapshotFile = "snapshot.json"
+
+	opPut          = "put"
+	opDelete       = "delete"
+	opCreateBucket = "create-bucket"
+	opDeleteBucket = "delete-bucket"
+	opVersioning   = "versioning"
+	opLifecycle    = "lifecycle"
+	opEvict        = "evict"
+	opLink         = "link"
+	opCopy         = "copy"
+	opBatch        = "batch"
+)
+
+// walRecord is a single modification of the store as written to the write-ahead log.
+type walRecord struct {
+	// Sequence numbers the records, so that records already contained in the snapshot are skipped when the log is
+	// replayed. Logs written by older versions do not contain it.
+	Sequence uint64 `json:"seq,omitempty"`
+	Op       string `json:"op"`
+	Bucket   string `json:"bucket"`
+	ObjectID string `json:"object"`
+	Content  []byte `json:"content,omitempty"`
+	// Metadata is the metadata of a put object or of the delete marker of a deleted object.
+	// Logs written by older versions do not contain it.
+	Metadata *store.Metadata `json:"metadata,omitempty"`
+	// VersionID is set when a single version of an object is deleted.
+	VersionID string `json:"versionId,omitempty"`
+	// Enabled is the new state of versioning of the bucket.
+	Enabled bool `json:"enabled,omitempty"`
+	// Rules are the new lifecycle rules of the bucket.
+	Rules []store.LifecycleRule `json:"rules,omitempty"`
+	// Digest is the digest algorithm of a created bucket.
+	Digest string `json:"digest,omitempty"`
+	// Source is the ID of the object which is copied within the bucket. VersionID selects a previous version of it.
+	Source string `json:"source,omitempty"`
+	// Records are the puts and deletes of an atomic batch, which are logged together.
+	Records []walRecord `json:"records,omitempty"`
+}
+
+type snapshot struct {
+	// Sequence is the sequence number of the last record contained in the snapshot.
+	Sequence uint64                    `json:"sequence,omitempty"`
+	Buckets  map[string]snapshotBucket `json:"buckets"`
+}
+
+type snapshotBucket struct {
+	Objects    map[string][]digest.Digest   `json:"objects"`
+	Contents   map[digest.Digest][]byte     `json:"contents"`
+	Metadata   map[string]store.Metadata    `json:"metadata,omitempty"`
+	Versioning bool                         `json:"versioning,omitempty"`
+	Versions   map[string][]snapshotVersion `json:"versions,omitempty"`
+	Lifecycle  []store.LifecycleRule        `json:"lifecycle,omitempty"`
+	Digest     string                       `json:"digest,omitempty"`
+}
+
+type snapshotVersion struct {
+	Chunks       []digest.Digest `json:"chunks,omitempty"`
+	Metadata     store.Metadata  `json:"metadata"`
+	DeleteMarker bool            `json:"deleteMarker,omitempty"`
+}
+
+// logFile is the file the write-ahead log is appended to.
+type logFile interface {
+	io.WriteCloser
+	Sync() error
+	Truncate(size int64) error
+}
+
+type wal struct {
+	dir           string
+	file          logFile
+	records       int
+	snapshotEvery int
+	// sequence is the sequence number of the last written record.
+	sequence uint64
+}
+
+// NewPersistentStore creates a Store which records every modification in a write-ahead log in dir.
+// After snapshotEvery records a snapshot of all buckets is written and the log is truncated.
+// Existing data in dir is restored before the store is returned.
+func NewPersistentStore(log logrus.FieldLogger, dir string, snapshotEvery int, opts ...Option) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can not create directory: %w", err)
+	}
+
+	s := NewStore(log, opts...)
+	sequence, err := s.loadSnapshot(filepath.Join(dir, snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+
+	records, sequence, err := s.replayLog(filepath.Join(dir, walFile), sequence)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("can not open log: %w", err)
+	}
+
+	s.wal = &wal{
+		dir:           dir,
+		file:          file,
+		records:       records,
+		snapshotEvery: snapshotEvery,
+		sequence:      sequence,
+	}
+	s.log.Debugf("Restored %d buckets from %s (%d log records)", len(s.buckets), dir, records)
+	return s, nil
+}
+
+// Close closes the write-ahead log of the store. It is a no-op for stores without persistence.
+func (s *Store) Close() error {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+
+	err := s.wal.file.Close()
+	s.wal = nil
+	return err
+}
+
+// Snapshot writes a snapshot of all buckets and truncates the write-ahead log. If the store stops before the log
+// is truncated, the records contained in the snapshot are skipped by their sequence number when restoring.
+func (s *Store) Snapshot() error {
+	s.bucketMutex.Lock()
+	defer s.bucketMutex.Unlock()
+
+	return s.snapshot()
+}
+
+func (s *Store) snapshot() error {
+	if s.wal == nil {
+		return errors.New("store has no persistence")
+	}
+
+	snap := snapshot{
+		Sequence: s.wal.sequence,
+		Buckets:  make(map[string]snapshotBucket, len(s.buckets)),
+	}
+	for name, b := range s.buckets {
+		contents := make(map[digest.Digest][]byte, len(b.contents))
+		for d, c := range b.contents {
+			contents[d] = []byte(c)
+		}
+
+		var versions map[string][]snapshotVersion
+		if len(b.versions) > 0 {
+			versions = make(map[string][]snapshotVersion, len(b.versions))
+			for objectID, history := range b.versions {
+				for _, v := range history {
+					versions[objectID] = append(versions[objectID], snapshotVersion{
+						Chunks:       v.chunks,
+						Metadata:     v.meta,
+						DeleteMarker: v.deleteMarker,
+					})
+				}
+			}
+		}
+
+		snap.Buckets[name] = snapshotBucket{
+			Objects:    b.objects,
+			Contents:   contents,
+			Metadata:   b.metadata,
+			Versioning: b.versioning,
+			Versions:   versions,
+			Lifecycle:  b.lifecycle,
+			Digest:     algorithmName(b),
+		}
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("can not encode snapshot: %w", err)
+	}
+
+	if err := fileutil.WriteFile(filepath.Join(s.wal.dir, snapshotFile), data); err != nil {
+		return fmt.Errorf("can not write snapshot: %w", err)
+	}
+
+	if err := s.wal.file.Truncate(0); err != nil {
+		return fmt.Errorf("can not truncate log: %w", err)
+	}
+	s.wal.records = 0
+
+	return nil
+}
+
+// logRecord appends the record to the write-ahead log. It needs to be called with the write lock held
+// before the modification is applied.
+func (s *Store) logRecord(rec walRecord) error {
+	if s.wal == nil {
+		return nil
+	}
+
+	rec.Sequence = s.wal.sequence + 1
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("can not encode log record: %w", err)
+	}
+
+	if _, err := s.wal.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("can not write log: %w", err)
+	}
+
+	if err := s.wal.file.Sync(); err != nil {
+		return fmt.Errorf("can not sync log: %w", err)
+	}
+
+	s.wal.records++
+	s.wal.sequence = rec.Sequence
+	return nil
+}
+
+// compact creates a snapshot once enough records have been written to the log.
+// It needs to be called with the write lock held after the modification has been applied.
+func (s *Store) compact() {
+	if s.wal == nil || s.wal.snapshotEvery <= 0 || s.wal.records < s.wal.snapshotEvery {
+		return
+	}
+
+	// The records are already durable, so a failed snapshot only delays the compaction.
+	if err := s.snapshot(); err != nil {
+		s.log.Errorf("Error creating snapshot: %s", err)
+	}
+}
+
+// loadSnapshot restores the buckets from the snapshot and returns the sequence number of its last record.
+func (s *Store) loadSnapshot(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("can not read snapshot: %w", err)
+	default:
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return 0, fmt.Errorf("can not parse snapshot: %w", err)
+	}
+
+	for name, sb := range snap.Buckets {
+		b := newBucket()
+		algorithm, err := bucketAlgorithm(sb.Digest)
+		if err != nil {
+			return 0, fmt.Errorf("can not restore bucket %s: %w", name, err)
+		}
+		b.algorithm = algorithm
+
+		for d, c := range sb.Contents {
+			s.storeContent(b, d, string(c))
+		}
+
+		for objectID, chunks := range sb.Objects {
+			for _, d := range chunks {
+				b.refs[d]++
+			}
+
+			meta, ok := sb.Metadata[objectID]
+			if !ok {
+				var err error
+				meta, err = s.restoreMetadata(b, chunks)
+				if err != nil {
+					return 0, fmt.Errorf("can not restore metadata of %s/%s: %w", name, objectID, err)
+				}
+			}
+			b.setObject(objectID, chunks, meta)
+			s.track(b, objectID)
+		}
+
+		b.versioning = sb.Versioning
+		b.lifecycle = sb.Lifecycle
+		for objectID, history := range sb.Versions {
+			for _, v := range history {
+				for _, d := range v.Chunks {
+					b.refs[d]++
+				}
+				b.addVersion(objectID, version{
+					chunks:       v.Chunks,
+					meta:         v.Metadata,
+					deleteMarker: v.DeleteMarker,
+				})
+			}
+		}
+		s.buckets[name] = b
+	}
+
+	return snap.Sequence, nil
+}
+
+// restoreMetadata recreates the metadata of an object from its contents for snapshots written by older versions.
+func (s *Store) restoreMetadata(b *bucket, chunks []digest.Digest) (store.Metadata, error) {
+	var data []byte
+	for _, d := range chunks {
+		data = append(data, b.contents[d]...)
+	}
+
+	parts := make([]chunk, 0, len(chunks))
+	for _, d := range chunks {
+		parts = append(parts, chunk{digest: d})
+	}
+
+	digester, _ := s.bucketDigester(b)
+	contentDigest, err := s.objectDigest(digester, data, parts)
+	if err != nil {
+		return store.Metadata{}, err
+	}
+
+	return store.NewMetadata(store.PutOptions{}, int64(len(data)), contentDigest, s.now(), nil), nil
+}
+
+// replayLog applies the records of the log which are not contained in the snapshot with the sequence number.
+// It returns the number of applied records and the sequence number of the last record.
+func (s *Store) replayLog(path string, sequence uint64) (int, uint64, error) {
+	file, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return 0, sequence, nil
+	case err != nil:
+		return 0, 0, fmt.Errorf("can not open log: %w", err)
+	default:
+	}
+	defer file.Close()
+
+	records := 0
+	offset := int64(0)
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				s.log.Warnf("Discarding incomplete record at end of log: %q", line)
+				if err := os.Truncate(path, offset); err != nil {
+					return 0, 0, fmt.Errorf("can not truncate log: %w", err)
+				}
+			}
+			break
+		}
+		if err != nil {
+			return 0, 0, fmt.Errorf("can not read log: %w", err)
+		}
+		offset += int64(len(line))
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return 0, 0, fmt.Errorf("can not parse log record %d: %w", records+1, err)
+		}
+
+		// The log has not been truncated after the last snapshot, which already contains the record.
+		if rec.Sequence != 0 && rec.Sequence <= sequence {
+			continue
+		}
+
+		if err := s.applyRecord(rec); err != nil {
+			return 0, 0, fmt.Errorf("can not apply log record %d: %w", records+1, err)
+		}
+		records++
+		if rec.Sequence > sequence {
+			sequence = rec.Sequence
+		}
+	}
+
+	return records, sequence, nil
+}
+
+func (s *Store) applyRecord(rec walRecord) error {
+	switch rec.Op {
+	case opPut:
+		digester, verify := s.bucketDigester(s.buckets[rec.Bucket])
+		chunks, err := s.split(digester, rec.Content)
+		if err != nil {
+			return err
+		}
+
+		if rec.Metadata == nil {
+			contentDigest, err := s.objectDigest(digester, rec.Content, chunks)
+			if err != nil {
+				return err
+			}
+
+			meta := store.NewMetadata(store.PutOptions{}, int64(len(rec.Content)), contentDigest, s.now(), nil)
+			rec.Metadata = &meta
+		}
+
+		// The log is replayed in order, so only objects which passed the check when they were saved are logged.
+		if verify {
+			if err := s.checkCollisions(s.buckets[rec.Bucket], chunks); err != nil {
+				return err
+			}
+		}
+
+		s.putObject(rec.Bucket, rec.ObjectID, chunks, *rec.Metadata)
+	case opLink:
+		b, ok := s.buckets[rec.Bucket]
+		if !ok || rec.Metadata == nil {
+			return fmt.Errorf("can not link %s/%s: missing bucket or metadata", rec.Bucket, rec.ObjectID)
+		}
+
+		chunks, ok := s.referencedChunks(b, rec.Metadata.Digest)
+		if !ok {
+			return fmt.Errorf("can not link %s/%s: content %q not found", rec.Bucket, rec.ObjectID, rec.Metadata.Digest)
+		}
+
+		s.putObject(rec.Bucket, rec.ObjectID, chunks, *rec.Metadata)
+	case opCopy:
+		b, ok := s.buckets[rec.Bucket]
+		if !ok || rec.Metadata == nil {
+			return fmt.Errorf("can not copy %s/%s: missing bucket or metadata", rec.Bucket, rec.ObjectID)
+		}
+
+		keys, _, ok := b.object(rec.Source, rec.VersionID)
+		if !ok {
+			return fmt.Errorf("can not copy %s/%s: source %s not found", rec.Bucket, rec.ObjectID, rec.Source)
+		}
+
+		chunks, _, err := s.copyChunks(b, keys, rec.Metadata.Digest, b)
+		if err != nil {
+			return err
+		}
+
+		s.putObject(rec.Bucket, rec.ObjectID, chunks, *rec.Metadata)
+	case opDelete:
+		b, ok := s.buckets[rec.Bucket]
+		if !ok {
+			return nil
+		}
+
+		if rec.VersionID != "" {
+			if b.hasVersion(rec.ObjectID, rec.VersionID) {
+				s.removeVersion(b, rec.ObjectID, rec.VersionID)
+			}
+			return nil
+		}
+
+		if _, ok := b.objects[rec.ObjectID]; !ok {
+			return nil
+		}
+
+		s.removeObject(b, rec.ObjectID, rec.Metadata)
+	case opCreateBucket:
+		if _, ok := s.buckets[rec.Bucket]; !ok {
+			algorithm, err := bucketAlgorithm(rec.Digest)
+			if err != nil {
+				return err
+			}
+
+			b := newBucket()
+			b.algorithm = algorithm
+			s.buckets[rec.Bucket] = b
+		}
+	case opDeleteBucket:
+		if _, ok := s.buckets[rec.Bucket]; ok {
+			s.deleteBucket(rec.Bucket)
+		}
+	case opVersioning:
+		if b, ok := s.buckets[rec.Bucket]; ok {
+			b.versioning = rec.Enabled
+		}
+	case opEvict:
+		if b, ok := s.buckets[rec.Bucket]; ok {
+			s.evictObject(b, rec.ObjectID)
+		}
+	case opLifecycle:
+		if b, ok := s.buckets[rec.Bucket]; ok {
+			b.lifecycle = rec.Rules
+		}
+	case opBatch:
+		for _, r := range rec.Records {
+			if err := s.applyRecord(r); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown operation %q", rec.Op)
+	}
+
+	return nil
+}
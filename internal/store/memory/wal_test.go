@@ -0,0 +1,239 @@
+package memory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+type walOp struct {
+	op       string
+	bucket   string
+	objectID string
+	content  string
+}
+
+func applyOps(t *testing.T, s *Store, ops []walOp) {
+	for _, o := range ops {
+		var err error
+		switch o.op {
+		case opPut:
+			_, err = s.Put(o.bucket, o.objectID, strings.NewReader(o.content), store.PutOptions{})
+		case opLink:
+			digester, _ := s.bucketDigester(s.buckets[o.bucket])
+			var d digest.Digest
+			if d, err = digester(strings.NewReader(o.content)); err == nil {
+				_, err = s.PutReference(o.bucket, o.objectID, d, store.PutOptions{})
+			}
+		case opDelete:
+			err = s.Delete(o.bucket, o.objectID, store.DeleteOptions{})
+		case opCreateBucket:
+			err = s.CreateBucket(o.bucket, store.BucketOptions{})
+		case opDeleteBucket:
+			err = s.DeleteBucket(o.bucket, true)
+		case opVersioning:
+			err = s.SetVersioning(o.bucket, true)
+		default:
+			t.Fatalf("unknown operation %q", o.op)
+		}
+
+		if err != nil {
+			t.Fatalf("can not %s %s/%s: %s", o.op, o.bucket, o.objectID, err)
+		}
+	}
+}
+
+func TestPersistentStore(t *testing.T) {
+	ops := []walOp{
+		{opPut, "test-bucket", "test-object", "test-content"},
+		{opPut, "test-bucket", "test-object2", "test-content"},
+		{opPut, "test-bucket", "test-object3", "test-content2"},
+		{opPut, "other-bucket", "test-object", "\xff\x00binary"},
+		{opDelete, "test-bucket", "test-object3", ""},
+		{opPut, "test-bucket", "test-object4", "test-content3"},
+		{opCreateBucket, "empty-bucket", "", ""},
+		{opPut, "deleted-bucket", "test-object", "test-content"},
+		{opDeleteBucket, "deleted-bucket", "", ""},
+		{opCreateBucket, "versioned-bucket", "", ""},
+		{opVersioning, "versioned-bucket", "", ""},
+		{opPut, "versioned-bucket", "test-object", "test-content"},
+		{opPut, "versioned-bucket", "test-object", "test-content2"},
+		{opDelete, "versioned-bucket", "test-object", ""},
+		{opLink, "test-bucket", "linked-object", "test-content3"},
+	}
+
+	tt := []struct {
+		desc          string
+		snapshotEvery int
+		wantRecords   int
+	}{
+		{
+			desc:          "log only",
+			snapshotEvery: 0,
+			wantRecords:   15,
+		},
+		{
+			desc:          "with snapshot",
+			snapshotEvery: 4,
+			wantRecords:   3,
+		},
+		{
+			desc:          "snapshot after every record",
+			snapshotEvery: 1,
+			wantRecords:   0,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			before, err := NewPersistentStore(log, dir, tc.snapshotEvery)
+			if err != nil {
+				t.Fatalf("can not create store: %s", err)
+			}
+
+			applyOps(t, before, ops)
+			if before.wal.records != tc.wantRecords {
+				t.Errorf("got %d records in log, want %d", before.wal.records, tc.wantRecords)
+			}
+
+			if err := before.Close(); err != nil {
+				t.Fatalf("can not close store: %s", err)
+			}
+
+			after, err := NewPersistentStore(log, dir, tc.snapshotEvery)
+			if err != nil {
+				t.Fatalf("can not restore store: %s", err)
+			}
+			defer after.Close()
+
+			if diff := cmp.Diff(after.Stats(), before.Stats()); diff != "" {
+				t.Errorf("stats differ: -got+want\n%s", diff)
+			}
+
+			if diff := cmp.Diff(after.buckets, before.buckets, cmp.AllowUnexported(bucket{}, version{})); diff != "" {
+				t.Errorf("buckets differ: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPersistentStoreIncompleteRecord(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "test-object", "test-content"},
+	})
+	s.Close()
+
+	file, err := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("can not open log: %s", err)
+	}
+	file.WriteString(`{"op":"put","bucket":"test-bu`)
+	file.Close()
+
+	s, err = NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+
+	applyOps(t, s, []walOp{
+		{opPut, "test-bucket", "test-object2", "test-content2"},
+	})
+	s.Close()
+
+	s, err = NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+	defer s.Close()
+
+	wantStats := store.StoreStats{
+		Buckets: map[string]store.BucketStats{
+			"test-bucket": {
+				NumObjects:  2,
+				NumContents: 2,
+				NumChunks:   2,
+				References: map[digest.Digest]uint{
+					"0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e": 1,
+					"94ba6a98c5123b53c471da92c82a9a469bf8d6c598fc12ba044414ed12ca432f": 1,
+				},
+				LogicalBytes:  25,
+				PhysicalBytes: 25,
+				DedupRatio:    1,
+				LargestObject: &store.ObjectSize{ObjectID: "test-object2", Size: 13},
+			},
+		},
+		NumObjects:    2,
+		LogicalBytes:  25,
+		PhysicalBytes: 25,
+		DedupRatio:    1,
+		LargestObject: &store.ObjectSize{Bucket: "test-bucket", ObjectID: "test-object2", Size: 13},
+	}
+	if diff := cmp.Diff(s.Stats(), wantStats); diff != "" {
+		t.Errorf("stats differ: -got+want\n%s", diff)
+	}
+}
+
+func TestPersistentStoreSnapshotWithoutTruncate(t *testing.T) {
+	dir := t.TempDir()
+	before, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not create store: %s", err)
+	}
+
+	applyOps(t, before, []walOp{
+		{opCreateBucket, "versioned-bucket", "", ""},
+		{opVersioning, "versioned-bucket", "", ""},
+		{opPut, "versioned-bucket", "test-object", "test-content"},
+		{opPut, "versioned-bucket", "test-object", "test-content2"},
+	})
+
+	logPath := filepath.Join(dir, walFile)
+	records, err := ioutil.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("can not read log: %s", err)
+	}
+
+	if err := before.Snapshot(); err != nil {
+		t.Fatalf("can not create snapshot: %s", err)
+	}
+
+	// The log is restored as if the store stopped after writing the snapshot and before truncating the log.
+	if err := ioutil.WriteFile(logPath, records, 0o644); err != nil {
+		t.Fatalf("can not restore log: %s", err)
+	}
+
+	applyOps(t, before, []walOp{
+		{opPut, "versioned-bucket", "test-object", "test-content3"},
+	})
+	before.Close()
+
+	after, err := NewPersistentStore(log, dir, 0)
+	if err != nil {
+		t.Fatalf("can not restore store: %s", err)
+	}
+	defer after.Close()
+
+	if after.wal.records != 1 {
+		t.Errorf("got %d replayed records, want %d", after.wal.records, 1)
+	}
+
+	if diff := cmp.Diff(after.buckets, before.buckets, cmp.AllowUnexported(bucket{}, version{})); diff != "" {
+		t.Errorf("buckets differ: -got+want\n%s", diff)
+	}
+}
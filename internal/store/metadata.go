@@ -0,0 +1,93 @@
+package store
+
+import (
+	"time"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+// Metadata describes an object. It is saved together with the object and replaced when the object is overwritten,
+// except for the creation time.
+type Metadata struct {
+	ContentType string            `json:"contentType,omitempty"`
+	Size        int64             `json:"size"`
+	Created     time.Time         `json:"created"`
+	Modified    time.Time         `json:"modified"`
+	Digest      digest.Digest     `json:"digest"`
+	User        map[string]string `json:"user,omitempty"`
+	// VersionID is only set for objects in buckets with versioning.
+	VersionID string `json:"versionId,omitempty"`
+	// Expires is the time after which the object is removed. It is only set for objects saved with a TTL.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// PutOptions contains the metadata provided by the client when saving an object.
+type PutOptions struct {
+	ContentType string
+	// UserMetadata contains arbitrary key-value pairs which are returned unmodified with the object.
+	UserMetadata map[string]string
+	// Condition needs to match the object which is replaced.
+	Condition Condition
+	// TTL is the duration after which the object expires. Objects without TTL only expire by lifecycle rules.
+	TTL time.Duration
+}
+
+// DeleteOptions controls how an object is deleted.
+type DeleteOptions struct {
+	// Condition needs to match the object which is deleted.
+	Condition Condition
+	// VersionID permanently deletes a single version of the object instead of the current version.
+	VersionID string
+}
+
+// CopyOptions controls how an object is copied or moved.
+type CopyOptions struct {
+	// VersionID selects a previous version of the source object. Moving a version only removes that version.
+	VersionID string
+	// Condition needs to match the destination object which is replaced.
+	Condition Condition
+}
+
+// NewMetadata creates the metadata of an object saved at modified. If the object replaces a previous version,
+// the creation time of that version is kept.
+func NewMetadata(opts PutOptions, size int64, d digest.Digest, modified time.Time, previous *Metadata) Metadata {
+	created := modified
+	if previous != nil {
+		created = previous.Created
+	}
+
+	meta := Metadata{
+		ContentType: opts.ContentType,
+		Size:        size,
+		Created:     created,
+		Modified:    modified,
+		Digest:      d,
+		User:        opts.UserMetadata,
+	}
+	if opts.TTL > 0 {
+		expires := modified.Add(opts.TTL)
+		meta.Expires = &expires
+	}
+
+	return meta
+}
+
+// CopyMetadata creates the metadata of a copy of the source object saved at modified. The content type, user metadata
+// and expiration time are taken from the source. If the copy replaces a previous version, the creation time of that
+// version is kept.
+func CopyMetadata(source Metadata, modified time.Time, previous *Metadata) Metadata {
+	var user map[string]string
+	if source.User != nil {
+		user = make(map[string]string, len(source.User))
+		for key, value := range source.User {
+			user[key] = value
+		}
+	}
+
+	meta := NewMetadata(PutOptions{
+		ContentType:  source.ContentType,
+		UserMetadata: user,
+	}, source.Size, source.Digest, modified, previous)
+	meta.Expires = source.Expires
+	return meta
+}
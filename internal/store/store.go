@@ -1,25 +1,150 @@
 package store
 
-import "errors"
+import (
+	"errors"
+	"io"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
 
 var (
 	// ErrNotFound is returned when an operation is done on a not existing bucket or object.
 	ErrNotFound = errors.New("object not found")
+	// ErrBucketExists is returned when creating a bucket which already exists.
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrBucketNotEmpty is returned when deleting a bucket which still contains objects without forcing it.
+	ErrBucketNotEmpty = errors.New("bucket not empty")
+	// ErrInsufficientStorage is returned when an object does not fit into the storage quota.
+	ErrInsufficientStorage = errors.New("insufficient storage")
 )
 
+// StoreStats contains the statistics of the store. The byte counts are kept up to date by the backends while objects
+// are saved and removed, so collecting them does not need to scan all objects.
 type StoreStats struct {
 	Buckets map[string]BucketStats `json:"buckets"`
+	// SharedContents is the number of contents shared between buckets.
+	// Only reported by backends deduplicating across buckets.
+	SharedContents uint `json:"sharedContents,omitempty"`
+	// Expired is the number of objects removed from all buckets by expiration since the store was started.
+	Expired uint `json:"expired,omitempty"`
+	// Evicted is the number of objects removed from all buckets to stay within the quota.
+	// Only reported by backends with quotas.
+	Evicted uint `json:"evicted,omitempty"`
+	// NumObjects, LogicalBytes and PhysicalBytes are the totals of all buckets.
+	// Contents shared between buckets are only counted once in PhysicalBytes.
+	NumObjects    uint    `json:"objects"`
+	LogicalBytes  uint64  `json:"logicalBytes"`
+	PhysicalBytes uint64  `json:"physicalBytes"`
+	DedupRatio    float64 `json:"dedupRatio"`
+	// LargestObject is the largest object of all buckets.
+	LargestObject *ObjectSize `json:"largestObject,omitempty"`
+}
+
+// ObjectSize identifies an object together with its size.
+type ObjectSize struct {
+	// Bucket is only set in the statistics of the whole store.
+	Bucket   string `json:"bucket,omitempty"`
+	ObjectID string `json:"id"`
+	Size     int64  `json:"size"`
 }
 
 type BucketStats struct {
 	NumObjects  uint `json:"objects"`
 	NumContents uint `json:"contents"`
+	// NumChunks is the number of chunks referenced by all objects of the bucket.
+	// Only reported by backends splitting objects into chunks.
+	NumChunks uint `json:"chunks,omitempty"`
+	// BytesSaved is the number of bytes not stored thanks to deduplication.
+	BytesSaved uint64 `json:"bytesSaved,omitempty"`
+	// Expired is the number of objects removed from the bucket by expiration since the store was started.
+	Expired uint `json:"expired,omitempty"`
+	// Evicted is the number of objects removed from the bucket to stay within the quota since the store was started.
+	Evicted uint `json:"evicted,omitempty"`
+	// LogicalBytes is the sum of the sizes of all objects.
+	LogicalBytes uint64 `json:"logicalBytes"`
+	// PhysicalBytes is the sum of the sizes of the stored contents, which are shared by identical objects.
+	PhysicalBytes uint64 `json:"physicalBytes"`
+	// DedupRatio is LogicalBytes divided by PhysicalBytes.
+	DedupRatio    float64     `json:"dedupRatio"`
+	LargestObject *ObjectSize `json:"largestObject,omitempty"`
+	// References contains the number of references to each content of the bucket.
+	// Only reported by backends keeping reference counts.
+	References map[digest.Digest]uint `json:"references,omitempty"`
+}
+
+// BucketOptions configures a bucket when it is created.
+type BucketOptions struct {
+	// Digest is the name of the digest algorithm used for the contents of the bucket.
+	// The algorithm configured for the store is used if it is empty.
+	Digest string
 }
 
-// Store provides the interface to the storage backend
+// Store provides the interface to the storage backend.
+// Contents are streamed, so that objects do not need to fit into memory at once when the backend supports it.
 type Store interface {
-	Get(bucket, objectID string) (content string, err error)
-	Put(bucket, objectID, content string) (id string, err error)
-	Delete(bucket, objectID string) error
+	// Get returns a reader for the content of the object and its metadata. The caller needs to close the reader.
+	// The reader supports seeking, so that parts of an object can be read without reading the whole content.
+	Get(bucket, objectID string, opts GetOptions) (content io.ReadSeekCloser, meta Metadata, err error)
+	// Head returns the metadata of the object without its content.
+	Head(bucket, objectID string, opts GetOptions) (Metadata, error)
+	// GetContent returns a reader for the content with the digest if it belongs to any object or version in the
+	// bucket. The caller needs to close the reader.
+	GetContent(bucket string, d digest.Digest) (content io.ReadSeekCloser, size int64, err error)
+	// Put reads the content until EOF and saves it as the object. It returns the metadata of the saved object,
+	// which contains the version ID in buckets with versioning.
+	Put(bucket, objectID string, content io.Reader, opts PutOptions) (Metadata, error)
+	// PutReference saves the object with a content which is already part of the bucket, so that it does not need to
+	// be uploaded again. Returns ErrNotFound if the bucket does not exist or no object or version has the content.
+	PutReference(bucket, objectID string, d digest.Digest, opts PutOptions) (Metadata, error)
+	// Copy saves the source object under the destination ID, which can be in another bucket. The copy shares the
+	// content of the source, so it is not uploaded again. Returns ErrNotFound if the source object does not exist.
+	Copy(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts CopyOptions) (Metadata, error)
+	// Move copies the object like Copy and deletes the source object like Delete afterwards.
+	Move(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts CopyOptions) (Metadata, error)
+	Delete(bucket, objectID string, opts DeleteOptions) error
+	// Batch applies the operations in order on objects of the bucket while holding the lock of the store once and
+	// returns a result for each operation. Failed operations do not stop the batch, unless atomic is set: then all
+	// operations are checked first and none is applied if any of them fails. The other operations return
+	// ErrBatchAborted in that case. The returned error is only set if the batch itself is invalid.
+	Batch(bucket string, ops []BatchOperation, atomic bool) ([]BatchResult, error)
+	// List returns the IDs of the objects in the bucket.
+	List(bucket string, opts ListOptions) (ListResult, error)
 	Stats() StoreStats
+	// BucketStats returns the statistics of a single bucket.
+	BucketStats(bucket string) (BucketStats, error)
+	// CreateBucket creates an empty bucket. Buckets are also created implicitly by Put using the default options.
+	// An unknown digest algorithm returns an error wrapping digest.ErrUnknownAlgorithm.
+	CreateBucket(bucket string, opts BucketOptions) error
+	// ListBuckets returns the sorted names of all buckets.
+	ListBuckets() ([]string, error)
+	// DeleteBucket deletes the bucket. Buckets containing objects are only deleted when force is set.
+	DeleteBucket(bucket string, force bool) error
+	// BucketExists returns true if the bucket exists.
+	BucketExists(bucket string) bool
+	// SetVersioning enables or disables keeping previous versions of the objects in the bucket.
+	// Existing versions are kept when versioning is disabled.
+	SetVersioning(bucket string, enabled bool) error
+	// Versioning returns true if versioning is enabled for the bucket.
+	Versioning(bucket string) (bool, error)
+	// SetLifecycle replaces the lifecycle rules of the bucket. Objects matching any rule expire.
+	SetLifecycle(bucket string, rules []LifecycleRule) error
+	// Lifecycle returns the lifecycle rules of the bucket.
+	Lifecycle(bucket string) ([]LifecycleRule, error)
+	// ExpireObjects removes all objects which have expired by their TTL or the lifecycle rules of their bucket.
+	// In buckets with versioning a delete marker is added instead.
+	ExpireObjects() (expired uint, err error)
+	// ListVersions returns all versions of the objects starting with prefix, sorted by object ID and newest first.
+	ListVersions(bucket, prefix string) ([]Version, error)
+	// CreateUpload starts a multipart upload of the object. The options are applied when the upload is completed.
+	CreateUpload(bucket, objectID string, opts PutOptions) (uploadID string, err error)
+	// PutPart saves a numbered part of the upload. Uploading a part with the same number again replaces it.
+	PutPart(bucket, objectID, uploadID string, number int, content io.Reader) (Part, error)
+	// ListParts returns the uploaded parts sorted by their number.
+	ListParts(bucket, objectID, uploadID string) ([]Part, error)
+	// CompleteUpload assembles the object from the parts and saves it like Put. See SelectParts for the parts used.
+	CompleteUpload(bucket, objectID, uploadID string, parts []Part) (Metadata, error)
+	// AbortUpload discards the upload and all of its parts.
+	AbortUpload(bucket, objectID, uploadID string) error
+	// CollectGarbage removes contents which are not referenced by any object anymore.
+	CollectGarbage() (removed uint, err error)
 }
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+const (
+	// MaxPartNumber is the highest part number of a multipart upload.
+	MaxPartNumber = 10000
+	// UploadMaxAge is the time after which multipart uploads which have not been completed are discarded together
+	// with the expired objects.
+	UploadMaxAge = 24 * time.Hour
+)
+
+var (
+	// ErrUploadNotFound is returned when a multipart upload does not exist or belongs to a different object.
+	ErrUploadNotFound = errors.New("upload not found")
+	// ErrInvalidPart is returned for part numbers out of range and when completing an upload with parts
+	// which have not been uploaded.
+	ErrInvalidPart = errors.New("invalid part")
+)
+
+// Part describes an uploaded part of a multipart upload.
+type Part struct {
+	Number int           `json:"partNumber"`
+	Size   int64         `json:"size"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// NewUploadID creates a random ID for a multipart upload.
+func NewUploadID() (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", fmt.Errorf("can not create upload ID: %w", err)
+	}
+
+	return id, nil
+}
+
+func randomID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id[:]), nil
+}
+
+// ValidatePartNumber returns ErrInvalidPart if the number is not between 1 and MaxPartNumber.
+func ValidatePartNumber(number int) error {
+	if number < 1 || number > MaxPartNumber {
+		return ErrInvalidPart
+	}
+
+	return nil
+}
+
+// SortedParts returns the uploaded parts sorted by their number.
+func SortedParts(uploaded map[int]Part) []Part {
+	parts := make([]Part, 0, len(uploaded))
+	for _, p := range uploaded {
+		parts = append(parts, p)
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].Number < parts[j].Number
+	})
+
+	return parts
+}
+
+// SelectParts returns the parts used to assemble the object when completing an upload.
+// Without requested parts all uploaded parts are used. Otherwise the requested parts need to be in ascending order
+// and need to exist with the same digest, if one is specified.
+func SelectParts(uploaded map[int]Part, requested []Part) ([]Part, error) {
+	if len(requested) == 0 {
+		return SortedParts(uploaded), nil
+	}
+
+	parts := make([]Part, 0, len(requested))
+	for i, r := range requested {
+		if i > 0 && r.Number <= requested[i-1].Number {
+			return nil, ErrInvalidPart
+		}
+
+		p, ok := uploaded[r.Number]
+		if !ok || (r.Digest != "" && r.Digest != p.Digest) {
+			return nil, ErrInvalidPart
+		}
+
+		parts = append(parts, p)
+	}
+
+	return parts, nil
+}
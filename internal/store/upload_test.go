@@ -0,0 +1,80 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSelectParts(t *testing.T) {
+	uploaded := map[int]Part{
+		1: {Number: 1, Size: 5, Digest: "digest-1"},
+		3: {Number: 3, Size: 5, Digest: "digest-3"},
+		2: {Number: 2, Size: 5, Digest: "digest-2"},
+	}
+
+	tt := []struct {
+		desc      string
+		requested []Part
+		wantParts []Part
+		wantErr   error
+	}{
+		{
+			desc: "all parts",
+			wantParts: []Part{
+				{Number: 1, Size: 5, Digest: "digest-1"},
+				{Number: 2, Size: 5, Digest: "digest-2"},
+				{Number: 3, Size: 5, Digest: "digest-3"},
+			},
+		},
+		{
+			desc: "selected parts",
+			requested: []Part{
+				{Number: 1},
+				{Number: 3, Digest: "digest-3"},
+			},
+			wantParts: []Part{
+				{Number: 1, Size: 5, Digest: "digest-1"},
+				{Number: 3, Size: 5, Digest: "digest-3"},
+			},
+		},
+		{
+			desc: "missing part",
+			requested: []Part{
+				{Number: 4},
+			},
+			wantErr: ErrInvalidPart,
+		},
+		{
+			desc: "different digest",
+			requested: []Part{
+				{Number: 1, Digest: "digest-2"},
+			},
+			wantErr: ErrInvalidPart,
+		},
+		{
+			desc: "wrong order",
+			requested: []Part{
+				{Number: 2},
+				{Number: 1},
+			},
+			wantErr: ErrInvalidPart,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			parts, err := SelectParts(uploaded, tc.requested)
+			if err != tc.wantErr {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if diff := cmp.Diff(parts, tc.wantParts); diff != "" {
+				t.Errorf("parts differ: -got+want\n%s", diff)
+			}
+		})
+	}
+}
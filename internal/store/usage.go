@@ -0,0 +1,111 @@
+package store
+
+// Usage keeps track of the number and logical size of the objects of a bucket while they are added and removed,
+// so that the statistics do not need to be computed by scanning all objects.
+type Usage struct {
+	Objects      uint
+	LogicalBytes uint64
+	// LargestID is the ID of the largest object. It is empty if the bucket has no objects.
+	LargestID   string
+	LargestSize int64
+}
+
+// Update changes the usage after an object has been saved or removed. previous and current are nil if the object did
+// not exist before or does not exist anymore. objects needs to contain the metadata of all objects after the change.
+func (u *Usage) Update(objectID string, previous, current *Metadata, objects map[string]Metadata) {
+	if previous != nil {
+		u.Objects--
+		u.LogicalBytes -= uint64(previous.Size)
+	}
+
+	if current != nil {
+		u.Objects++
+		u.LogicalBytes += uint64(current.Size)
+	}
+
+	switch {
+	case current != nil && u.largerThanLargest(objectID, current.Size):
+		u.LargestID = objectID
+		u.LargestSize = current.Size
+	case objectID == u.LargestID:
+		// The largest object has been removed or got smaller, which can only be resolved by looking at all objects.
+		u.LargestID, u.LargestSize = "", 0
+		for id, meta := range objects {
+			if u.largerThanLargest(id, meta.Size) {
+				u.LargestID = id
+				u.LargestSize = meta.Size
+			}
+		}
+	}
+}
+
+// largerThanLargest returns true if the object should replace the largest object.
+// Objects of the same size are ordered by their ID, so that the result does not depend on the order of the changes.
+func (u *Usage) largerThanLargest(objectID string, size int64) bool {
+	switch {
+	case u.LargestID == "":
+		return true
+	case objectID == u.LargestID:
+		return size >= u.LargestSize
+	case size != u.LargestSize:
+		return size > u.LargestSize
+	default:
+		return objectID < u.LargestID
+	}
+}
+
+// BucketStats returns the statistics derived from the usage of a bucket storing physicalBytes of contents.
+func (u Usage) BucketStats(physicalBytes uint64) BucketStats {
+	stats := BucketStats{
+		NumObjects:    u.Objects,
+		LogicalBytes:  u.LogicalBytes,
+		PhysicalBytes: physicalBytes,
+		DedupRatio:    DedupRatio(u.LogicalBytes, physicalBytes),
+	}
+	if u.LogicalBytes > physicalBytes {
+		stats.BytesSaved = u.LogicalBytes - physicalBytes
+	}
+	if u.LargestID != "" {
+		stats.LargestObject = &ObjectSize{
+			ObjectID: u.LargestID,
+			Size:     u.LargestSize,
+		}
+	}
+
+	return stats
+}
+
+// AddBucket adds the statistics of a bucket to the store statistics and its totals. PhysicalBytes and DedupRatio are
+// left to the backend, because only the backend knows whether contents are shared between buckets.
+func (s *StoreStats) AddBucket(name string, b BucketStats) {
+	if s.Buckets == nil {
+		s.Buckets = make(map[string]BucketStats)
+	}
+	s.Buckets[name] = b
+
+	s.NumObjects += b.NumObjects
+	s.LogicalBytes += b.LogicalBytes
+	s.Expired += b.Expired
+	s.Evicted += b.Evicted
+
+	// Ties are broken by the bucket name to keep the result stable.
+	if largest := b.LargestObject; largest != nil {
+		if s.LargestObject == nil || largest.Size > s.LargestObject.Size ||
+			(largest.Size == s.LargestObject.Size && name < s.LargestObject.Bucket) {
+			s.LargestObject = &ObjectSize{
+				Bucket:   name,
+				ObjectID: largest.ObjectID,
+				Size:     largest.Size,
+			}
+		}
+	}
+}
+
+// DedupRatio returns the ratio of the logical size to the stored size. It is zero if nothing is stored.
+func DedupRatio(logicalBytes, physicalBytes uint64) float64 {
+	if physicalBytes == 0 {
+		return 0
+	}
+
+	return float64(logicalBytes) / float64(physicalBytes)
+}
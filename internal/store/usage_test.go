@@ -0,0 +1,100 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUsage(t *testing.T) {
+	type change struct {
+		objectID string
+		// size is negative if the object is removed.
+		size int64
+	}
+
+	tt := []struct {
+		desc      string
+		changes   []change
+		wantUsage Usage
+	}{
+		{
+			desc:      "empty",
+			changes:   []change{},
+			wantUsage: Usage{},
+		},
+		{
+			desc: "add objects",
+			changes: []change{
+				{"object-b", 10},
+				{"object-a", 5},
+				{"object-c", 20},
+			},
+			wantUsage: Usage{Objects: 3, LogicalBytes: 35, LargestID: "object-c", LargestSize: 20},
+		},
+		{
+			desc: "same size",
+			changes: []change{
+				{"object-b", 10},
+				{"object-a", 10},
+			},
+			wantUsage: Usage{Objects: 2, LogicalBytes: 20, LargestID: "object-a", LargestSize: 10},
+		},
+		{
+			desc: "overwrite largest with smaller object",
+			changes: []change{
+				{"object-a", 20},
+				{"object-b", 10},
+				{"object-a", 5},
+			},
+			wantUsage: Usage{Objects: 2, LogicalBytes: 15, LargestID: "object-b", LargestSize: 10},
+		},
+		{
+			desc: "remove largest",
+			changes: []change{
+				{"object-a", 20},
+				{"object-b", 10},
+				{"object-a", -1},
+			},
+			wantUsage: Usage{Objects: 1, LogicalBytes: 10, LargestID: "object-b", LargestSize: 10},
+		},
+		{
+			desc: "remove all",
+			changes: []change{
+				{"object-a", 20},
+				{"object-a", -1},
+			},
+			wantUsage: Usage{},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			var usage Usage
+			objects := map[string]Metadata{}
+			for _, c := range tc.changes {
+				var previous, current *Metadata
+				if meta, ok := objects[c.objectID]; ok {
+					previous = &meta
+				}
+
+				if c.size < 0 {
+					delete(objects, c.objectID)
+				} else {
+					meta := Metadata{Size: c.size}
+					objects[c.objectID] = meta
+					current = &meta
+				}
+
+				usage.Update(c.objectID, previous, current, objects)
+			}
+
+			if diff := cmp.Diff(usage, tc.wantUsage); diff != "" {
+				t.Errorf("usage differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
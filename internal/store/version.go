@@ -0,0 +1,46 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/xperimental/bukky/internal/digest"
+)
+
+var (
+	// ErrNotSupported is returned when the backend does not implement an optional feature.
+	ErrNotSupported = errors.New("not supported by backend")
+)
+
+// GetOptions selects the version of an object to read.
+type GetOptions struct {
+	// VersionID selects a previous version of the object. The current version is used if it is empty.
+	VersionID string
+}
+
+// Version describes one version of an object in a bucket with versioning.
+type Version struct {
+	ObjectID  string `json:"objectId"`
+	VersionID string `json:"versionId"`
+	// IsLatest is set for the current version or the delete marker of a deleted object.
+	IsLatest bool `json:"isLatest"`
+	// DeleteMarker is set for versions recording the deletion of the object.
+	DeleteMarker bool          `json:"deleteMarker,omitempty"`
+	Modified     time.Time     `json:"modified"`
+	Size         int64         `json:"size"`
+	Digest       digest.Digest `json:"digest,omitempty"`
+}
+
+// NullVersionID selects the version of an object which was saved while versioning was not enabled, like in S3.
+const NullVersionID = "null"
+
+// NewVersionID creates a random ID for a version of an object.
+func NewVersionID() (string, error) {
+	id, err := randomID()
+	if err != nil {
+		return "", fmt.Errorf("can not create version ID: %w", err)
+	}
+
+	return id, nil
+}
@@ -1,5 +1,11 @@
 package testutil
 
+import (
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
 // EqualErrorMessage returns true if the errors have the same error message.
 func EqualErrorMessage(got, want error) bool {
 	if got == want {
@@ -19,3 +25,21 @@ func EqualErrorMessage(got, want error) bool {
 
 	return gotMsg == wantMsg
 }
+
+// ReadAll reads the content from r and closes it if it is an io.Closer.
+func ReadAll(t *testing.T, r io.Reader) string {
+	t.Helper()
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("can not read content: %s", err)
+	}
+
+	if closer, ok := r.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			t.Errorf("can not close reader: %s", err)
+		}
+	}
+
+	return string(content)
+}
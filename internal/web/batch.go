@@ -0,0 +1,268 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+const (
+	// DefaultBatchMaxBytes is the default limit of the size of the body of batch requests.
+	DefaultBatchMaxBytes = 32 << 20
+
+	// batchOperationsPart is the name of the part containing the operations in multipart batch requests.
+	batchOperationsPart = "operations"
+)
+
+// batchRequest is the body of a batch request. Multipart requests contain it in the part named "operations" and the
+// contents of the puts in other parts.
+type batchRequest struct {
+	// Atomic only applies the operations if none of them fails.
+	Atomic     bool             `json:"atomic"`
+	Operations []batchOperation `json:"operations"`
+}
+
+type batchOperation struct {
+	Op store.BatchOp `json:"op"`
+	ID string        `json:"id"`
+	// Content is the base64-encoded content of a put.
+	Content []byte `json:"content,omitempty"`
+	// Part is the name of the part containing the content of a put in multipart requests.
+	Part        string            `json:"part,omitempty"`
+	ContentType string            `json:"contentType,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	// TTL is given in seconds.
+	TTL uint32 `json:"ttl,omitempty"`
+	// IfMatch and IfNoneMatch contain entity tags like the headers of the same name.
+	IfMatch     string `json:"ifMatch,omitempty"`
+	IfNoneMatch string `json:"ifNoneMatch,omitempty"`
+	VersionID   string `json:"versionId,omitempty"`
+}
+
+type batchResponse struct {
+	// Aborted is set if an atomic batch has not been applied.
+	Aborted bool          `json:"aborted,omitempty"`
+	Results []batchResult `json:"results"`
+}
+
+// batchResult contains the status code and error message of an operation like they would be returned by the
+// handler of the single operation.
+type batchResult struct {
+	Op          store.BatchOp `json:"op"`
+	ID          string        `json:"id"`
+	Status      int           `json:"status"`
+	Error       string        `json:"error,omitempty"`
+	Digest      digest.Digest `json:"digest,omitempty"`
+	VersionID   string        `json:"versionId,omitempty"`
+	ContentType string        `json:"contentType,omitempty"`
+	// Content is the base64-encoded content of a get.
+	Content []byte `json:"content,omitempty"`
+}
+
+func (r *Router) batchHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	bucket, _ := reqVars(req)
+	if r.explicitBuckets && !r.backend.BucketExists(bucket) {
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	}
+
+	body := &limitedBody{
+		ReadCloser: http.MaxBytesReader(w, req.Body, r.batchMaxBytes),
+		remaining:  r.batchMaxBytes,
+	}
+	req.Body = body
+
+	batch, parts, err := parseBatchRequest(req)
+	switch {
+	case err != nil && body.exceeded():
+		http.Error(w, fmt.Sprintf("body is larger than %d bytes", r.batchMaxBytes), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not parse body: %s", err), http.StatusBadRequest)
+		return
+	default:
+	}
+
+	ops, err := batchOperations(batch.Operations, parts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := store.ValidateBatch(ops, batch.Atomic); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	results, err := r.backend.Batch(bucket, ops, batch.Atomic)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can not apply batch: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := batchResponse{
+		Results: make([]batchResult, 0, len(results)),
+	}
+	for i, result := range results {
+		if batch.Atomic && result.Err != nil {
+			response.Aborted = true
+		}
+
+		response.Results = append(response.Results, newBatchResult(bucket, ops[i], result))
+	}
+
+	sendJSON(r.log, w, http.StatusOK, response)
+}
+
+// parseBatchRequest reads a JSON or multipart batch request. The contents of all parts of a multipart request are
+// returned by their name.
+func parseBatchRequest(req *http.Request) (batchRequest, map[string][]byte, error) {
+	var batch batchRequest
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(req.Body).Decode(&batch); err != nil {
+			return batchRequest{}, nil, err
+		}
+
+		return batch, nil, nil
+	}
+
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return batchRequest{}, nil, err
+	}
+
+	parts := make(map[string][]byte)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return batchRequest{}, nil, err
+		}
+
+		content, err := ioutil.ReadAll(part)
+		if err != nil {
+			return batchRequest{}, nil, err
+		}
+		parts[part.FormName()] = content
+	}
+
+	operations, ok := parts[batchOperationsPart]
+	if !ok {
+		return batchRequest{}, nil, fmt.Errorf("missing part %q", batchOperationsPart)
+	}
+
+	if err := json.Unmarshal(operations, &batch); err != nil {
+		return batchRequest{}, nil, err
+	}
+
+	return batch, parts, nil
+}
+
+// batchOperations converts the operations of the request into operations of the store. The contents of puts are
+// looked up in the parts of multipart requests.
+func batchOperations(operations []batchOperation, parts map[string][]byte) ([]store.BatchOperation, error) {
+	ops := make([]store.BatchOperation, 0, len(operations))
+	for i, o := range operations {
+		content := o.Content
+		if o.Part != "" {
+			if content != nil {
+				return nil, fmt.Errorf("content and part are both set at index %d", i)
+			}
+
+			var ok bool
+			content, ok = parts[o.Part]
+			if !ok || o.Part == batchOperationsPart {
+				return nil, fmt.Errorf("part not found: %q", o.Part)
+			}
+		}
+
+		op := store.BatchOperation{
+			Op:       o.Op,
+			ObjectID: o.ID,
+		}
+		switch o.Op {
+		case store.BatchPut:
+			op.Content = content
+			op.PutOptions = store.PutOptions{
+				ContentType:  o.ContentType,
+				UserMetadata: o.Metadata,
+				Condition:    batchCondition(o),
+				TTL:          time.Duration(o.TTL) * time.Second,
+			}
+		case store.BatchDelete:
+			op.DeleteOptions = store.DeleteOptions{
+				Condition: batchCondition(o),
+				VersionID: o.VersionID,
+			}
+		case store.BatchGet:
+			op.GetOptions = store.GetOptions{
+				VersionID: o.VersionID,
+			}
+		default:
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
+// batchCondition creates the condition of an operation like requestCondition.
+func batchCondition(o batchOperation) store.Condition {
+	return store.Condition{
+		IfMatch:     parseETags(o.IfMatch, false),
+		IfNoneMatch: parseETags(o.IfNoneMatch, true),
+	}
+}
+
+func newBatchResult(bucket string, op store.BatchOperation, result store.BatchResult) batchResult {
+	r := batchResult{
+		Op: op.Op,
+		ID: op.ObjectID,
+	}
+
+	switch {
+	case result.Err == store.ErrNotFound:
+		r.Status = http.StatusNotFound
+		r.Error = fmt.Sprintf("object not found: %s/%s", bucket, op.ObjectID)
+	case result.Err == store.ErrPreconditionFailed:
+		r.Status = http.StatusPreconditionFailed
+		r.Error = fmt.Sprintf("precondition failed: %s/%s", bucket, op.ObjectID)
+	case result.Err == store.ErrInsufficientStorage:
+		r.Status = http.StatusInsufficientStorage
+		r.Error = fmt.Sprintf("insufficient storage for %s/%s", bucket, op.ObjectID)
+	case result.Err == store.ErrBatchAborted:
+		r.Status = http.StatusFailedDependency
+		r.Error = "not applied, because another operation of the batch failed"
+	case result.Err != nil:
+		r.Status = http.StatusInternalServerError
+		r.Error = fmt.Sprintf("can not %s object: %s", op.Op, result.Err)
+	case op.Op == store.BatchPut:
+		r.Status = http.StatusCreated
+	case op.Op == store.BatchDelete:
+		r.Status = http.StatusNoContent
+	default:
+		r.Status = http.StatusOK
+		r.ContentType = result.Metadata.ContentType
+		r.Content = result.Content
+	}
+
+	if result.Err == nil {
+		r.Digest = result.Metadata.Digest
+		r.VersionID = result.Metadata.VersionID
+	}
+
+	return r
+}
@@ -0,0 +1,239 @@
+package web
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+const testBoundary = "test-boundary"
+
+// multipartBody creates a multipart form with a part for each pair of name and content.
+func multipartBody(t *testing.T, parts ...string) io.Reader {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	if err := w.SetBoundary(testBoundary); err != nil {
+		t.Fatalf("can not set boundary: %s", err)
+	}
+
+	for i := 0; i < len(parts); i += 2 {
+		if err := w.WriteField(parts[i], parts[i+1]); err != nil {
+			t.Fatalf("can not write part: %s", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("can not close multipart writer: %s", err)
+	}
+
+	return &buf
+}
+
+func TestBatch(t *testing.T) {
+	multipartHeader := http.Header{
+		"Content-Type": {"multipart/form-data; boundary=" + testBoundary},
+	}
+
+	tt := []struct {
+		desc       string
+		opts       []Option
+		store      store.Store
+		header     http.Header
+		body       io.Reader
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc: "success",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantBatchOps: []store.BatchOperation{
+					{Op: store.BatchPut, ObjectID: "put-object", Content: []byte("test-content"), PutOptions: store.PutOptions{
+						ContentType: "text/plain",
+						Condition:   store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+					}},
+					{Op: store.BatchGet, ObjectID: "get-object", GetOptions: store.GetOptions{VersionID: "test-version"}},
+					{Op: store.BatchDelete, ObjectID: "delete-object", DeleteOptions: store.DeleteOptions{
+						Condition: store.Condition{IfMatch: []digest.Digest{"test-digest"}},
+					}},
+				},
+				batchResults: []store.BatchResult{
+					{Metadata: store.Metadata{Digest: "test-digest"}},
+					{Metadata: store.Metadata{Digest: "other-digest", ContentType: "text/plain", VersionID: "test-version"}, Content: []byte("other-content")},
+					{Err: store.ErrNotFound},
+				},
+			},
+			body: strings.NewReader(`{"operations":[
+				{"op":"put","id":"put-object","content":"dGVzdC1jb250ZW50","contentType":"text/plain","ifNoneMatch":"*"},
+				{"op":"get","id":"get-object","versionId":"test-version"},
+				{"op":"delete","id":"delete-object","ifMatch":"\"test-digest\""}
+			]}`),
+			wantStatus: http.StatusOK,
+			wantBody: `{"results":[{"op":"put","id":"put-object","status":201,"digest":"test-digest"},` +
+				`{"op":"get","id":"get-object","status":200,"digest":"other-digest","versionId":"test-version","contentType":"text/plain","content":"b3RoZXItY29udGVudA=="},` +
+				`{"op":"delete","id":"delete-object","status":404,"error":"object not found: test-bucket/delete-object"}]}
+`,
+		},
+		{
+			desc: "aborted",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantBatchOps: []store.BatchOperation{
+					{Op: store.BatchDelete, ObjectID: "test-object"},
+					{Op: store.BatchPut, ObjectID: "test-object", Content: []byte("test-content"), PutOptions: store.PutOptions{
+						Condition: store.Condition{IfMatch: []digest.Digest{"test-digest"}},
+					}},
+				},
+				wantAtomic: true,
+				batchResults: []store.BatchResult{
+					{Err: store.ErrBatchAborted},
+					{Err: store.ErrPreconditionFailed},
+				},
+			},
+			body: strings.NewReader(`{"atomic":true,"operations":[
+				{"op":"delete","id":"test-object"},
+				{"op":"put","id":"test-object","content":"dGVzdC1jb250ZW50","ifMatch":"\"test-digest\""}
+			]}`),
+			wantStatus: http.StatusOK,
+			wantBody: `{"aborted":true,"results":[{"op":"delete","id":"test-object","status":424,"error":"not applied, because another operation of the batch failed"},` +
+				`{"op":"put","id":"test-object","status":412,"error":"precondition failed: test-bucket/test-object"}]}
+`,
+		},
+		{
+			desc: "multipart",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantBatchOps: []store.BatchOperation{
+					{Op: store.BatchPut, ObjectID: "test-object", Content: []byte("test-content")},
+					{Op: store.BatchPut, ObjectID: "other-object", Content: []byte("other-content")},
+				},
+				wantAtomic: true,
+				batchResults: []store.BatchResult{
+					{Metadata: store.Metadata{Digest: "test-digest"}},
+					{Metadata: store.Metadata{Digest: "other-digest"}},
+				},
+			},
+			header: multipartHeader,
+			body: multipartBody(t,
+				"test-content", "test-content",
+				"operations", `{"atomic":true,"operations":[{"op":"put","id":"test-object","part":"test-content"},{"op":"put","id":"other-object","part":"other-content"}]}`,
+				"other-content", "other-content",
+			),
+			wantStatus: http.StatusOK,
+			wantBody: `{"results":[{"op":"put","id":"test-object","status":201,"digest":"test-digest"},{"op":"put","id":"other-object","status":201,"digest":"other-digest"}]}
+`,
+		},
+		{
+			desc:   "multipart part not found",
+			store:  &fakeStore{t: t},
+			header: multipartHeader,
+			body: multipartBody(t,
+				"operations", `{"operations":[{"op":"put","id":"test-object","part":"test-content"}]}`,
+			),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "part not found: \"test-content\"\n",
+		},
+		{
+			desc:   "multipart without operations",
+			store:  &fakeStore{t: t},
+			header: multipartHeader,
+			body: multipartBody(t,
+				"test-content", "test-content",
+			),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "can not parse body: missing part \"operations\"\n",
+		},
+		{
+			desc:       "invalid body",
+			store:      &fakeStore{t: t},
+			body:       strings.NewReader("{"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "can not parse body: unexpected EOF\n",
+		},
+		{
+			desc:       "too large",
+			opts:       []Option{WithBatchMaxBytes(16)},
+			store:      &fakeStore{t: t},
+			body:       strings.NewReader(`{"operations":[{"op":"delete","id":"test-object"}]}`),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   "body is larger than 16 bytes\n",
+		},
+		{
+			desc:   "multipart too large",
+			opts:   []Option{WithBatchMaxBytes(64)},
+			store:  &fakeStore{t: t},
+			header: multipartHeader,
+			body: multipartBody(t,
+				"operations", `{"operations":[{"op":"put","id":"test-object","part":"test-content"}]}`,
+				"test-content", "test-content",
+			),
+			wantStatus: http.StatusRequestEntityTooLarge,
+			wantBody:   "body is larger than 64 bytes\n",
+		},
+		{
+			desc:       "unknown operation",
+			store:      &fakeStore{t: t},
+			body:       strings.NewReader(`{"operations":[{"op":"copy","id":"test-object"}]}`),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "unknown operation \"copy\" at index 0\n",
+		},
+		{
+			desc:       "atomic version",
+			store:      &fakeStore{t: t},
+			body:       strings.NewReader(`{"atomic":true,"operations":[{"op":"get","id":"test-object","versionId":"test-version"}]}`),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "can not select version in atomic batch at index 0\n",
+		},
+		{
+			desc: "error",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantBatchOps: []store.BatchOperation{
+					{Op: store.BatchDelete, ObjectID: "test-object"},
+				},
+				err: errors.New("test error"),
+			},
+			body:       strings.NewReader(`{"operations":[{"op":"delete","id":"test-object"}]}`),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "can not apply batch: test error\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store, tc.opts...)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/batch/test-bucket", tc.body)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
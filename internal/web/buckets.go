@@ -0,0 +1,87 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func (r *Router) listBucketsHandler(w http.ResponseWriter, req *http.Request) {
+	buckets, err := r.backend.ListBuckets()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can not list buckets: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Buckets []string `json:"buckets"`
+	}{
+		Buckets: buckets,
+	}
+	sendJSON(r.log, w, http.StatusOK, response)
+}
+
+func (r *Router) createBucketHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	err := r.backend.CreateBucket(bucket, store.BucketOptions{
+		Digest: req.URL.Query().Get("digest"),
+	})
+	switch {
+	case err == store.ErrBucketExists:
+		http.Error(w, fmt.Sprintf("bucket already exists: %s", bucket), http.StatusConflict)
+		return
+	case errors.Is(err, digest.ErrUnknownAlgorithm):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not create bucket: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (r *Router) headBucketHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	if !r.backend.BucketExists(bucket) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *Router) deleteBucketHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+
+	force := false
+	if value := req.URL.Query().Get("force"); value != "" {
+		var err error
+		force, err = strconv.ParseBool(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid force: %q", value), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := r.backend.DeleteBucket(bucket, force)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err == store.ErrBucketNotEmpty:
+		http.Error(w, fmt.Sprintf("bucket not empty: %s", bucket), http.StatusConflict)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not delete bucket: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
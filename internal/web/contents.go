@@ -0,0 +1,66 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func contentVars(req *http.Request) (string, digest.Digest) {
+	vars := mux.Vars(req)
+	return vars["bucket"], digest.Digest(vars["digest"])
+}
+
+// setContentHeaders sets the headers describing a content. Contents are immutable, so the digest is a strong ETag.
+// Content-Length is left to ServeContent like for objects.
+func setContentHeaders(h http.Header, d digest.Digest) {
+	h.Set("Content-Type", "application/octet-stream")
+	h.Set(headerDigest, string(d))
+	h.Set("ETag", etag(d))
+	h.Set("Accept-Ranges", "bytes")
+}
+
+func (r *Router) getContentHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, d := contentVars(req)
+	content, _, err := r.backend.GetContent(bucket, d)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("content not found: %s/%s", bucket, d), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not get content: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	defer content.Close()
+
+	// ServeContent handles conditional and range requests using the ETag.
+	setContentHeaders(w.Header(), d)
+	http.ServeContent(w, req, "", time.Time{}, content)
+}
+
+func (r *Router) headContentHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, d := contentVars(req)
+	content, size, err := r.backend.GetContent(bucket, d)
+	switch {
+	case err == store.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		r.log.Errorf("Error getting content %s/%s: %s", bucket, d, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	default:
+	}
+	content.Close()
+
+	setContentHeaders(w.Header(), d)
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,192 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+func TestContents(t *testing.T) {
+	contentHeader := http.Header{
+		"Content-Type":   {"application/octet-stream"},
+		"Content-Length": {"12"},
+		"X-Bukky-Digest": {"blake3:test-digest"},
+		"Etag":           {`"blake3:test-digest"`},
+		"Accept-Ranges":  {"bytes"},
+	}
+
+	tt := []struct {
+		desc       string
+		method     string
+		store      store.Store
+		header     http.Header
+		wantStatus int
+		wantHeader http.Header
+		wantBody   string
+	}{
+		{
+			desc:   "get",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				getContent: "test-content",
+			},
+			wantStatus: http.StatusOK,
+			wantHeader: contentHeader,
+			wantBody:   "test-content",
+		},
+		{
+			desc:   "get not modified",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				getContent: "test-content",
+			},
+			header: http.Header{
+				"If-None-Match": {`"blake3:test-digest"`},
+			},
+			wantStatus: http.StatusNotModified,
+			wantHeader: http.Header{
+				"X-Bukky-Digest": {"blake3:test-digest"},
+				"Etag":           {`"blake3:test-digest"`},
+				"Accept-Ranges":  {"bytes"},
+			},
+		},
+		{
+			desc:   "get precondition failed",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				getContent: "test-content",
+			},
+			header: http.Header{
+				"If-Match": {`"blake3:other-digest"`},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+			wantHeader: http.Header{
+				"Content-Type":   {"application/octet-stream"},
+				"X-Bukky-Digest": {"blake3:test-digest"},
+				"Etag":           {`"blake3:test-digest"`},
+				"Accept-Ranges":  {"bytes"},
+			},
+		},
+		{
+			desc:   "get range",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				getContent: "test-content",
+			},
+			header: http.Header{
+				"Range": {"bytes=5-"},
+			},
+			wantStatus: http.StatusPartialContent,
+			wantHeader: http.Header{
+				"Content-Type":   {"application/octet-stream"},
+				"Content-Length": {"7"},
+				"Content-Range":  {"bytes 5-11/12"},
+				"X-Bukky-Digest": {"blake3:test-digest"},
+				"Etag":           {`"blake3:test-digest"`},
+				"Accept-Ranges":  {"bytes"},
+			},
+			wantBody: "content",
+		},
+		{
+			desc:   "get not found",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				err:        store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantHeader: http.Header{
+				"Content-Type":           {"text/plain; charset=utf-8"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+			wantBody: "content not found: test-bucket/blake3:test-digest\n",
+		},
+		{
+			desc:   "get error",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				err:        errors.New("test-error"),
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantHeader: http.Header{
+				"Content-Type":           {"text/plain; charset=utf-8"},
+				"X-Content-Type-Options": {"nosniff"},
+			},
+			wantBody: "can not get content: test-error\n",
+		},
+		{
+			desc:   "head",
+			method: http.MethodHead,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				getContent: "test-content",
+			},
+			wantStatus: http.StatusOK,
+			wantHeader: contentHeader,
+		},
+		{
+			desc:   "head not found",
+			method: http.MethodHead,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantDigest: "blake3:test-digest",
+				err:        store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantHeader: http.Header{},
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "/contents/test-bucket/blake3:test-digest", nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			if diff := cmp.Diff(rec.Header(), tc.wantHeader); diff != "" {
+				t.Errorf("header differs: -got+want\n%s", diff)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xperimental/bukky/internal/store"
+)
+
+// copySource parses the source of a copy given as "bucket/objectID". The ID can be URL-encoded and followed by the
+// query parameter "versionId" to select a previous version.
+func copySource(value string) (bucket, objectID, versionID string, err error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return "", "", "", fmt.Errorf("invalid copy source: %q", value)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("invalid copy source: %q", value)
+	}
+
+	return parts[0], parts[1], u.Query().Get("versionId"), nil
+}
+
+// copyObject saves the object as a copy of the source object. The source object is deleted if move is set.
+// The request body needs to be empty.
+func (r *Router) copyObject(w http.ResponseWriter, req *http.Request, source, bucket, objectID string, move bool, condition store.Condition) {
+	if hasBody(req) {
+		http.Error(w, "body needs to be empty when copying an object", http.StatusBadRequest)
+		return
+	}
+
+	srcBucket, srcObjectID, versionID, err := copySource(source)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	copyObject := r.backend.Copy
+	if move {
+		copyObject = r.backend.Move
+	}
+
+	meta, err := copyObject(srcBucket, srcObjectID, bucket, objectID, store.CopyOptions{
+		VersionID: versionID,
+		Condition: condition,
+	})
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("object not found: %s/%s", srcBucket, srcObjectID), http.StatusNotFound)
+		return
+	case err == store.ErrPreconditionFailed:
+		http.Error(w, fmt.Sprintf("precondition failed: %s/%s", bucket, objectID), http.StatusPreconditionFailed)
+		return
+	case err == store.ErrInsufficientStorage:
+		http.Error(w, fmt.Sprintf("insufficient storage for %s/%s", bucket, objectID), http.StatusInsufficientStorage)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not copy object: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	w.Header().Set("ETag", etag(meta.Digest))
+	sendJSON(r.log, w, http.StatusCreated, newObjectResponse(objectID, meta))
+}
@@ -0,0 +1,60 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xperimental/bukky/internal/store"
+)
+
+type lifecycleConfig struct {
+	Rules []store.LifecycleRule `json:"rules"`
+}
+
+func (r *Router) getLifecycleHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	rules, err := r.backend.Lifecycle(bucket)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not get lifecycle: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	if rules == nil {
+		rules = []store.LifecycleRule{}
+	}
+
+	sendJSON(r.log, w, http.StatusOK, lifecycleConfig{Rules: rules})
+}
+
+func (r *Router) setLifecycleHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	bucket, _ := reqVars(req)
+	var config lifecycleConfig
+	if err := json.NewDecoder(req.Body).Decode(&config); err != nil {
+		http.Error(w, fmt.Sprintf("can not parse body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	err := r.backend.SetLifecycle(bucket, config.Rules)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err == store.ErrInvalidRule:
+		http.Error(w, "invalid lifecycle rule: days need to be at least 1", http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not set lifecycle: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,230 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+const metricsNamespace = "bukky"
+
+// metrics contains the metrics of the HTTP handlers. Every Router has its own registry, so that multiple routers
+// can be created in the same process.
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	bytesIn  *prometheus.CounterVec
+	bytesOut *prometheus.CounterVec
+}
+
+func newMetrics(backend store.Store) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "Number of handled requests by handler and status code.",
+		}, []string{"handler", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of requests by handler and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"handler", "code"}),
+		bytesIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "request_bytes_total",
+			Help:      "Number of bytes read from request bodies by handler.",
+		}, []string{"handler"}),
+		bytesOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: "http",
+			Name:      "response_bytes_total",
+			Help:      "Number of bytes written to response bodies by handler.",
+		}, []string{"handler"}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		m.bytesIn,
+		m.bytesOut,
+		newStoreCollector(backend),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return m
+}
+
+func (m *metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Middleware records the metrics of every request matched by the router. Requests are labeled with the path template
+// of the route instead of the path, so that the number of label values stays bounded.
+func (m *metrics) Middleware(next http.Handler) http.Handler {
+	return m.instrument("", next)
+}
+
+// S3Middleware records the metrics of the requests to the S3 API. The path templates are prefixed with "s3:" to tell
+// them apart from the routes of the default API.
+func (m *metrics) S3Middleware(next http.Handler) http.Handler {
+	return m.instrument("s3:", next)
+}
+
+func (m *metrics) instrument(prefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handler := "unknown"
+		if route := mux.CurrentRoute(req); route != nil {
+			if template, err := route.GetPathTemplate(); err == nil {
+				handler = template
+			}
+		}
+		handler = prefix + handler
+
+		body := &countingReader{ReadCloser: req.Body}
+		req.Body = body
+		rec, writer := newStatusRecorder(w)
+
+		start := time.Now()
+		next.ServeHTTP(writer, req)
+		duration := time.Since(start)
+
+		code := strconv.Itoa(rec.status)
+		m.requests.WithLabelValues(handler, code).Inc()
+		m.duration.WithLabelValues(handler, code).Observe(duration.Seconds())
+		m.bytesIn.WithLabelValues(handler).Add(float64(body.count))
+		m.bytesOut.WithLabelValues(handler).Add(float64(rec.count))
+	})
+}
+
+// countingReader counts the bytes read from the request body.
+type countingReader struct {
+	io.ReadCloser
+	count int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// statusRecorder remembers the status code and counts the bytes written to the response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	count       int64
+}
+
+// newStatusRecorder wraps the writer in a statusRecorder. The returned writer implements http.Flusher if w does, so
+// that handlers can still detect whether flushing is supported.
+func newStatusRecorder(w http.ResponseWriter) (*statusRecorder, http.ResponseWriter) {
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	if _, ok := w.(http.Flusher); ok {
+		return rec, flushingRecorder{rec}
+	}
+
+	return rec, rec
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(p []byte) (int, error) {
+	r.wroteHeader = true
+	n, err := r.ResponseWriter.Write(p)
+	r.count += int64(n)
+	return n, err
+}
+
+// ReadFrom uses the io.ReaderFrom of the wrapped writer if available, which allows net/http to send files without
+// copying them.
+func (r *statusRecorder) ReadFrom(src io.Reader) (int64, error) {
+	r.wroteHeader = true
+	if rf, ok := r.ResponseWriter.(io.ReaderFrom); ok {
+		n, err := rf.ReadFrom(src)
+		r.count += n
+		return n, err
+	}
+
+	// The writer is wrapped, so that io.Copy does not call ReadFrom again.
+	return io.Copy(struct{ io.Writer }{r}, src)
+}
+
+// flushingRecorder is a statusRecorder of a writer implementing http.Flusher.
+type flushingRecorder struct {
+	*statusRecorder
+}
+
+func (r flushingRecorder) Flush() {
+	r.wroteHeader = true
+	r.ResponseWriter.(http.Flusher).Flush()
+}
+
+var (
+	bucketLabels = []string{"bucket"}
+
+	objectsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "bucket", "objects"),
+		"Number of objects in the bucket.",
+		bucketLabels, nil)
+	contentsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "bucket", "contents"),
+		"Number of distinct contents in the bucket.",
+		bucketLabels, nil)
+	logicalBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "bucket", "logical_bytes"),
+		"Sum of the sizes of all objects in the bucket.",
+		bucketLabels, nil)
+	physicalBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "bucket", "physical_bytes"),
+		"Number of bytes stored for the contents of the bucket.",
+		bucketLabels, nil)
+)
+
+// storeCollector reports the statistics of the store as gauges. The statistics are collected on every scrape.
+type storeCollector struct {
+	backend store.Store
+}
+
+func newStoreCollector(backend store.Store) prometheus.Collector {
+	return &storeCollector{
+		backend: backend,
+	}
+}
+
+func (c *storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- objectsDesc
+	ch <- contentsDesc
+	ch <- logicalBytesDesc
+	ch <- physicalBytesDesc
+}
+
+func (c *storeCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.backend.Stats()
+	for name, b := range stats.Buckets {
+		ch <- prometheus.MustNewConstMetric(objectsDesc, prometheus.GaugeValue, float64(b.NumObjects), name)
+		ch <- prometheus.MustNewConstMetric(contentsDesc, prometheus.GaugeValue, float64(b.NumContents), name)
+		ch <- prometheus.MustNewConstMetric(logicalBytesDesc, prometheus.GaugeValue, float64(b.LogicalBytes), name)
+		ch <- prometheus.MustNewConstMetric(physicalBytesDesc, prometheus.GaugeValue, float64(b.PhysicalBytes), name)
+	}
+}
@@ -0,0 +1,169 @@
+package web
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/store/memory"
+)
+
+func TestMetrics(t *testing.T) {
+	backend := &fakeStore{
+		t:            t,
+		wantBucket:   "test-bucket",
+		wantObjectID: "test-object",
+		wantContent:  "test-content",
+		getContent:   "content",
+		putMeta:      store.Metadata{Digest: "test-digest"},
+		meta:         testMetadata,
+		stats: store.StoreStats{
+			Buckets: map[string]store.BucketStats{
+				"test-bucket": {
+					NumObjects:    2,
+					NumContents:   1,
+					LogicalBytes:  24,
+					PhysicalBytes: 12,
+				},
+			},
+		},
+	}
+	r := NewRouter(log, backend)
+
+	requests := []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{http.MethodPut, "/objects/test-bucket/test-object", "test-content"},
+		{http.MethodGet, "/objects/test-bucket/test-object", ""},
+		{http.MethodGet, "/objects/test-bucket/test-object", ""},
+	}
+	for _, req := range requests {
+		r.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, strings.NewReader(req.body)))
+	}
+
+	const handler = "/objects/{bucket}/{objectID}"
+	counters := []struct {
+		desc string
+		got  float64
+		want float64
+	}{
+		{"put requests", testutil.ToFloat64(r.metrics.requests.WithLabelValues(handler, "201")), 1},
+		{"get requests", testutil.ToFloat64(r.metrics.requests.WithLabelValues(handler, "200")), 2},
+		{"bytes in", testutil.ToFloat64(r.metrics.bytesIn.WithLabelValues(handler)), 12},
+		// The response to the put contains the ID and digest as JSON.
+		{"bytes out", testutil.ToFloat64(r.metrics.bytesOut.WithLabelValues(handler)), 58},
+	}
+	for _, c := range counters {
+		if c.got != c.want {
+			t.Errorf("got %s %v, want %v", c.desc, c.got, c.want)
+		}
+	}
+
+	if got := testutil.CollectAndCount(r.metrics.duration); got != 2 {
+		t.Errorf("got %d latency histograms, want 2", got)
+	}
+
+	wantGauges := `
+# HELP bukky_bucket_logical_bytes Sum of the sizes of all objects in the bucket.
+# TYPE bukky_bucket_logical_bytes gauge
+bukky_bucket_logical_bytes{bucket="test-bucket"} 24
+# HELP bukky_bucket_objects Number of objects in the bucket.
+# TYPE bukky_bucket_objects gauge
+bukky_bucket_objects{bucket="test-bucket"} 2
+`
+	if err := testutil.CollectAndCompare(newStoreCollector(backend), strings.NewReader(wantGauges),
+		"bukky_bucket_objects", "bukky_bucket_logical_bytes"); err != nil {
+		t.Errorf("gauges differ: %s", err)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %v, want %v", rec.Code, http.StatusOK)
+	}
+
+	if body := rec.Body.String(); !strings.Contains(body, `bukky_http_requests_total{code="200",handler="/objects/{bucket}/{objectID}"} 2`) {
+		t.Errorf("metrics do not contain request counter:\n%s", body)
+	}
+}
+
+func TestS3Metrics(t *testing.T) {
+	backend := memory.NewStore(log)
+	r := NewRouter(log, backend)
+	s3 := NewS3Router(log, backend, WithMetricsOf(r))
+
+	s3.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/test-bucket/test-object", strings.NewReader("test-content")))
+
+	const handler = "s3:/{bucket}/{objectID:.+}"
+	if got := testutil.ToFloat64(r.metrics.requests.WithLabelValues(handler, "200")); got != 1 {
+		t.Errorf("got %v requests, want 1", got)
+	}
+
+	if got := testutil.ToFloat64(r.metrics.bytesIn.WithLabelValues(handler)); got != 12 {
+		t.Errorf("got %v bytes in, want 12", got)
+	}
+}
+
+// readerFromWriter is a http.ResponseWriter implementing io.ReaderFrom.
+type readerFromWriter struct {
+	*httptest.ResponseRecorder
+	readFrom bool
+}
+
+func (w *readerFromWriter) ReadFrom(src io.Reader) (int64, error) {
+	w.readFrom = true
+	return io.Copy(w.ResponseRecorder, src)
+}
+
+func TestStatusRecorder(t *testing.T) {
+	t.Run("flusher", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		rec, writer := newStatusRecorder(w)
+
+		flusher, ok := writer.(http.Flusher)
+		if !ok {
+			t.Fatal("writer does not implement http.Flusher")
+		}
+		flusher.Flush()
+
+		if !w.Flushed {
+			t.Error("got response not flushed")
+		}
+
+		if !rec.wroteHeader {
+			t.Error("got header not written after flush")
+		}
+	})
+
+	t.Run("no flusher", func(t *testing.T) {
+		_, writer := newStatusRecorder(struct{ http.ResponseWriter }{httptest.NewRecorder()})
+
+		if _, ok := writer.(http.Flusher); ok {
+			t.Error("writer implements http.Flusher")
+		}
+	})
+
+	for _, w := range []http.ResponseWriter{&readerFromWriter{ResponseRecorder: httptest.NewRecorder()}, httptest.NewRecorder()} {
+		rec, writer := newStatusRecorder(w)
+		// The reader is wrapped, so that io.Copy can not use its io.WriterTo.
+		n, err := io.Copy(writer, struct{ io.Reader }{strings.NewReader("test-content")})
+		if err != nil {
+			t.Fatalf("can not copy: %s", err)
+		}
+
+		if n != 12 || rec.count != 12 {
+			t.Errorf("got %d bytes copied and %d counted, want 12", n, rec.count)
+		}
+
+		if rfw, ok := w.(*readerFromWriter); ok && !rfw.readFrom {
+			t.Error("got ReadFrom of writer not used")
+		}
+	}
+}
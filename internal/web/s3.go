@@ -0,0 +1,526 @@
+package web
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+const (
+	s3Namespace       = "http://s3.amazonaws.com/doc/2006-03-01/"
+	s3TimeFormat      = "2006-01-02T15:04:05.000Z"
+	s3MetaPrefix      = "X-Amz-Meta-"
+	s3StorageClass    = "STANDARD"
+	s3StreamingPrefix = "STREAMING-"
+	s3CopySource      = "X-Amz-Copy-Source"
+)
+
+// S3Router provides a subset of the S3 API using path-style addressing, so that existing S3 clients can be used
+// with bukky. Requests are not authenticated, signatures are ignored.
+type S3Router struct {
+	log     logrus.FieldLogger
+	backend store.Store
+	router  *mux.Router
+}
+
+// S3Option changes the behavior of the S3Router.
+type S3Option func(r *S3Router)
+
+// WithMetricsOf records the metrics of the S3 requests in the registry of the router, so that they are served by its
+// metrics endpoint together with the metrics of the default API.
+func WithMetricsOf(router *Router) S3Option {
+	return func(r *S3Router) {
+		r.router.Use(router.metrics.S3Middleware)
+	}
+}
+
+func NewS3Router(log logrus.FieldLogger, backend store.Store, opts ...S3Option) *S3Router {
+	r := &S3Router{
+		log:     log,
+		backend: backend,
+		router:  mux.NewRouter(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.router.Path("/").Methods(http.MethodGet).HandlerFunc(r.listBucketsHandler)
+
+	buckets := r.router.Path("/{bucket}").Subrouter()
+	buckets.Methods(http.MethodGet).HandlerFunc(r.listObjectsHandler)
+	buckets.Methods(http.MethodPut).HandlerFunc(r.createBucketHandler)
+	buckets.Methods(http.MethodHead).HandlerFunc(r.headBucketHandler)
+	buckets.Methods(http.MethodDelete).HandlerFunc(r.deleteBucketHandler)
+
+	objects := r.router.Path("/{bucket}/{objectID:.+}").Subrouter()
+	objects.Methods(http.MethodGet).HandlerFunc(r.getHandler)
+	objects.Methods(http.MethodHead).HandlerFunc(r.headHandler)
+	objects.Methods(http.MethodPut).HandlerFunc(r.putHandler)
+	objects.Methods(http.MethodDelete).HandlerFunc(r.deleteHandler)
+
+	return r
+}
+
+func (r *S3Router) Handler() http.Handler {
+	return r.router
+}
+
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource"`
+	RequestID string   `xml:"RequestId"`
+}
+
+type s3Bucket struct {
+	Name string `xml:"Name"`
+}
+
+type s3ListBucketsResult struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Object struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type s3ListObjectsResult struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              int              `xml:"KeyCount"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Marker                *string          `xml:"Marker"`
+	NextMarker            string           `xml:"NextMarker,omitempty"`
+	Contents              []s3Object       `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+func (r *S3Router) sendXML(w http.ResponseWriter, statusCode int, content interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write([]byte(xml.Header)); err != nil {
+		r.log.Errorf("Error writing XML: %s", err)
+		return
+	}
+
+	if err := xml.NewEncoder(w).Encode(content); err != nil {
+		r.log.Errorf("Error encoding XML: %s", err)
+	}
+}
+
+// sendError sends an S3 error response. Responses to HEAD requests only contain the status code.
+func (r *S3Router) sendError(w http.ResponseWriter, req *http.Request, statusCode int, code, message string) {
+	if req.Method == http.MethodHead {
+		w.WriteHeader(statusCode)
+		return
+	}
+
+	r.sendXML(w, statusCode, s3Error{
+		Code:     code,
+		Message:  message,
+		Resource: req.URL.Path,
+	})
+}
+
+func (r *S3Router) sendInternalError(w http.ResponseWriter, req *http.Request, err error) {
+	r.log.Errorf("Error handling %s %s: %s", req.Method, req.URL.Path, err)
+	r.sendError(w, req, http.StatusInternalServerError, "InternalError", "We encountered an internal error. Please try again.")
+}
+
+// sendNotFound distinguishes between missing buckets and missing objects, which are both reported as
+// store.ErrNotFound by the backend.
+func (r *S3Router) sendNotFound(w http.ResponseWriter, req *http.Request, bucket string) {
+	if !r.backend.BucketExists(bucket) {
+		r.sendError(w, req, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+
+	r.sendError(w, req, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+}
+
+func (r *S3Router) listBucketsHandler(w http.ResponseWriter, req *http.Request) {
+	names, err := r.backend.ListBuckets()
+	if err != nil {
+		r.sendInternalError(w, req, err)
+		return
+	}
+
+	result := s3ListBucketsResult{
+		Xmlns:   s3Namespace,
+		Buckets: make([]s3Bucket, 0, len(names)),
+	}
+	for _, name := range names {
+		result.Buckets = append(result.Buckets, s3Bucket{Name: name})
+	}
+	r.sendXML(w, http.StatusOK, result)
+}
+
+func (r *S3Router) createBucketHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	err := r.backend.CreateBucket(bucket, store.BucketOptions{})
+	switch {
+	case err == store.ErrBucketExists:
+		r.sendError(w, req, http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists.")
+		return
+	case err != nil:
+		r.sendInternalError(w, req, err)
+		return
+	default:
+	}
+
+	w.Header().Set("Location", "/"+bucket)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *S3Router) headBucketHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	if !r.backend.BucketExists(bucket) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *S3Router) deleteBucketHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	err := r.backend.DeleteBucket(bucket, false)
+	switch {
+	case err == store.ErrNotFound:
+		r.sendError(w, req, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	case err == store.ErrBucketNotEmpty:
+		r.sendError(w, req, http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty.")
+		return
+	case err != nil:
+		r.sendInternalError(w, req, err)
+		return
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listObjectsHandler implements ListObjectsV2 and ListObjects (V1) for requests without list-type.
+// V1 listings are continued using the last returned key or common prefix as marker.
+func (r *S3Router) listObjectsHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	query := req.URL.Query()
+	v2 := query.Get("list-type") == "2"
+	opts := store.ListOptions{
+		Prefix:    query.Get("prefix"),
+		Delimiter: query.Get("delimiter"),
+		MaxKeys:   store.DefaultMaxKeys,
+	}
+	if v2 {
+		opts.ContinuationToken = query.Get("continuation-token")
+		opts.StartAfter = query.Get("start-after")
+	} else {
+		opts.StartAfter = query.Get("marker")
+	}
+
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			r.sendError(w, req, http.StatusBadRequest, "InvalidArgument", fmt.Sprintf("Invalid max-keys: %q", value))
+			return
+		}
+		opts.MaxKeys = maxKeys
+	}
+
+	list, err := r.backend.List(bucket, opts)
+	switch {
+	case err == store.ErrNotFound:
+		r.sendError(w, req, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	case err == store.ErrInvalidToken:
+		r.sendError(w, req, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect.")
+		return
+	case err != nil:
+		r.sendInternalError(w, req, err)
+		return
+	default:
+	}
+
+	result := s3ListObjectsResult{
+		Xmlns:       s3Namespace,
+		Name:        bucket,
+		Prefix:      opts.Prefix,
+		Delimiter:   opts.Delimiter,
+		MaxKeys:     opts.MaxKeys,
+		IsTruncated: list.Truncated,
+	}
+	if v2 {
+		result.ContinuationToken = opts.ContinuationToken
+		result.NextContinuationToken = list.NextContinuationToken
+		result.StartAfter = opts.StartAfter
+	} else {
+		result.Marker = &opts.StartAfter
+	}
+	for _, objectID := range list.Objects {
+		meta, err := r.backend.Head(bucket, objectID, store.GetOptions{})
+		switch {
+		case err == store.ErrNotFound:
+			// Deleted since listing.
+			continue
+		case err != nil:
+			r.sendInternalError(w, req, err)
+			return
+		default:
+		}
+
+		result.Contents = append(result.Contents, s3Object{
+			Key:          objectID,
+			LastModified: s3Time(meta.Modified),
+			ETag:         etag(meta.Digest),
+			Size:         meta.Size,
+			StorageClass: s3StorageClass,
+		})
+	}
+
+	for _, prefix := range list.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: prefix})
+	}
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	if !v2 && list.Truncated {
+		result.NextMarker = lastListed(list)
+	}
+
+	r.sendXML(w, http.StatusOK, result)
+}
+
+func (r *S3Router) getHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, objectID := reqVars(req)
+	content, meta, err := r.backend.Get(bucket, objectID, getOptions(req))
+	switch {
+	case err == store.ErrNotFound:
+		r.sendNotFound(w, req, bucket)
+		return
+	case err != nil:
+		r.sendInternalError(w, req, err)
+		return
+	default:
+	}
+
+	defer content.Close()
+
+	setS3Headers(w.Header(), meta)
+	http.ServeContent(w, req, "", meta.Modified, content)
+}
+
+func (r *S3Router) headHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, objectID := reqVars(req)
+	meta, err := r.backend.Head(bucket, objectID, getOptions(req))
+	switch {
+	case err == store.ErrNotFound:
+		r.sendNotFound(w, req, bucket)
+		return
+	case err != nil:
+		r.sendInternalError(w, req, err)
+		return
+	default:
+	}
+
+	if preconditionFailed(req, meta) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	if notModified(req, meta) {
+		sendNotModified(w, meta)
+		return
+	}
+
+	setS3Headers(w.Header(), meta)
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *S3Router) putHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	query := req.URL.Query()
+	if query.Get("uploadId") != "" || query.Get("partNumber") != "" {
+		r.sendError(w, req, http.StatusNotImplemented, "NotImplemented", "Multipart uploads are not supported.")
+		return
+	}
+
+	if req.Header.Get(s3CopySource) != "" {
+		r.copyHandler(w, req)
+		return
+	}
+
+	bucket, objectID := reqVars(req)
+	opts := store.PutOptions{
+		ContentType: req.Header.Get("Content-Type"),
+		Condition:   requestCondition(req),
+	}
+	for name, values := range req.Header {
+		if !strings.HasPrefix(name, s3MetaPrefix) || len(name) == len(s3MetaPrefix) {
+			continue
+		}
+
+		if opts.UserMetadata == nil {
+			opts.UserMetadata = make(map[string]string)
+		}
+		opts.UserMetadata[strings.TrimPrefix(name, s3MetaPrefix)] = strings.Join(values, ",")
+	}
+
+	body := &errorTrackingReader{reader: req.Body}
+	if strings.HasPrefix(req.Header.Get("X-Amz-Content-Sha256"), s3StreamingPrefix) {
+		body.reader = newAWSChunkedReader(req.Body)
+	}
+
+	meta, err := r.backend.Put(bucket, objectID, body, opts)
+	if err != nil {
+		switch {
+		case body.err != nil:
+			r.sendError(w, req, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Can not read body: %s", body.err))
+		case err == store.ErrPreconditionFailed:
+			r.sendError(w, req, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions you specified did not hold.")
+		case err == store.ErrInsufficientStorage:
+			r.sendError(w, req, http.StatusInsufficientStorage, "InsufficientStorage", "The object does not fit into the storage quota.")
+		default:
+			r.sendInternalError(w, req, err)
+		}
+		return
+	}
+
+	w.Header().Set("ETag", etag(meta.Digest))
+	w.WriteHeader(http.StatusOK)
+}
+
+// copyHandler handles CopyObject requests, which are PUT requests with the source given in a header. The metadata of
+// the source object is always kept.
+func (r *S3Router) copyHandler(w http.ResponseWriter, req *http.Request) {
+	if strings.EqualFold(req.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		r.sendError(w, req, http.StatusNotImplemented, "NotImplemented", "Replacing the metadata of a copy is not supported.")
+		return
+	}
+
+	srcBucket, srcObjectID, versionID, err := copySource(req.Header.Get(s3CopySource))
+	if err != nil {
+		r.sendError(w, req, http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey.")
+		return
+	}
+
+	bucket, objectID := reqVars(req)
+	meta, err := r.backend.Copy(srcBucket, srcObjectID, bucket, objectID, store.CopyOptions{
+		VersionID: versionID,
+		Condition: requestCondition(req),
+	})
+	switch {
+	case err == store.ErrNotFound:
+		r.sendNotFound(w, req, srcBucket)
+		return
+	case err == store.ErrPreconditionFailed:
+		r.sendError(w, req, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions you specified did not hold.")
+		return
+	case err == store.ErrInsufficientStorage:
+		r.sendError(w, req, http.StatusInsufficientStorage, "InsufficientStorage", "The object does not fit into the storage quota.")
+		return
+	case err != nil:
+		r.sendInternalError(w, req, err)
+		return
+	default:
+	}
+
+	r.sendXML(w, http.StatusOK, s3CopyObjectResult{
+		LastModified: s3Time(meta.Modified),
+		ETag:         etag(meta.Digest),
+	})
+}
+
+func (r *S3Router) deleteHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, objectID := reqVars(req)
+	if !r.backend.BucketExists(bucket) {
+		r.sendError(w, req, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+
+	err := r.backend.Delete(bucket, objectID, store.DeleteOptions{
+		Condition: requestCondition(req),
+		VersionID: req.URL.Query().Get("versionId"),
+	})
+	switch {
+	case err == store.ErrNotFound:
+		// Deleting a missing object succeeds in S3.
+	case err == store.ErrPreconditionFailed:
+		r.sendError(w, req, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions you specified did not hold.")
+		return
+	case err != nil:
+		r.sendInternalError(w, req, err)
+		return
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setS3Headers(h http.Header, meta store.Metadata) {
+	if meta.ContentType != "" {
+		h.Set("Content-Type", meta.ContentType)
+	}
+	h.Set("Last-Modified", meta.Modified.UTC().Format(http.TimeFormat))
+	h.Set("ETag", etag(meta.Digest))
+	h.Set("Accept-Ranges", "bytes")
+	if meta.VersionID != "" {
+		h.Set("X-Amz-Version-Id", meta.VersionID)
+	}
+
+	for key, value := range meta.User {
+		h.Set(s3MetaPrefix+key, value)
+	}
+}
+
+// lastListed returns the last object ID or common prefix of the listing, whichever is sorted last.
+func lastListed(list store.ListResult) string {
+	last := ""
+	if len(list.Objects) > 0 {
+		last = list.Objects[len(list.Objects)-1]
+	}
+	if len(list.CommonPrefixes) > 0 {
+		if prefix := list.CommonPrefixes[len(list.CommonPrefixes)-1]; prefix > last {
+			last = prefix
+		}
+	}
+
+	return last
+}
+
+// s3Time formats a time like the timestamps in S3 listings.
+func s3Time(t time.Time) string {
+	return t.UTC().Format(s3TimeFormat)
+}
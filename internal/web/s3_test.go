@@ -0,0 +1,425 @@
+package web
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/xperimental/bukky/internal/store/memory"
+)
+
+var lastModifiedRegex = regexp.MustCompile(`<LastModified>[^<]+</LastModified>`)
+
+type s3Step struct {
+	desc       string
+	method     string
+	path       string
+	header     http.Header
+	body       string
+	wantStatus int
+	wantHeader http.Header
+	wantBody   string
+}
+
+func runS3Steps(t *testing.T, steps []s3Step) {
+	r := NewS3Router(log, memory.NewStore(log))
+	for _, s := range steps {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(s.method, s.path, strings.NewReader(s.body))
+		for name, values := range s.header {
+			req.Header[name] = values
+		}
+
+		r.Handler().ServeHTTP(rec, req)
+
+		if rec.Code != s.wantStatus {
+			t.Errorf("%s: got status %v, want %v", s.desc, rec.Code, s.wantStatus)
+		}
+
+		for name := range s.wantHeader {
+			if diff := cmp.Diff(rec.Header().Values(name), s.wantHeader.Values(name)); diff != "" {
+				t.Errorf("%s: header %s differs: -got+want\n%s", s.desc, name, diff)
+			}
+		}
+
+		body := lastModifiedRegex.ReplaceAllString(rec.Body.String(), "<LastModified></LastModified>")
+		if diff := cmp.Diff(body, s.wantBody); diff != "" {
+			t.Errorf("%s: body differs: -got+want\n%s", s.desc, diff)
+		}
+	}
+}
+
+func TestS3Buckets(t *testing.T) {
+	runS3Steps(t, []s3Step{
+		{
+			desc:       "create bucket",
+			method:     http.MethodPut,
+			path:       "/test-bucket",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{
+				"Location": {"/test-bucket"},
+			},
+		},
+		{
+			desc:       "create existing bucket",
+			method:     http.MethodPut,
+			path:       "/test-bucket",
+			wantStatus: http.StatusConflict,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>BucketAlreadyOwnedByYou</Code><Message>The bucket already exists.</Message><Resource>/test-bucket</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "head bucket",
+			method:     http.MethodHead,
+			path:       "/test-bucket",
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:       "head missing bucket",
+			method:     http.MethodHead,
+			path:       "/other-bucket",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			desc:       "put object",
+			method:     http.MethodPut,
+			path:       "/test-bucket/test-object",
+			body:       "test-content",
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:       "list buckets",
+			method:     http.MethodGet,
+			path:       "/",
+			wantStatus: http.StatusOK,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<ListAllMyBucketsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Buckets><Bucket><Name>test-bucket</Name></Bucket></Buckets></ListAllMyBucketsResult>`,
+		},
+		{
+			desc:       "delete bucket not empty",
+			method:     http.MethodDelete,
+			path:       "/test-bucket",
+			wantStatus: http.StatusConflict,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>BucketNotEmpty</Code><Message>The bucket you tried to delete is not empty.</Message><Resource>/test-bucket</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "delete object",
+			method:     http.MethodDelete,
+			path:       "/test-bucket/test-object",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			desc:       "delete bucket",
+			method:     http.MethodDelete,
+			path:       "/test-bucket",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			desc:       "delete missing bucket",
+			method:     http.MethodDelete,
+			path:       "/test-bucket",
+			wantStatus: http.StatusNotFound,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist.</Message><Resource>/test-bucket</Resource><RequestId></RequestId></Error>`,
+		},
+	})
+}
+
+func TestS3Objects(t *testing.T) {
+	const testETag = `"0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e"`
+
+	runS3Steps(t, []s3Step{
+		{
+			desc:   "put object",
+			method: http.MethodPut,
+			path:   "/test-bucket/dir/test-object",
+			header: http.Header{
+				"Content-Type":      {"text/plain"},
+				"X-Amz-Meta-Author": {"test-author"},
+			},
+			body:       "test-content",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{
+				"Etag": {testETag},
+			},
+		},
+		{
+			desc:   "put streaming upload",
+			method: http.MethodPut,
+			path:   "/test-bucket/streamed",
+			header: http.Header{
+				"X-Amz-Content-Sha256": {"STREAMING-AWS4-HMAC-SHA256-PAYLOAD"},
+			},
+			body:       "5;chunk-signature=abc\r\ntest-\r\n7;chunk-signature=def\r\ncontent\r\n0;chunk-signature=ghi\r\n\r\n",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{
+				"Etag": {testETag},
+			},
+		},
+		{
+			desc:   "put existing with if-none-match",
+			method: http.MethodPut,
+			path:   "/test-bucket/streamed",
+			header: http.Header{
+				"If-None-Match": {"*"},
+			},
+			body:       "other-content",
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>PreconditionFailed</Code><Message>At least one of the preconditions you specified did not hold.</Message><Resource>/test-bucket/streamed</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "get object",
+			method:     http.MethodGet,
+			path:       "/test-bucket/dir/test-object",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type":      {"text/plain"},
+				"Etag":              {testETag},
+				"X-Amz-Meta-Author": {"test-author"},
+			},
+			wantBody: "test-content",
+		},
+		{
+			desc:   "get range",
+			method: http.MethodGet,
+			path:   "/test-bucket/streamed",
+			header: http.Header{
+				"Range": {"bytes=5-"},
+			},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   "content",
+		},
+		{
+			desc:       "head object",
+			method:     http.MethodHead,
+			path:       "/test-bucket/dir/test-object",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Length": {"12"},
+				"Etag":           {testETag},
+			},
+		},
+		{
+			desc:   "head object with different if-match",
+			method: http.MethodHead,
+			path:   "/test-bucket/dir/test-object",
+			header: http.Header{
+				"If-Match": {`"other-etag"`},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+			wantHeader: http.Header{
+				"Content-Length": nil,
+			},
+		},
+		{
+			desc:       "head missing object",
+			method:     http.MethodHead,
+			path:       "/test-bucket/missing",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			desc:       "get missing object",
+			method:     http.MethodGet,
+			path:       "/test-bucket/missing",
+			wantStatus: http.StatusNotFound,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Resource>/test-bucket/missing</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "get object in missing bucket",
+			method:     http.MethodGet,
+			path:       "/other-bucket/test-object",
+			wantStatus: http.StatusNotFound,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist.</Message><Resource>/other-bucket/test-object</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "list objects",
+			method:     http.MethodGet,
+			path:       "/test-bucket?list-type=2&delimiter=%2F",
+			wantStatus: http.StatusOK,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>test-bucket</Name><Prefix></Prefix><Delimiter>/</Delimiter><MaxKeys>1000</MaxKeys><KeyCount>2</KeyCount><IsTruncated>false</IsTruncated><Contents><Key>streamed</Key><LastModified></LastModified><ETag>&#34;0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e&#34;</ETag><Size>12</Size><StorageClass>STANDARD</StorageClass></Contents><CommonPrefixes><Prefix>dir/</Prefix></CommonPrefixes></ListBucketResult>`,
+		},
+		{
+			desc:       "list objects v1 truncated",
+			method:     http.MethodGet,
+			path:       "/test-bucket?delimiter=%2F&max-keys=1",
+			wantStatus: http.StatusOK,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>test-bucket</Name><Prefix></Prefix><Delimiter>/</Delimiter><MaxKeys>1</MaxKeys><KeyCount>1</KeyCount><IsTruncated>true</IsTruncated><Marker></Marker><NextMarker>dir/</NextMarker><CommonPrefixes><Prefix>dir/</Prefix></CommonPrefixes></ListBucketResult>`,
+		},
+		{
+			desc:       "list objects v1 marker",
+			method:     http.MethodGet,
+			path:       "/test-bucket?delimiter=%2F&max-keys=1&marker=dir%2F",
+			wantStatus: http.StatusOK,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Name>test-bucket</Name><Prefix></Prefix><Delimiter>/</Delimiter><MaxKeys>1</MaxKeys><KeyCount>1</KeyCount><IsTruncated>false</IsTruncated><Marker>dir/</Marker><Contents><Key>streamed</Key><LastModified></LastModified><ETag>&#34;0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e&#34;</ETag><Size>12</Size><StorageClass>STANDARD</StorageClass></Contents></ListBucketResult>`,
+		},
+		{
+			desc:       "list objects invalid max-keys",
+			method:     http.MethodGet,
+			path:       "/test-bucket?list-type=2&max-keys=many",
+			wantStatus: http.StatusBadRequest,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>InvalidArgument</Code><Message>Invalid max-keys: &#34;many&#34;</Message><Resource>/test-bucket</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "delete missing object",
+			method:     http.MethodDelete,
+			path:       "/test-bucket/missing",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			desc:   "delete changed object",
+			method: http.MethodDelete,
+			path:   "/test-bucket/streamed",
+			header: http.Header{
+				"If-Match": {`"other-digest"`},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>PreconditionFailed</Code><Message>At least one of the preconditions you specified did not hold.</Message><Resource>/test-bucket/streamed</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "delete object",
+			method:     http.MethodDelete,
+			path:       "/test-bucket/streamed",
+			wantStatus: http.StatusNoContent,
+		},
+	})
+}
+
+func TestS3Copy(t *testing.T) {
+	const testETag = `"0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e"`
+
+	runS3Steps(t, []s3Step{
+		{
+			desc:   "put object",
+			method: http.MethodPut,
+			path:   "/test-bucket/test-object",
+			header: http.Header{
+				"Content-Type": {"text/plain"},
+			},
+			body:       "test-content",
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:   "copy object",
+			method: http.MethodPut,
+			path:   "/test-bucket/copied",
+			header: http.Header{
+				"X-Amz-Copy-Source": {"/test-bucket/test-object"},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<CopyObjectResult><LastModified></LastModified><ETag>&#34;0a3666a0710c08aa6d0de92ce72beeb5b93124cce1bf3701c9d6cdeb543cb73e&#34;</ETag></CopyObjectResult>`,
+		},
+		{
+			desc:       "get copy",
+			method:     http.MethodGet,
+			path:       "/test-bucket/copied",
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{
+				"Content-Type": {"text/plain"},
+				"Etag":         {testETag},
+			},
+			wantBody: "test-content",
+		},
+		{
+			desc:   "copy missing object",
+			method: http.MethodPut,
+			path:   "/test-bucket/copied",
+			header: http.Header{
+				"X-Amz-Copy-Source": {"test-bucket/missing"},
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message><Resource>/test-bucket/copied</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:   "copy replacing metadata",
+			method: http.MethodPut,
+			path:   "/test-bucket/copied",
+			header: http.Header{
+				"X-Amz-Copy-Source":        {"test-bucket/test-object"},
+				"X-Amz-Metadata-Directive": {"REPLACE"},
+			},
+			wantStatus: http.StatusNotImplemented,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NotImplemented</Code><Message>Replacing the metadata of a copy is not supported.</Message><Resource>/test-bucket/copied</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "upload part",
+			method:     http.MethodPut,
+			path:       "/test-bucket/test-object?partNumber=1&uploadId=test-upload",
+			body:       "other-content",
+			wantStatus: http.StatusNotImplemented,
+			wantBody: `<?xml version="1.0" encoding="UTF-8"?>
+<Error><Code>NotImplemented</Code><Message>Multipart uploads are not supported.</Message><Resource>/test-bucket/test-object</Resource><RequestId></RequestId></Error>`,
+		},
+		{
+			desc:       "object unchanged by upload part",
+			method:     http.MethodGet,
+			path:       "/test-bucket/test-object",
+			wantStatus: http.StatusOK,
+			wantBody:   "test-content",
+		},
+	})
+}
+
+func TestAWSChunkedReader(t *testing.T) {
+	tt := []struct {
+		desc        string
+		input       string
+		wantContent string
+		wantErr     error
+	}{
+		{
+			desc:        "success",
+			input:       "3;chunk-signature=abc\r\nabc\r\n2;chunk-signature=def\r\nde\r\n0;chunk-signature=ghi\r\n\r\n",
+			wantContent: "abcde",
+		},
+		{
+			desc:        "empty",
+			input:       "0;chunk-signature=abc\r\n\r\n",
+			wantContent: "",
+		},
+		{
+			desc:        "truncated",
+			input:       "5;chunk-signature=abc\r\nabc",
+			wantContent: "abc",
+			wantErr:     io.ErrUnexpectedEOF,
+		},
+		{
+			desc:        "missing last chunk",
+			input:       "3;chunk-signature=abc\r\nabc\r\n",
+			wantContent: "abc",
+			wantErr:     io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			content, err := ioutil.ReadAll(newAWSChunkedReader(strings.NewReader(tc.input)))
+			if err != tc.wantErr {
+				t.Errorf("got error %q, want %q", err, tc.wantErr)
+			}
+
+			if string(content) != tc.wantContent {
+				t.Errorf("got content %q, want %q", content, tc.wantContent)
+			}
+		})
+	}
+}
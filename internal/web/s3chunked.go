@@ -0,0 +1,81 @@
+package web
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// awsChunkedReader decodes the aws-chunked encoding used by S3 clients for streaming uploads.
+// Every chunk is prefixed with its size and a signature, which is not verified.
+type awsChunkedReader struct {
+	reader    *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func newAWSChunkedReader(r io.Reader) *awsChunkedReader {
+	return &awsChunkedReader{
+		reader: bufio.NewReader(r),
+	}
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	if c.done {
+		return 0, io.EOF
+	}
+
+	if c.remaining == 0 {
+		header, err := c.reader.ReadString('\n')
+		if err != nil {
+			return 0, unexpectedEOF(err)
+		}
+
+		sizeHex := strings.TrimSpace(strings.SplitN(header, ";", 2)[0])
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil || size < 0 {
+			return 0, fmt.Errorf("invalid chunk size: %q", sizeHex)
+		}
+
+		// The last chunk is empty. Trailing headers are ignored.
+		if size == 0 {
+			c.done = true
+			return 0, io.EOF
+		}
+		c.remaining = size
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.reader.Read(p)
+	c.remaining -= int64(n)
+	if err != nil {
+		return n, unexpectedEOF(err)
+	}
+
+	if c.remaining == 0 {
+		var crlf [2]byte
+		if _, err := io.ReadFull(c.reader, crlf[:]); err != nil {
+			return n, unexpectedEOF(err)
+		}
+
+		if string(crlf[:]) != "\r\n" {
+			return n, errors.New("missing chunk terminator")
+		}
+	}
+
+	return n, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,138 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+// completeRequest is the optional body of a request completing a multipart upload.
+type completeRequest struct {
+	Parts []store.Part `json:"parts"`
+}
+
+// sendUploadError sends the response for errors of the multipart upload operations.
+func (r *Router) sendUploadError(w http.ResponseWriter, uploadID string, err error) {
+	switch {
+	case err == store.ErrUploadNotFound:
+		http.Error(w, fmt.Sprintf("upload not found: %s", uploadID), http.StatusNotFound)
+	case err == store.ErrInvalidPart:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err == store.ErrPreconditionFailed:
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	case err == store.ErrInsufficientStorage:
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+	default:
+		http.Error(w, fmt.Sprintf("can not process upload: %s", err), http.StatusInternalServerError)
+	}
+}
+
+func (r *Router) createUploadHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, objectID := reqVars(req)
+	if r.explicitBuckets && !r.backend.BucketExists(bucket) {
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	}
+
+	opts, err := putOptions(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uploadID, err := r.backend.CreateUpload(bucket, objectID, opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can not create upload: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		UploadID string `json:"uploadId"`
+	}{
+		UploadID: uploadID,
+	}
+	sendJSON(r.log, w, http.StatusCreated, response)
+}
+
+func (r *Router) putPartHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	bucket, objectID := reqVars(req)
+	vars := mux.Vars(req)
+	uploadID := vars["uploadID"]
+	number, err := strconv.Atoi(vars["partNumber"])
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid part number: %q", vars["partNumber"]), http.StatusBadRequest)
+		return
+	}
+
+	body := &errorTrackingReader{reader: req.Body}
+	part, err := r.backend.PutPart(bucket, objectID, uploadID, number, body)
+	if body.err != nil {
+		http.Error(w, fmt.Sprintf("can not read body: %s", body.err), http.StatusInternalServerError)
+		return
+	}
+
+	if err != nil {
+		r.sendUploadError(w, uploadID, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(part.Digest))
+	sendJSON(r.log, w, http.StatusOK, part)
+}
+
+func (r *Router) listPartsHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, objectID := reqVars(req)
+	uploadID := mux.Vars(req)["uploadID"]
+	parts, err := r.backend.ListParts(bucket, objectID, uploadID)
+	if err != nil {
+		r.sendUploadError(w, uploadID, err)
+		return
+	}
+
+	response := struct {
+		Parts []store.Part `json:"parts"`
+	}{
+		Parts: parts,
+	}
+	sendJSON(r.log, w, http.StatusOK, response)
+}
+
+func (r *Router) completeUploadHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	bucket, objectID := reqVars(req)
+	uploadID := mux.Vars(req)["uploadID"]
+
+	var complete completeRequest
+	if err := json.NewDecoder(req.Body).Decode(&complete); err != nil && err != io.EOF {
+		http.Error(w, fmt.Sprintf("can not parse body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := r.backend.CompleteUpload(bucket, objectID, uploadID, complete.Parts)
+	if err != nil {
+		r.sendUploadError(w, uploadID, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(meta.Digest))
+	sendJSON(r.log, w, http.StatusCreated, newObjectResponse(objectID, meta))
+}
+
+func (r *Router) abortUploadHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, objectID := reqVars(req)
+	uploadID := mux.Vars(req)["uploadID"]
+	if err := r.backend.AbortUpload(bucket, objectID, uploadID); err != nil {
+		r.sendUploadError(w, uploadID, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,10 +2,31 @@ package web
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+)
+
+const (
+	headerCreated    = "X-Bukky-Created"
+	headerDigest     = "X-Bukky-Digest"
+	headerMetaPrefix = "X-Bukky-Meta-"
+	headerVersionID  = "X-Bukky-Version-Id"
+	headerTTL        = "X-Bukky-TTL"
+	headerExpires    = "X-Bukky-Expires"
+	// headerContentDigest references an existing content instead of uploading it again.
+	headerContentDigest = "X-Bukky-Content-Digest"
+	// headerCopySource and headerMoveSource copy or move an existing object instead of uploading it again.
+	headerCopySource = "X-Bukky-Copy-Source"
+	headerMoveSource = "X-Bukky-Move-Source"
 )
 
 func reqVars(r *http.Request) (bucket, objectID string) {
@@ -22,3 +43,218 @@ func sendJSON(log logrus.FieldLogger, w http.ResponseWriter, statusCode int, con
 		log.Errorf("Error encoding stats JSON: %s", err)
 	}
 }
+
+// errorTrackingReader remembers the first error returned by the underlying reader, so that errors reading the request
+// can be told apart from errors of the backend.
+type errorTrackingReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *errorTrackingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if err != nil && err != io.EOF && r.err == nil {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// limitedBody counts the bytes read from a body limited by http.MaxBytesReader, so that an error caused by exceeding
+// the limit can be told apart from other errors reading the body.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
+
+// exceeded returns true if the whole limit has been read. The body is only read up to the limit, if it is larger.
+func (b *limitedBody) exceeded() bool {
+	return b.remaining <= 0
+}
+
+// getOptions selects the version of the object requested using the versionId parameter.
+func getOptions(req *http.Request) store.GetOptions {
+	return store.GetOptions{
+		VersionID: req.URL.Query().Get("versionId"),
+	}
+}
+
+// putOptions collects the metadata of an object from the headers of the request.
+// The TTL is given in seconds.
+func putOptions(req *http.Request) (store.PutOptions, error) {
+	opts := store.PutOptions{
+		ContentType: req.Header.Get("Content-Type"),
+		Condition:   requestCondition(req),
+	}
+
+	if value := req.Header.Get(headerTTL); value != "" {
+		seconds, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || seconds == 0 {
+			return store.PutOptions{}, fmt.Errorf("invalid TTL: %q", value)
+		}
+		opts.TTL = time.Duration(seconds) * time.Second
+	}
+
+	for name, values := range req.Header {
+		if !strings.HasPrefix(name, headerMetaPrefix) || len(name) == len(headerMetaPrefix) {
+			continue
+		}
+
+		if opts.UserMetadata == nil {
+			opts.UserMetadata = make(map[string]string)
+		}
+		opts.UserMetadata[strings.TrimPrefix(name, headerMetaPrefix)] = strings.Join(values, ",")
+	}
+
+	return opts, nil
+}
+
+// setMetadataHeaders adds the metadata of an object to the response headers. Content-Length is not set, because it
+// depends on the status of the response.
+func setMetadataHeaders(h http.Header, meta store.Metadata) {
+	if meta.ContentType != "" {
+		h.Set("Content-Type", meta.ContentType)
+	}
+	h.Set("Last-Modified", meta.Modified.UTC().Format(http.TimeFormat))
+	h.Set(headerCreated, meta.Created.UTC().Format(http.TimeFormat))
+	h.Set(headerDigest, string(meta.Digest))
+	h.Set("ETag", etag(meta.Digest))
+	h.Set("Accept-Ranges", "bytes")
+	if meta.VersionID != "" {
+		h.Set(headerVersionID, meta.VersionID)
+	}
+	if meta.Expires != nil {
+		h.Set(headerExpires, meta.Expires.UTC().Format(http.TimeFormat))
+	}
+
+	for key, value := range meta.User {
+		h.Set(headerMetaPrefix+key, value)
+	}
+}
+
+// hasBody returns true if the request body contains at least one byte.
+func hasBody(req *http.Request) bool {
+	n, _ := io.ReadFull(req.Body, make([]byte, 1))
+	return n > 0
+}
+
+// objectResponse is sent after an object has been saved.
+type objectResponse struct {
+	ID     string        `json:"id"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// newObjectResponse identifies the saved object by its version ID in buckets with versioning.
+func newObjectResponse(objectID string, meta store.Metadata) objectResponse {
+	id := objectID
+	if meta.VersionID != "" {
+		id = meta.VersionID
+	}
+
+	return objectResponse{
+		ID:     id,
+		Digest: meta.Digest,
+	}
+}
+
+// etag formats the digest as a strong entity tag.
+func etag(d digest.Digest) string {
+	return `"` + string(d) + `"`
+}
+
+// parseETags parses the list of entity tags of a conditional header. Weak tags are only converted to digests
+// when weak is set, otherwise they are kept as-is, so that they never match a digest.
+func parseETags(value string, weak bool) []digest.Digest {
+	var digests []digest.Digest
+	for _, tag := range strings.Split(value, ",") {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "":
+			continue
+		case tag == "*":
+			digests = append(digests, store.MatchAny)
+			continue
+		case strings.HasPrefix(tag, "W/"):
+			if !weak {
+				digests = append(digests, digest.Digest(tag))
+				continue
+			}
+			tag = tag[2:]
+		default:
+		}
+
+		digests = append(digests, digest.Digest(strings.Trim(tag, `"`)))
+	}
+
+	return digests
+}
+
+// requestCondition creates the condition of a modification from the If-Match and If-None-Match headers.
+func requestCondition(req *http.Request) store.Condition {
+	return store.Condition{
+		IfMatch:     parseETags(req.Header.Get("If-Match"), false),
+		IfNoneMatch: parseETags(req.Header.Get("If-None-Match"), true),
+	}
+}
+
+// notModified returns true if the client already has the current version of the object.
+// If-Modified-Since is ignored when If-None-Match is present.
+func notModified(req *http.Request, meta store.Metadata) bool {
+	if value := req.Header.Get("If-None-Match"); value != "" {
+		for _, d := range parseETags(value, true) {
+			if d == store.MatchAny || d == meta.Digest {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if value := req.Header.Get("If-Modified-Since"); value != "" {
+		since, err := http.ParseTime(value)
+		if err != nil {
+			return false
+		}
+
+		return !meta.Modified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+// preconditionFailed returns true if the If-Match or If-Unmodified-Since header does not match the object.
+// If-Unmodified-Since is ignored when If-Match is present.
+func preconditionFailed(req *http.Request, meta store.Metadata) bool {
+	if value := req.Header.Get("If-Match"); value != "" {
+		for _, d := range parseETags(value, false) {
+			if d == store.MatchAny || d == meta.Digest {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if value := req.Header.Get("If-Unmodified-Since"); value != "" {
+		since, err := http.ParseTime(value)
+		if err != nil {
+			return false
+		}
+
+		return meta.Modified.Truncate(time.Second).After(since)
+	}
+
+	return false
+}
+
+func sendNotModified(w http.ResponseWriter, meta store.Metadata) {
+	w.Header().Set("ETag", etag(meta.Digest))
+	w.Header().Set("Last-Modified", meta.Modified.UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNotModified)
+}
@@ -0,0 +1,81 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xperimental/bukky/internal/store"
+)
+
+type versioningConfig struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (r *Router) getVersioningHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	enabled, err := r.backend.Versioning(bucket)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not get versioning: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	sendJSON(r.log, w, http.StatusOK, versioningConfig{Enabled: enabled})
+}
+
+func (r *Router) setVersioningHandler(w http.ResponseWriter, req *http.Request) {
+	defer req.Body.Close()
+
+	bucket, _ := reqVars(req)
+	var config versioningConfig
+	if err := json.NewDecoder(req.Body).Decode(&config); err != nil {
+		http.Error(w, fmt.Sprintf("can not parse body: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	err := r.backend.SetVersioning(bucket, config.Enabled)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err == store.ErrNotSupported:
+		http.Error(w, "versioning is not supported by the backend", http.StatusNotImplemented)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not set versioning: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *Router) listVersionsHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	versions, err := r.backend.ListVersions(bucket, req.URL.Query().Get("prefix"))
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not list versions: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	if versions == nil {
+		versions = []store.Version{}
+	}
+
+	response := struct {
+		Versions []store.Version `json:"versions"`
+	}{
+		Versions: versions,
+	}
+	sendJSON(r.log, w, http.StatusOK, response)
+}
@@ -2,33 +2,100 @@ package web
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/digest"
 	"github.com/xperimental/bukky/internal/store"
 )
 
 type Router struct {
-	log     logrus.FieldLogger
-	backend store.Store
-	router  *mux.Router
+	log             logrus.FieldLogger
+	backend         store.Store
+	router          *mux.Router
+	metrics         *metrics
+	explicitBuckets bool
+	batchMaxBytes   int64
 }
 
-func NewRouter(log logrus.FieldLogger, backend store.Store) *Router {
+// Option changes the behavior of the Router.
+type Option func(r *Router)
+
+// WithExplicitBuckets disables the implicit creation of buckets when an object is put into a bucket
+// that does not exist yet. Buckets need to be created using the bucket API instead.
+func WithExplicitBuckets() Option {
+	return func(r *Router) {
+		r.explicitBuckets = true
+	}
+}
+
+// WithBatchMaxBytes limits the size of the body of batch requests. It defaults to DefaultBatchMaxBytes.
+func WithBatchMaxBytes(limit int64) Option {
+	return func(r *Router) {
+		r.batchMaxBytes = limit
+	}
+}
+
+func NewRouter(log logrus.FieldLogger, backend store.Store, opts ...Option) *Router {
 	r := &Router{
-		log:     log,
-		backend: backend,
-		router:  mux.NewRouter(),
+		log:           log,
+		backend:       backend,
+		router:        mux.NewRouter(),
+		metrics:       newMetrics(backend),
+		batchMaxBytes: DefaultBatchMaxBytes,
 	}
 
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	r.router.Use(r.metrics.Middleware)
+
+	r.router.Path("/buckets").Methods(http.MethodGet).HandlerFunc(r.listBucketsHandler)
+
+	buckets := r.router.Path("/buckets/{bucket}").Subrouter()
+	buckets.Methods(http.MethodPut).HandlerFunc(r.createBucketHandler)
+	buckets.Methods(http.MethodHead).HandlerFunc(r.headBucketHandler)
+	buckets.Methods(http.MethodDelete).HandlerFunc(r.deleteBucketHandler)
+
+	versioning := r.router.Path("/buckets/{bucket}/versioning").Subrouter()
+	versioning.Methods(http.MethodGet).HandlerFunc(r.getVersioningHandler)
+	versioning.Methods(http.MethodPut).HandlerFunc(r.setVersioningHandler)
+
+	lifecycle := r.router.Path("/buckets/{bucket}/lifecycle").Subrouter()
+	lifecycle.Methods(http.MethodGet).HandlerFunc(r.getLifecycleHandler)
+	lifecycle.Methods(http.MethodPut).HandlerFunc(r.setLifecycleHandler)
+
+	r.router.Path("/objects/{bucket}").Methods(http.MethodGet).HandlerFunc(r.listHandler)
+	r.router.Path("/versions/{bucket}").Methods(http.MethodGet).HandlerFunc(r.listVersionsHandler)
+
 	objects := r.router.Path("/objects/{bucket}/{objectID}").Subrouter()
 	objects.Methods(http.MethodGet).HandlerFunc(r.getHandler)
+	objects.Methods(http.MethodHead).HandlerFunc(r.headHandler)
 	objects.Methods(http.MethodPut).HandlerFunc(r.putHandler)
 	objects.Methods(http.MethodDelete).HandlerFunc(r.deleteHandler)
 
+	contents := r.router.Path("/contents/{bucket}/{digest}").Subrouter()
+	contents.Methods(http.MethodGet).HandlerFunc(r.getContentHandler)
+	contents.Methods(http.MethodHead).HandlerFunc(r.headContentHandler)
+
+	r.router.Path("/batch/{bucket}").Methods(http.MethodPost).HandlerFunc(r.batchHandler)
+
+	r.router.Path("/uploads/{bucket}/{objectID}").Methods(http.MethodPost).HandlerFunc(r.createUploadHandler)
+
+	uploads := r.router.Path("/uploads/{bucket}/{objectID}/{uploadID}").Subrouter()
+	uploads.Methods(http.MethodGet).HandlerFunc(r.listPartsHandler)
+	uploads.Methods(http.MethodPost).HandlerFunc(r.completeUploadHandler)
+	uploads.Methods(http.MethodDelete).HandlerFunc(r.abortUploadHandler)
+
+	r.router.Path("/uploads/{bucket}/{objectID}/{uploadID}/{partNumber}").Methods(http.MethodPut).HandlerFunc(r.putPartHandler)
+
 	r.router.Path("/stats").Methods(http.MethodGet).HandlerFunc(r.statsHandler)
+	r.router.Path("/stats/{bucket}").Methods(http.MethodGet).HandlerFunc(r.bucketStatsHandler)
+	r.router.Path("/gc").Methods(http.MethodPost).HandlerFunc(r.gcHandler)
+	r.router.Path("/metrics").Methods(http.MethodGet).Handler(r.metrics.Handler())
 	r.router.Path("/health").HandlerFunc(r.healthHandler)
 
 	return r
@@ -48,9 +115,75 @@ func (r *Router) statsHandler(w http.ResponseWriter, req *http.Request) {
 	sendJSON(r.log, w, http.StatusOK, stats)
 }
 
+func (r *Router) bucketStatsHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	stats, err := r.backend.BucketStats(bucket)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not get stats: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	sendJSON(r.log, w, http.StatusOK, stats)
+}
+
+func (r *Router) gcHandler(w http.ResponseWriter, req *http.Request) {
+	removed, err := r.backend.CollectGarbage()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can not collect garbage: %s", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := struct {
+		Removed uint `json:"removed"`
+	}{
+		Removed: removed,
+	}
+	sendJSON(r.log, w, http.StatusOK, response)
+}
+
+func (r *Router) listHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, _ := reqVars(req)
+	query := req.URL.Query()
+	opts := store.ListOptions{
+		Prefix:            query.Get("prefix"),
+		Delimiter:         query.Get("delimiter"),
+		ContinuationToken: query.Get("continuation-token"),
+	}
+
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			http.Error(w, fmt.Sprintf("invalid max-keys: %q", value), http.StatusBadRequest)
+			return
+		}
+		opts.MaxKeys = maxKeys
+	}
+
+	result, err := r.backend.List(bucket, opts)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
+		return
+	case err == store.ErrInvalidToken:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not list objects: %s", err), http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	sendJSON(r.log, w, http.StatusOK, result)
+}
+
 func (r *Router) getHandler(w http.ResponseWriter, req *http.Request) {
 	bucket, objectID := reqVars(req)
-	content, err := r.backend.Get(bucket, objectID)
+	content, meta, err := r.backend.Get(bucket, objectID, getOptions(req))
 	switch {
 	case err == store.ErrNotFound:
 		http.Error(w, fmt.Sprintf("object not found: %s/%s", bucket, objectID), http.StatusNotFound)
@@ -61,40 +194,138 @@ func (r *Router) getHandler(w http.ResponseWriter, req *http.Request) {
 	default:
 	}
 
-	w.Write([]byte(content))
+	defer content.Close()
+
+	// ServeContent handles conditional and range requests using the ETag and modification time. It also sets the
+	// Content-Length, which does not match the size of the object for failed conditions and ranges.
+	setMetadataHeaders(w.Header(), meta)
+	http.ServeContent(w, req, "", meta.Modified, content)
+}
+
+func (r *Router) headHandler(w http.ResponseWriter, req *http.Request) {
+	bucket, objectID := reqVars(req)
+	meta, err := r.backend.Head(bucket, objectID, getOptions(req))
+	switch {
+	case err == store.ErrNotFound:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		r.log.Errorf("Error getting metadata of %s/%s: %s", bucket, objectID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	default:
+	}
+
+	if preconditionFailed(req, meta) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return
+	}
+
+	if notModified(req, meta) {
+		sendNotModified(w, meta)
+		return
+	}
+
+	setMetadataHeaders(w.Header(), meta)
+	w.Header().Set("Content-Length", strconv.FormatInt(meta.Size, 10))
+	w.WriteHeader(http.StatusOK)
 }
 
 func (r *Router) putHandler(w http.ResponseWriter, req *http.Request) {
 	defer req.Body.Close()
 
 	bucket, objectID := reqVars(req)
-	content, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("can not read body: %s", err), http.StatusInternalServerError)
+	if r.explicitBuckets && !r.backend.BucketExists(bucket) {
+		http.Error(w, fmt.Sprintf("bucket not found: %s", bucket), http.StatusNotFound)
 		return
 	}
 
-	id, err := r.backend.Put(bucket, objectID, string(content))
+	opts, err := putOptions(req)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if value := req.Header.Get(headerContentDigest); value != "" {
+		r.putReference(w, req, bucket, objectID, digest.Digest(value), opts)
+		return
+	}
+
+	if value := req.Header.Get(headerCopySource); value != "" {
+		r.copyObject(w, req, value, bucket, objectID, false, opts.Condition)
+		return
+	}
+
+	if value := req.Header.Get(headerMoveSource); value != "" {
+		r.copyObject(w, req, value, bucket, objectID, true, opts.Condition)
+		return
+	}
+
+	body := &errorTrackingReader{reader: req.Body}
+	meta, err := r.backend.Put(bucket, objectID, body, opts)
+	if body.err != nil {
+		http.Error(w, fmt.Sprintf("can not read body: %s", body.err), http.StatusInternalServerError)
+		return
+	}
+
+	switch {
+	case err == store.ErrPreconditionFailed:
+		http.Error(w, fmt.Sprintf("precondition failed: %s/%s", bucket, objectID), http.StatusPreconditionFailed)
+		return
+	case err == store.ErrInsufficientStorage:
+		http.Error(w, fmt.Sprintf("insufficient storage for %s/%s", bucket, objectID), http.StatusInsufficientStorage)
+		return
+	case err != nil:
 		http.Error(w, fmt.Sprintf("can not save object: %s", err), http.StatusInternalServerError)
 		return
+	default:
 	}
 
-	response := struct {
-		ID string `json:"id"`
-	}{
-		ID: id,
+	w.Header().Set("ETag", etag(meta.Digest))
+	sendJSON(r.log, w, http.StatusCreated, newObjectResponse(objectID, meta))
+}
+
+// putReference saves the object with an existing content of the bucket. The request body needs to be empty.
+func (r *Router) putReference(w http.ResponseWriter, req *http.Request, bucket, objectID string, d digest.Digest, opts store.PutOptions) {
+	if hasBody(req) {
+		http.Error(w, fmt.Sprintf("body needs to be empty when %s is set", headerContentDigest), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := r.backend.PutReference(bucket, objectID, d, opts)
+	switch {
+	case err == store.ErrNotFound:
+		http.Error(w, fmt.Sprintf("content not found: %s/%s", bucket, d), http.StatusNotFound)
+		return
+	case err == store.ErrPreconditionFailed:
+		http.Error(w, fmt.Sprintf("precondition failed: %s/%s", bucket, objectID), http.StatusPreconditionFailed)
+		return
+	case err == store.ErrInsufficientStorage:
+		http.Error(w, fmt.Sprintf("insufficient storage for %s/%s", bucket, objectID), http.StatusInsufficientStorage)
+		return
+	case err != nil:
+		http.Error(w, fmt.Sprintf("can not save object: %s", err), http.StatusInternalServerError)
+		return
+	default:
 	}
-	sendJSON(r.log, w, http.StatusCreated, response)
+
+	w.Header().Set("ETag", etag(meta.Digest))
+	sendJSON(r.log, w, http.StatusCreated, newObjectResponse(objectID, meta))
 }
 
 func (r *Router) deleteHandler(w http.ResponseWriter, req *http.Request) {
 	bucket, objectID := reqVars(req)
-	err := r.backend.Delete(bucket, objectID)
+	err := r.backend.Delete(bucket, objectID, store.DeleteOptions{
+		Condition: requestCondition(req),
+		VersionID: req.URL.Query().Get("versionId"),
+	})
 	switch {
 	case err == store.ErrNotFound:
 		http.Error(w, fmt.Sprintf("object not found: %s/%s", bucket, objectID), http.StatusNotFound)
 		return
+	case err == store.ErrPreconditionFailed:
+		http.Error(w, fmt.Sprintf("precondition failed: %s/%s", bucket, objectID), http.StatusPreconditionFailed)
+		return
 	case err != nil:
 		http.Error(w, fmt.Sprintf("can not get object: %s", err), http.StatusInternalServerError)
 		return
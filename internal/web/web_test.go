@@ -2,14 +2,18 @@ package web
 
 import (
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/digest"
 	"github.com/xperimental/bukky/internal/store"
 )
 
@@ -18,13 +22,42 @@ var (
 )
 
 type fakeStore struct {
-	t            *testing.T
-	wantBucket   string
-	wantObjectID string
-	wantContent  string
-	getContent   string
-	putID        string
-	err          error
+	t              *testing.T
+	wantBucket     string
+	wantObjectID   string
+	wantContent    string
+	getContent     string
+	meta           store.Metadata
+	wantPutOpts    store.PutOptions
+	wantDelOpts    store.DeleteOptions
+	uploadID       string
+	part           store.Part
+	parts          []store.Part
+	wantParts      []store.Part
+	putMeta        store.Metadata
+	removed        uint
+	wantListOpts   store.ListOptions
+	listResult     store.ListResult
+	buckets        []string
+	exists         bool
+	wantForce      bool
+	wantGetOpts    store.GetOptions
+	versioning     bool
+	versions       []store.Version
+	wantPrefix     string
+	rules          []store.LifecycleRule
+	expired        uint
+	bucketStats    store.BucketStats
+	stats          store.StoreStats
+	wantBucketOpts store.BucketOptions
+	wantDigest     digest.Digest
+	wantSource     string
+	wantMove       bool
+	wantCopyOpts   store.CopyOptions
+	wantBatchOps   []store.BatchOperation
+	wantAtomic     bool
+	batchResults   []store.BatchResult
+	err            error
 }
 
 func (f fakeStore) checkBucketObject(bucket, objectID string) {
@@ -37,28 +70,254 @@ func (f fakeStore) checkBucketObject(bucket, objectID string) {
 	}
 }
 
-func (f fakeStore) Get(bucket, objectID string) (content string, err error) {
+type nopSeekCloser struct {
+	io.ReadSeeker
+}
+
+func (nopSeekCloser) Close() error {
+	return nil
+}
+
+func (f fakeStore) Get(bucket, objectID string, opts store.GetOptions) (content io.ReadSeekCloser, meta store.Metadata, err error) {
+	f.checkBucketObject(bucket, objectID)
+	if diff := cmp.Diff(opts, f.wantGetOpts); diff != "" {
+		f.t.Errorf("get options differ: -got+want\n%s", diff)
+	}
+	if f.err != nil {
+		return nil, store.Metadata{}, f.err
+	}
+
+	return nopSeekCloser{strings.NewReader(f.getContent)}, f.meta, nil
+}
+
+func (f fakeStore) Head(bucket, objectID string, opts store.GetOptions) (store.Metadata, error) {
 	f.checkBucketObject(bucket, objectID)
-	return f.getContent, f.err
+	if diff := cmp.Diff(opts, f.wantGetOpts); diff != "" {
+		f.t.Errorf("get options differ: -got+want\n%s", diff)
+	}
+	return f.meta, f.err
+}
+
+func (f fakeStore) GetContent(bucket string, d digest.Digest) (io.ReadSeekCloser, int64, error) {
+	if bucket != f.wantBucket {
+		f.t.Errorf("got bucket %q, want %q", bucket, f.wantBucket)
+	}
+	if d != f.wantDigest {
+		f.t.Errorf("got digest %q, want %q", d, f.wantDigest)
+	}
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+
+	return nopSeekCloser{strings.NewReader(f.getContent)}, int64(len(f.getContent)), nil
 }
 
-func (f fakeStore) Put(bucket, objectID, content string) (id string, err error) {
+func (f fakeStore) Put(bucket, objectID string, reader io.Reader, opts store.PutOptions) (store.Metadata, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return store.Metadata{}, err
+	}
+
 	f.checkBucketObject(bucket, objectID)
-	if content != f.wantContent {
+	if diff := cmp.Diff(opts, f.wantPutOpts); diff != "" {
+		f.t.Errorf("put options differ: -got+want\n%s", diff)
+	}
+	if content := string(data); content != f.wantContent {
 		f.t.Errorf("got content %q, want %q", content, f.wantContent)
 	}
-	return f.putID, f.err
+	return f.putMeta, f.err
+}
+
+func (f fakeStore) PutReference(bucket, objectID string, d digest.Digest, opts store.PutOptions) (store.Metadata, error) {
+	f.checkBucketObject(bucket, objectID)
+	if d != f.wantDigest {
+		f.t.Errorf("got digest %q, want %q", d, f.wantDigest)
+	}
+	if diff := cmp.Diff(opts, f.wantPutOpts); diff != "" {
+		f.t.Errorf("put options differ: -got+want\n%s", diff)
+	}
+	return f.putMeta, f.err
+}
+
+func (f fakeStore) Copy(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	return f.copyObject(false, srcBucket, srcObjectID, dstBucket, dstObjectID, opts)
+}
+
+func (f fakeStore) Move(srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	return f.copyObject(true, srcBucket, srcObjectID, dstBucket, dstObjectID, opts)
+}
+
+func (f fakeStore) copyObject(move bool, srcBucket, srcObjectID, dstBucket, dstObjectID string, opts store.CopyOptions) (store.Metadata, error) {
+	f.checkBucketObject(dstBucket, dstObjectID)
+	if source := srcBucket + "/" + srcObjectID; source != f.wantSource {
+		f.t.Errorf("got source %q, want %q", source, f.wantSource)
+	}
+	if move != f.wantMove {
+		f.t.Errorf("got move %v, want %v", move, f.wantMove)
+	}
+	if diff := cmp.Diff(opts, f.wantCopyOpts); diff != "" {
+		f.t.Errorf("copy options differ: -got+want\n%s", diff)
+	}
+	return f.putMeta, f.err
 }
 
-func (f fakeStore) Delete(bucket, objectID string) error {
+func (f fakeStore) Delete(bucket, objectID string, opts store.DeleteOptions) error {
 	f.checkBucketObject(bucket, objectID)
+	if diff := cmp.Diff(opts, f.wantDelOpts); diff != "" {
+		f.t.Errorf("delete options differ: -got+want\n%s", diff)
+	}
 	return f.err
 }
 
+func (f fakeStore) Batch(bucket string, ops []store.BatchOperation, atomic bool) ([]store.BatchResult, error) {
+	if bucket != f.wantBucket {
+		f.t.Errorf("got bucket %q, want %q", bucket, f.wantBucket)
+	}
+	if diff := cmp.Diff(ops, f.wantBatchOps); diff != "" {
+		f.t.Errorf("batch operations differ: -got+want\n%s", diff)
+	}
+	if atomic != f.wantAtomic {
+		f.t.Errorf("got atomic %v, want %v", atomic, f.wantAtomic)
+	}
+	return f.batchResults, f.err
+}
+
 func (f fakeStore) Stats() store.StoreStats {
-	return store.StoreStats{
-		Buckets: map[string]store.BucketStats{},
+	if f.stats.Buckets == nil {
+		f.stats.Buckets = map[string]store.BucketStats{}
+	}
+	return f.stats
+}
+
+func (f fakeStore) BucketStats(bucket string) (store.BucketStats, error) {
+	f.checkBucketObject(bucket, "")
+	return f.bucketStats, f.err
+}
+
+func (f fakeStore) List(bucket string, opts store.ListOptions) (store.ListResult, error) {
+	f.checkBucketObject(bucket, "")
+	if diff := cmp.Diff(opts, f.wantListOpts); diff != "" {
+		f.t.Errorf("list options differ: -got+want\n%s", diff)
+	}
+	return f.listResult, f.err
+}
+
+func (f fakeStore) CollectGarbage() (uint, error) {
+	return f.removed, f.err
+}
+
+func (f fakeStore) CreateBucket(bucket string, opts store.BucketOptions) error {
+	f.checkBucketObject(bucket, "")
+	if diff := cmp.Diff(opts, f.wantBucketOpts); diff != "" {
+		f.t.Errorf("bucket options differ: -got+want\n%s", diff)
+	}
+	return f.err
+}
+
+func (f fakeStore) ListBuckets() ([]string, error) {
+	return f.buckets, f.err
+}
+
+func (f fakeStore) DeleteBucket(bucket string, force bool) error {
+	f.checkBucketObject(bucket, "")
+	if force != f.wantForce {
+		f.t.Errorf("got force %v, want %v", force, f.wantForce)
+	}
+	return f.err
+}
+
+func (f fakeStore) BucketExists(bucket string) bool {
+	if bucket != f.wantBucket {
+		f.t.Errorf("got bucket %q, want %q", bucket, f.wantBucket)
+	}
+	return f.exists
+}
+
+func (f fakeStore) SetVersioning(bucket string, enabled bool) error {
+	f.checkBucketObject(bucket, "")
+	if enabled != f.versioning {
+		f.t.Errorf("got versioning %v, want %v", enabled, f.versioning)
+	}
+	return f.err
+}
+
+func (f fakeStore) Versioning(bucket string) (bool, error) {
+	f.checkBucketObject(bucket, "")
+	return f.versioning, f.err
+}
+
+func (f fakeStore) SetLifecycle(bucket string, rules []store.LifecycleRule) error {
+	f.checkBucketObject(bucket, "")
+	if diff := cmp.Diff(rules, f.rules); diff != "" {
+		f.t.Errorf("rules differ: -got+want\n%s", diff)
+	}
+	return f.err
+}
+
+func (f fakeStore) Lifecycle(bucket string) ([]store.LifecycleRule, error) {
+	f.checkBucketObject(bucket, "")
+	return f.rules, f.err
+}
+
+func (f fakeStore) ExpireObjects() (uint, error) {
+	return f.expired, f.err
+}
+
+func (f fakeStore) ListVersions(bucket, prefix string) ([]store.Version, error) {
+	f.checkBucketObject(bucket, "")
+	if prefix != f.wantPrefix {
+		f.t.Errorf("got prefix %q, want %q", prefix, f.wantPrefix)
+	}
+	return f.versions, f.err
+}
+
+func (f fakeStore) checkUpload(bucket, objectID, uploadID string) {
+	f.checkBucketObject(bucket, objectID)
+	if uploadID != f.uploadID {
+		f.t.Errorf("got upload ID %q, want %q", uploadID, f.uploadID)
+	}
+}
+
+func (f fakeStore) CreateUpload(bucket, objectID string, opts store.PutOptions) (string, error) {
+	f.checkBucketObject(bucket, objectID)
+	if diff := cmp.Diff(opts, f.wantPutOpts); diff != "" {
+		f.t.Errorf("put options differ: -got+want\n%s", diff)
+	}
+	return f.uploadID, f.err
+}
+
+func (f fakeStore) PutPart(bucket, objectID, uploadID string, number int, reader io.Reader) (store.Part, error) {
+	data, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return store.Part{}, err
+	}
+
+	f.checkUpload(bucket, objectID, uploadID)
+	if number != f.part.Number {
+		f.t.Errorf("got part number %d, want %d", number, f.part.Number)
 	}
+	if content := string(data); content != f.wantContent {
+		f.t.Errorf("got content %q, want %q", content, f.wantContent)
+	}
+	return f.part, f.err
+}
+
+func (f fakeStore) ListParts(bucket, objectID, uploadID string) ([]store.Part, error) {
+	f.checkUpload(bucket, objectID, uploadID)
+	return f.parts, f.err
+}
+
+func (f fakeStore) CompleteUpload(bucket, objectID, uploadID string, parts []store.Part) (store.Metadata, error) {
+	f.checkUpload(bucket, objectID, uploadID)
+	if diff := cmp.Diff(parts, f.wantParts); diff != "" {
+		f.t.Errorf("parts differ: -got+want\n%s", diff)
+	}
+	return f.putMeta, f.err
+}
+
+func (f fakeStore) AbortUpload(bucket, objectID, uploadID string) error {
+	f.checkUpload(bucket, objectID, uploadID)
+	return f.err
 }
 
 type errorReader struct{}
@@ -67,12 +326,35 @@ func (e errorReader) Read(p []byte) (n int, err error) {
 	return 0, errors.New("read error")
 }
 
+var testMetadata = store.Metadata{
+	ContentType: "text/plain",
+	Size:        12,
+	Created:     time.Date(2021, 6, 1, 11, 0, 0, 0, time.UTC),
+	Modified:    time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC),
+	Digest:      "test-digest",
+	User: map[string]string{
+		"Author": "test-author",
+	},
+}
+
+var testMetadataHeader = http.Header{
+	"Content-Type":        {"text/plain"},
+	"Content-Length":      {"12"},
+	"Last-Modified":       {"Tue, 01 Jun 2021 12:00:00 GMT"},
+	"X-Bukky-Created":     {"Tue, 01 Jun 2021 11:00:00 GMT"},
+	"X-Bukky-Digest":      {"test-digest"},
+	"Etag":                {`"test-digest"`},
+	"Accept-Ranges":       {"bytes"},
+	"X-Bukky-Meta-Author": {"test-author"},
+}
+
 func TestGet(t *testing.T) {
 
 	tt := []struct {
 		desc       string
 		store      store.Store
 		wantStatus int
+		wantHeader http.Header
 		wantBody   string
 	}{
 		{
@@ -82,9 +364,11 @@ func TestGet(t *testing.T) {
 				wantBucket:   "test-bucket",
 				wantObjectID: "test-object",
 				getContent:   "test-content",
+				meta:         testMetadata,
 				err:          nil,
 			},
 			wantStatus: http.StatusOK,
+			wantHeader: testMetadataHeader,
 			wantBody:   "test-content",
 		},
 		{
@@ -128,6 +412,12 @@ func TestGet(t *testing.T) {
 				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
 			}
 
+			for name := range tc.wantHeader {
+				if diff := cmp.Diff(rec.Header().Values(name), tc.wantHeader.Values(name)); diff != "" {
+					t.Errorf("header %s differs: -got+want\n%s", name, diff)
+				}
+			}
+
 			body := rec.Body.String()
 			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
 				t.Errorf("body differs: -got+want\n%s", diff)
@@ -136,12 +426,12 @@ func TestGet(t *testing.T) {
 	}
 }
 
-func TestDelete(t *testing.T) {
+func TestHead(t *testing.T) {
 	tt := []struct {
 		desc       string
 		store      store.Store
 		wantStatus int
-		wantBody   string
+		wantHeader http.Header
 	}{
 		{
 			desc: "success",
@@ -149,10 +439,10 @@ func TestDelete(t *testing.T) {
 				t:            t,
 				wantBucket:   "test-bucket",
 				wantObjectID: "test-object",
-				err:          nil,
+				meta:         testMetadata,
 			},
-			wantStatus: http.StatusNoContent,
-			wantBody:   "",
+			wantStatus: http.StatusOK,
+			wantHeader: testMetadataHeader,
 		},
 		{
 			desc: "not found",
@@ -163,7 +453,7 @@ func TestDelete(t *testing.T) {
 				err:          store.ErrNotFound,
 			},
 			wantStatus: http.StatusNotFound,
-			wantBody:   "object not found: test-bucket/test-object\n",
+			wantHeader: http.Header{},
 		},
 		{
 			desc: "backend error",
@@ -174,7 +464,7 @@ func TestDelete(t *testing.T) {
 				err:          errors.New("test-error"),
 			},
 			wantStatus: http.StatusInternalServerError,
-			wantBody:   "can not get object: test-error\n",
+			wantHeader: http.Header{},
 		},
 	}
 
@@ -185,7 +475,7 @@ func TestDelete(t *testing.T) {
 
 			r := NewRouter(log, tc.store)
 			rec := httptest.NewRecorder()
-			req := httptest.NewRequest(http.MethodDelete, "/objects/test-bucket/test-object", nil)
+			req := httptest.NewRequest(http.MethodHead, "/objects/test-bucket/test-object", nil)
 
 			r.Handler().ServeHTTP(rec, req)
 
@@ -193,58 +483,231 @@ func TestDelete(t *testing.T) {
 				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
 			}
 
-			body := rec.Body.String()
-			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
-				t.Errorf("body differs: -got+want\n%s", diff)
+			if diff := cmp.Diff(rec.Header(), tc.wantHeader); diff != "" {
+				t.Errorf("header differs: -got+want\n%s", diff)
+			}
+
+			if rec.Body.Len() != 0 {
+				t.Errorf("got body %q, want none", rec.Body.String())
 			}
 		})
 	}
 }
 
-func TestPut(t *testing.T) {
+func TestNotModified(t *testing.T) {
 	tt := []struct {
 		desc       string
-		store      store.Store
-		body       io.Reader
+		method     string
+		header     http.Header
 		wantStatus int
-		wantBody   string
 	}{
 		{
-			desc: "success",
-			store: &fakeStore{
-				t:            t,
-				wantBucket:   "test-bucket",
-				wantObjectID: "test-object",
-				wantContent:  "test-content",
-				putID:        "test-object-id",
-				err:          nil,
+			desc:       "no condition",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:   "matching etag",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-None-Match": {`"other-digest", "test-digest"`},
 			},
-			body:       strings.NewReader("test-content"),
-			wantStatus: http.StatusCreated,
-			wantBody: `{"id":"test-object-id"}
-`,
+			wantStatus: http.StatusNotModified,
 		},
 		{
-			desc: "read error",
-			store: &fakeStore{
-				t: t,
+			desc:   "matching weak etag",
+			method: http.MethodHead,
+			header: http.Header{
+				"If-None-Match": {`W/"test-digest"`},
 			},
-			body:       &errorReader{},
-			wantStatus: http.StatusInternalServerError,
-			wantBody:   "can not read body: read error\n",
+			wantStatus: http.StatusNotModified,
 		},
 		{
-			desc: "backend error",
-			store: &fakeStore{
+			desc:   "different etag",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-None-Match": {`"other-digest"`},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:   "etag takes precedence",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-None-Match":     {`"other-digest"`},
+				"If-Modified-Since": {"Tue, 01 Jun 2021 12:00:00 GMT"},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:   "not modified since",
+			method: http.MethodHead,
+			header: http.Header{
+				"If-Modified-Since": {"Tue, 01 Jun 2021 12:00:00 GMT"},
+			},
+			wantStatus: http.StatusNotModified,
+		},
+		{
+			desc:   "modified since",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-Modified-Since": {"Tue, 01 Jun 2021 11:59:59 GMT"},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:   "invalid date",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-Modified-Since": {"yesterday"},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:   "if-match",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-Match": {`"test-digest"`},
+			},
+			wantStatus: http.StatusOK,
+		},
+		{
+			desc:   "if-match different etag",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-Match": {`"other-digest"`},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			desc:   "head if-match different etag",
+			method: http.MethodHead,
+			header: http.Header{
+				"If-Match": {`"other-digest"`},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			desc:   "modified after if-unmodified-since",
+			method: http.MethodGet,
+			header: http.Header{
+				"If-Unmodified-Since": {"Tue, 01 Jun 2021 11:59:59 GMT"},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			desc:   "head modified after if-unmodified-since",
+			method: http.MethodHead,
+			header: http.Header{
+				"If-Unmodified-Since": {"Tue, 01 Jun 2021 11:59:59 GMT"},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+		},
+		{
+			desc:   "unmodified since",
+			method: http.MethodHead,
+			header: http.Header{
+				"If-Unmodified-Since": {"Tue, 01 Jun 2021 12:00:00 GMT"},
+			},
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, &fakeStore{
 				t:            t,
 				wantBucket:   "test-bucket",
 				wantObjectID: "test-object",
-				wantContent:  "test-content",
-				err:          errors.New("backend error"),
-			},
-			body:       strings.NewReader("test-content"),
-			wantStatus: http.StatusInternalServerError,
-			wantBody:   "can not save object: backend error\n",
+				getContent:   "test-content",
+				meta:         testMetadata,
+			})
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "/objects/test-bucket/test-object", nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			if etag := rec.Header().Get("ETag"); etag != `"test-digest"` && rec.Code != http.StatusPreconditionFailed {
+				t.Errorf("got ETag %q, want %q", etag, `"test-digest"`)
+			}
+
+			if rec.Code != http.StatusOK && rec.Body.Len() != 0 {
+				t.Errorf("got body %q, want none", rec.Body.String())
+			}
+
+			// The length of the object is only sent with its content or for HEAD requests.
+			wantLength := ""
+			if rec.Code == http.StatusOK {
+				wantLength = "12"
+			}
+			if length := rec.Header().Get("Content-Length"); length != wantLength {
+				t.Errorf("got Content-Length %q, want %q", length, wantLength)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	tt := []struct {
+		desc             string
+		rangeHeader      string
+		ifRange          string
+		wantStatus       int
+		wantContentRange string
+		wantContentType  string
+		wantBody         string
+	}{
+		{
+			desc:            "no range",
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/plain",
+			wantBody:        "test-content",
+		},
+		{
+			desc:             "single range",
+			rangeHeader:      "bytes=5-8",
+			wantStatus:       http.StatusPartialContent,
+			wantContentRange: "bytes 5-8/12",
+			wantContentType:  "text/plain",
+			wantBody:         "cont",
+		},
+		{
+			desc:             "suffix range",
+			rangeHeader:      "bytes=-3",
+			wantStatus:       http.StatusPartialContent,
+			wantContentRange: "bytes 9-11/12",
+			wantContentType:  "text/plain",
+			wantBody:         "ent",
+		},
+		{
+			desc:            "multiple ranges",
+			rangeHeader:     "bytes=0-3,5-8",
+			wantStatus:      http.StatusPartialContent,
+			wantContentType: "multipart/byteranges",
+		},
+		{
+			desc:             "unsatisfiable",
+			rangeHeader:      "bytes=20-30",
+			wantStatus:       http.StatusRequestedRangeNotSatisfiable,
+			wantContentRange: "bytes */12",
+		},
+		{
+			desc:            "outdated if-range",
+			rangeHeader:     "bytes=5-8",
+			ifRange:         `"other-digest"`,
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/plain",
+			wantBody:        "test-content",
 		},
 	}
 
@@ -253,9 +716,1395 @@ func TestPut(t *testing.T) {
 		t.Run(tc.desc, func(t *testing.T) {
 			t.Parallel()
 
-			r := NewRouter(log, tc.store)
+			r := NewRouter(log, &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				getContent:   "test-content",
+				meta:         testMetadata,
+			})
 			rec := httptest.NewRecorder()
-			req := httptest.NewRequest(http.MethodPut, "/objects/test-bucket/test-object", tc.body)
+			req := httptest.NewRequest(http.MethodGet, "/objects/test-bucket/test-object", nil)
+			if tc.rangeHeader != "" {
+				req.Header.Set("Range", tc.rangeHeader)
+			}
+			if tc.ifRange != "" {
+				req.Header.Set("If-Range", tc.ifRange)
+			}
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			if contentRange := rec.Header().Get("Content-Range"); contentRange != tc.wantContentRange {
+				t.Errorf("got Content-Range %q, want %q", contentRange, tc.wantContentRange)
+			}
+
+			if contentType := rec.Header().Get("Content-Type"); !strings.HasPrefix(contentType, tc.wantContentType) {
+				t.Errorf("got Content-Type %q, want %q", contentType, tc.wantContentType)
+			}
+
+			if tc.wantBody == "" {
+				return
+			}
+
+			if diff := cmp.Diff(rec.Body.String(), tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDelete(t *testing.T) {
+	tt := []struct {
+		desc       string
+		store      store.Store
+		header     http.Header
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc: "success",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				err:          nil,
+			},
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		},
+		{
+			desc: "not found",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				err:          store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "object not found: test-bucket/test-object\n",
+		},
+		{
+			desc: "precondition failed",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantDelOpts: store.DeleteOptions{
+					Condition: store.Condition{
+						IfMatch: []digest.Digest{"test-digest", "W/\"weak-digest\""},
+					},
+				},
+				err: store.ErrPreconditionFailed,
+			},
+			header: http.Header{
+				"If-Match": {`"test-digest", W/"weak-digest"`},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   "precondition failed: test-bucket/test-object\n",
+		},
+		{
+			desc: "backend error",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				err:          errors.New("test-error"),
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "can not get object: test-error\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodDelete, "/objects/test-bucket/test-object", nil)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPut(t *testing.T) {
+	tt := []struct {
+		desc       string
+		store      store.Store
+		header     http.Header
+		body       io.Reader
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc: "success",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				putMeta:      store.Metadata{Digest: "test-digest"},
+				err:          nil,
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "versioned",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				putMeta:      store.Metadata{Digest: "test-digest", VersionID: "test-version"},
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-version","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "metadata",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				wantPutOpts: store.PutOptions{
+					ContentType: "text/plain",
+					UserMetadata: map[string]string{
+						"Author": "test-author",
+						"Tags":   "one,two",
+					},
+				},
+				putMeta: store.Metadata{Digest: "test-digest"},
+			},
+			header: http.Header{
+				"Content-Type":        {"text/plain"},
+				"X-Bukky-Meta-Author": {"test-author"},
+				"X-Bukky-Meta-Tags":   {"one", "two"},
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "create only",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				wantPutOpts: store.PutOptions{
+					Condition: store.Condition{
+						IfNoneMatch: []digest.Digest{store.MatchAny},
+					},
+				},
+				err: store.ErrPreconditionFailed,
+			},
+			header: http.Header{
+				"If-None-Match": {"*"},
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   "precondition failed: test-bucket/test-object\n",
+		},
+		{
+			desc: "insufficient storage",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				err:          store.ErrInsufficientStorage,
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusInsufficientStorage,
+			wantBody:   "insufficient storage for test-bucket/test-object\n",
+		},
+		{
+			desc: "ttl",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				wantPutOpts: store.PutOptions{
+					TTL: time.Hour,
+				},
+				putMeta: store.Metadata{Digest: "test-digest"},
+			},
+			header: http.Header{
+				"X-Bukky-Ttl": {"3600"},
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc:  "invalid ttl",
+			store: &fakeStore{t: t},
+			header: http.Header{
+				"X-Bukky-Ttl": {"1h"},
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid TTL: \"1h\"\n",
+		},
+		{
+			desc: "read error",
+			store: &fakeStore{
+				t: t,
+			},
+			body:       &errorReader{},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "can not read body: read error\n",
+		},
+		{
+			desc: "backend error",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				err:          errors.New("backend error"),
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "can not save object: backend error\n",
+		},
+		{
+			desc: "copy",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantSource:   "other-bucket/other-object",
+				putMeta:      store.Metadata{Digest: "test-digest"},
+			},
+			header: http.Header{
+				"X-Bukky-Copy-Source": {"other-bucket/other-object"},
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "copy version",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantSource:   "other-bucket/other object",
+				wantCopyOpts: store.CopyOptions{
+					VersionID: "test-version",
+					Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+				},
+				putMeta: store.Metadata{Digest: "test-digest"},
+			},
+			header: http.Header{
+				"If-None-Match":       {"*"},
+				"X-Bukky-Copy-Source": {"/other-bucket/other%20object?versionId=test-version"},
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "move",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantSource:   "test-bucket/other-object",
+				wantMove:     true,
+				putMeta:      store.Metadata{Digest: "test-digest"},
+			},
+			header: http.Header{
+				"X-Bukky-Move-Source": {"test-bucket/other-object"},
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "copy source not found",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantSource:   "other-bucket/other-object",
+				err:          store.ErrNotFound,
+			},
+			header: http.Header{
+				"X-Bukky-Copy-Source": {"other-bucket/other-object"},
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "object not found: other-bucket/other-object\n",
+		},
+		{
+			desc: "invalid copy source",
+			store: &fakeStore{
+				t: t,
+			},
+			header: http.Header{
+				"X-Bukky-Copy-Source": {"other-bucket"},
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid copy source: \"other-bucket\"\n",
+		},
+		{
+			desc: "copy with body",
+			store: &fakeStore{
+				t: t,
+			},
+			header: http.Header{
+				"X-Bukky-Copy-Source": {"other-bucket/other-object"},
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "body needs to be empty when copying an object\n",
+		},
+		{
+			desc: "reference",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantDigest:   "test-digest",
+				wantPutOpts: store.PutOptions{
+					ContentType: "text/plain",
+				},
+				putMeta: store.Metadata{Digest: "test-digest"},
+			},
+			header: http.Header{
+				"Content-Type":           {"text/plain"},
+				"X-Bukky-Content-Digest": {"test-digest"},
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "reference with body",
+			store: &fakeStore{
+				t: t,
+			},
+			header: http.Header{
+				"X-Bukky-Content-Digest": {"test-digest"},
+			},
+			body:       strings.NewReader("test-content"),
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "body needs to be empty when X-Bukky-Content-Digest is set\n",
+		},
+		{
+			desc: "reference not found",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantDigest:   "test-digest",
+				err:          store.ErrNotFound,
+			},
+			header: http.Header{
+				"X-Bukky-Content-Digest": {"test-digest"},
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "content not found: test-bucket/test-digest\n",
+		},
+		{
+			desc: "reference precondition failed",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantDigest:   "test-digest",
+				wantPutOpts: store.PutOptions{
+					Condition: store.Condition{IfNoneMatch: []digest.Digest{store.MatchAny}},
+				},
+				err: store.ErrPreconditionFailed,
+			},
+			header: http.Header{
+				"If-None-Match":          {"*"},
+				"X-Bukky-Content-Digest": {"test-digest"},
+			},
+			wantStatus: http.StatusPreconditionFailed,
+			wantBody:   "precondition failed: test-bucket/test-object\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/objects/test-bucket/test-object", tc.body)
+			for name, values := range tc.header {
+				req.Header[name] = values
+			}
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestList(t *testing.T) {
+	tt := []struct {
+		desc       string
+		query      string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc:  "success",
+			query: "",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				listResult: store.ListResult{
+					Objects: []string{"test-object"},
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"objects":["test-object"],"truncated":false}
+`,
+		},
+		{
+			desc:  "options",
+			query: "?prefix=dir%2F&delimiter=%2F&max-keys=10&continuation-token=token",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantListOpts: store.ListOptions{
+					Prefix:            "dir/",
+					Delimiter:         "/",
+					MaxKeys:           10,
+					ContinuationToken: "token",
+				},
+				listResult: store.ListResult{
+					Objects:               []string{"dir/test-object"},
+					CommonPrefixes:        []string{"dir/sub/"},
+					Truncated:             true,
+					NextContinuationToken: "next",
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"objects":["dir/test-object"],"commonPrefixes":["dir/sub/"],"truncated":true,"nextContinuationToken":"next"}
+`,
+		},
+		{
+			desc:       "invalid max-keys",
+			query:      "?max-keys=many",
+			store:      &fakeStore{t: t},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid max-keys: \"many\"\n",
+		},
+		{
+			desc:  "invalid token",
+			query: "?continuation-token=invalid",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantListOpts: store.ListOptions{
+					ContinuationToken: "invalid",
+				},
+				err: store.ErrInvalidToken,
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid continuation token\n",
+		},
+		{
+			desc:  "bucket not found",
+			query: "",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "bucket not found: test-bucket\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/objects/test-bucket"+tc.query, nil)
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBuckets(t *testing.T) {
+	tt := []struct {
+		desc       string
+		method     string
+		path       string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc:   "list",
+			method: http.MethodGet,
+			path:   "/buckets",
+			store: &fakeStore{
+				t:       t,
+				buckets: []string{"bucket-a", "bucket-b"},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"buckets":["bucket-a","bucket-b"]}
+`,
+		},
+		{
+			desc:   "list error",
+			method: http.MethodGet,
+			path:   "/buckets",
+			store: &fakeStore{
+				t:   t,
+				err: errors.New("test-error"),
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "can not list buckets: test-error\n",
+		},
+		{
+			desc:   "create",
+			method: http.MethodPut,
+			path:   "/buckets/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+			},
+			wantStatus: http.StatusCreated,
+			wantBody:   "",
+		},
+		{
+			desc:   "create with digest",
+			method: http.MethodPut,
+			path:   "/buckets/test-bucket?digest=blake3",
+			store: &fakeStore{
+				t:              t,
+				wantBucket:     "test-bucket",
+				wantBucketOpts: store.BucketOptions{Digest: "blake3"},
+			},
+			wantStatus: http.StatusCreated,
+			wantBody:   "",
+		},
+		{
+			desc:   "create with unknown digest",
+			method: http.MethodPut,
+			path:   "/buckets/test-bucket?digest=md5",
+			store: &fakeStore{
+				t:              t,
+				wantBucket:     "test-bucket",
+				wantBucketOpts: store.BucketOptions{Digest: "md5"},
+				err:            fmt.Errorf("%w: %q", digest.ErrUnknownAlgorithm, "md5"),
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "unknown digest algorithm: \"md5\"\n",
+		},
+		{
+			desc:   "create existing",
+			method: http.MethodPut,
+			path:   "/buckets/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrBucketExists,
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   "bucket already exists: test-bucket\n",
+		},
+		{
+			desc:   "head existing",
+			method: http.MethodHead,
+			path:   "/buckets/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				exists:     true,
+			},
+			wantStatus: http.StatusOK,
+			wantBody:   "",
+		},
+		{
+			desc:   "head missing",
+			method: http.MethodHead,
+			path:   "/buckets/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "",
+		},
+		{
+			desc:   "delete",
+			method: http.MethodDelete,
+			path:   "/buckets/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+			},
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		},
+		{
+			desc:   "delete forced",
+			method: http.MethodDelete,
+			path:   "/buckets/test-bucket?force=true",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantForce:  true,
+			},
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		},
+		{
+			desc:       "delete invalid force",
+			method:     http.MethodDelete,
+			path:       "/buckets/test-bucket?force=maybe",
+			store:      &fakeStore{t: t},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid force: \"maybe\"\n",
+		},
+		{
+			desc:   "delete not empty",
+			method: http.MethodDelete,
+			path:   "/buckets/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrBucketNotEmpty,
+			},
+			wantStatus: http.StatusConflict,
+			wantBody:   "bucket not empty: test-bucket\n",
+		},
+		{
+			desc:   "delete not found",
+			method: http.MethodDelete,
+			path:   "/buckets/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "bucket not found: test-bucket\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, tc.path, nil)
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestVersions(t *testing.T) {
+	versionMeta := testMetadata
+	versionMeta.VersionID = "test-version"
+
+	tt := []struct {
+		desc       string
+		method     string
+		path       string
+		body       string
+		store      store.Store
+		wantStatus int
+		wantHeader http.Header
+		wantBody   string
+	}{
+		{
+			desc:   "get versioning",
+			method: http.MethodGet,
+			path:   "/buckets/test-bucket/versioning",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				versioning: true,
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"enabled":true}
+`,
+		},
+		{
+			desc:   "get versioning not found",
+			method: http.MethodGet,
+			path:   "/buckets/test-bucket/versioning",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "bucket not found: test-bucket\n",
+		},
+		{
+			desc:   "enable versioning",
+			method: http.MethodPut,
+			path:   "/buckets/test-bucket/versioning",
+			body:   `{"enabled":true}`,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				versioning: true,
+			},
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		},
+		{
+			desc:       "invalid versioning",
+			method:     http.MethodPut,
+			path:       "/buckets/test-bucket/versioning",
+			body:       "enabled",
+			store:      &fakeStore{t: t},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "can not parse body: invalid character 'e' looking for beginning of value\n",
+		},
+		{
+			desc:   "versioning not supported",
+			method: http.MethodPut,
+			path:   "/buckets/test-bucket/versioning",
+			body:   `{"enabled":false}`,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrNotSupported,
+			},
+			wantStatus: http.StatusNotImplemented,
+			wantBody:   "versioning is not supported by the backend\n",
+		},
+		{
+			desc:   "list versions",
+			method: http.MethodGet,
+			path:   "/versions/test-bucket?prefix=test-",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				wantPrefix: "test-",
+				versions: []store.Version{
+					{
+						ObjectID:     "test-object",
+						VersionID:    "version-2",
+						IsLatest:     true,
+						DeleteMarker: true,
+						Modified:     testMetadata.Modified,
+					},
+					{
+						ObjectID:  "test-object",
+						VersionID: "version-1",
+						Modified:  testMetadata.Modified,
+						Size:      12,
+						Digest:    "test-digest",
+					},
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"versions":[{"objectId":"test-object","versionId":"version-2","isLatest":true,"deleteMarker":true,"modified":"2021-06-01T12:00:00Z","size":0},{"objectId":"test-object","versionId":"version-1","isLatest":false,"modified":"2021-06-01T12:00:00Z","size":12,"digest":"test-digest"}]}
+`,
+		},
+		{
+			desc:   "list versions empty",
+			method: http.MethodGet,
+			path:   "/versions/test-bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"versions":[]}
+`,
+		},
+		{
+			desc:   "get version",
+			method: http.MethodGet,
+			path:   "/objects/test-bucket/test-object?versionId=test-version",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantGetOpts:  store.GetOptions{VersionID: "test-version"},
+				getContent:   "test-content",
+				meta:         versionMeta,
+			},
+			wantStatus: http.StatusOK,
+			wantHeader: http.Header{
+				"X-Bukky-Version-Id": []string{"test-version"},
+			},
+			wantBody: "test-content",
+		},
+		{
+			desc:   "delete version",
+			method: http.MethodDelete,
+			path:   "/objects/test-bucket/test-object?versionId=test-version",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantDelOpts:  store.DeleteOptions{VersionID: "test-version"},
+			},
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			for name := range tc.wantHeader {
+				if diff := cmp.Diff(rec.Header().Values(name), tc.wantHeader.Values(name)); diff != "" {
+					t.Errorf("header %s differs: -got+want\n%s", name, diff)
+				}
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	tt := []struct {
+		desc       string
+		method     string
+		body       string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc:   "get",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				rules: []store.LifecycleRule{
+					{Prefix: "tmp/", Days: 1},
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"rules":[{"prefix":"tmp/","days":1}]}
+`,
+		},
+		{
+			desc:   "get without rules",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"rules":[]}
+`,
+		},
+		{
+			desc:   "get not found",
+			method: http.MethodGet,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "bucket not found: test-bucket\n",
+		},
+		{
+			desc:   "set",
+			method: http.MethodPut,
+			body:   `{"rules":[{"days":7},{"prefix":"tmp/","days":1}]}`,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				rules: []store.LifecycleRule{
+					{Days: 7},
+					{Prefix: "tmp/", Days: 1},
+				},
+			},
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		},
+		{
+			desc:   "set invalid rule",
+			method: http.MethodPut,
+			body:   `{"rules":[{"days":0}]}`,
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				rules: []store.LifecycleRule{
+					{Days: 0},
+				},
+				err: store.ErrInvalidRule,
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid lifecycle rule: days need to be at least 1\n",
+		},
+		{
+			desc:       "set invalid body",
+			method:     http.MethodPut,
+			body:       "rules",
+			store:      &fakeStore{t: t},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "can not parse body: invalid character 'r' looking for beginning of value\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, "/buckets/test-bucket/lifecycle", strings.NewReader(tc.body))
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExplicitBuckets(t *testing.T) {
+	tt := []struct {
+		desc       string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc: "existing bucket",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				putMeta:      store.Metadata{Digest: "test-digest"},
+				exists:       true,
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc: "missing bucket",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "bucket not found: test-bucket\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store, WithExplicitBuckets())
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/objects/test-bucket/test-object", strings.NewReader("test-content"))
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUploads(t *testing.T) {
+	tt := []struct {
+		desc       string
+		method     string
+		path       string
+		body       string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc:   "create",
+			method: http.MethodPost,
+			path:   "/uploads/test-bucket/test-object",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"uploadId":"test-upload"}
+`,
+		},
+		{
+			desc:   "put part",
+			method: http.MethodPut,
+			path:   "/uploads/test-bucket/test-object/test-upload/2",
+			body:   "test-content",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				wantContent:  "test-content",
+				uploadID:     "test-upload",
+				part: store.Part{
+					Number: 2,
+					Size:   12,
+					Digest: "test-digest",
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"partNumber":2,"size":12,"digest":"test-digest"}
+`,
+		},
+		{
+			desc:       "put part invalid number",
+			method:     http.MethodPut,
+			path:       "/uploads/test-bucket/test-object/test-upload/first",
+			store:      &fakeStore{t: t},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid part number: \"first\"\n",
+		},
+		{
+			desc:   "put part out of range",
+			method: http.MethodPut,
+			path:   "/uploads/test-bucket/test-object/test-upload/0",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+				err:          store.ErrInvalidPart,
+			},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "invalid part\n",
+		},
+		{
+			desc:   "list parts",
+			method: http.MethodGet,
+			path:   "/uploads/test-bucket/test-object/test-upload",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+				parts: []store.Part{
+					{Number: 1, Size: 5, Digest: "digest-1"},
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"parts":[{"partNumber":1,"size":5,"digest":"digest-1"}]}
+`,
+		},
+		{
+			desc:   "list parts upload not found",
+			method: http.MethodGet,
+			path:   "/uploads/test-bucket/test-object/test-upload",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+				err:          store.ErrUploadNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "upload not found: test-upload\n",
+		},
+		{
+			desc:   "complete",
+			method: http.MethodPost,
+			path:   "/uploads/test-bucket/test-object/test-upload",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+				putMeta:      store.Metadata{Digest: "test-digest"},
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc:   "complete with parts",
+			method: http.MethodPost,
+			path:   "/uploads/test-bucket/test-object/test-upload",
+			body:   `{"parts":[{"partNumber":1,"digest":"digest-1"},{"partNumber":3}]}`,
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+				wantParts: []store.Part{
+					{Number: 1, Digest: "digest-1"},
+					{Number: 3},
+				},
+				putMeta: store.Metadata{Digest: "test-digest"},
+			},
+			wantStatus: http.StatusCreated,
+			wantBody: `{"id":"test-object","digest":"test-digest"}
+`,
+		},
+		{
+			desc:   "complete insufficient storage",
+			method: http.MethodPost,
+			path:   "/uploads/test-bucket/test-object/test-upload",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+				err:          store.ErrInsufficientStorage,
+			},
+			wantStatus: http.StatusInsufficientStorage,
+			wantBody:   "insufficient storage\n",
+		},
+		{
+			desc:       "complete invalid body",
+			method:     http.MethodPost,
+			path:       "/uploads/test-bucket/test-object/test-upload",
+			body:       `{"parts":`,
+			store:      &fakeStore{t: t},
+			wantStatus: http.StatusBadRequest,
+			wantBody:   "can not parse body: unexpected EOF\n",
+		},
+		{
+			desc:   "abort",
+			method: http.MethodDelete,
+			path:   "/uploads/test-bucket/test-object/test-upload",
+			store: &fakeStore{
+				t:            t,
+				wantBucket:   "test-bucket",
+				wantObjectID: "test-object",
+				uploadID:     "test-upload",
+			},
+			wantStatus: http.StatusNoContent,
+			wantBody:   "",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGC(t *testing.T) {
+	tt := []struct {
+		desc       string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc: "success",
+			store: &fakeStore{
+				removed: 3,
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"removed":3}
+`,
+		},
+		{
+			desc: "backend error",
+			store: &fakeStore{
+				err: errors.New("test-error"),
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "can not collect garbage: test-error\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/gc", nil)
+
+			r.Handler().ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Errorf("got status %v, want %v", rec.Code, tc.wantStatus)
+			}
+
+			body := rec.Body.String()
+			if diff := cmp.Diff(body, tc.wantBody); diff != "" {
+				t.Errorf("body differs: -got+want\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestBucketStats(t *testing.T) {
+	tt := []struct {
+		desc       string
+		store      store.Store
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			desc: "success",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				bucketStats: store.BucketStats{
+					NumObjects:    2,
+					NumContents:   1,
+					BytesSaved:    4,
+					LogicalBytes:  8,
+					PhysicalBytes: 4,
+					DedupRatio:    2,
+					LargestObject: &store.ObjectSize{ObjectID: "test-object", Size: 4},
+				},
+			},
+			wantStatus: http.StatusOK,
+			wantBody: `{"objects":2,"contents":1,"bytesSaved":4,"logicalBytes":8,"physicalBytes":4,"dedupRatio":2,"largestObject":{"id":"test-object","size":4}}
+`,
+		},
+		{
+			desc: "bucket not found",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        store.ErrNotFound,
+			},
+			wantStatus: http.StatusNotFound,
+			wantBody:   "bucket not found: test-bucket\n",
+		},
+		{
+			desc: "error",
+			store: &fakeStore{
+				t:          t,
+				wantBucket: "test-bucket",
+				err:        errors.New("test error"),
+			},
+			wantStatus: http.StatusInternalServerError,
+			wantBody:   "can not get stats: test error\n",
+		},
+	}
+
+	for _, tc := range tt {
+		tc := tc
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+
+			r := NewRouter(log, tc.store)
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/stats/test-bucket", nil)
 
 			r.Handler().ServeHTTP(rec, req)
 
@@ -285,7 +2134,7 @@ func TestSimpleHandlers(t *testing.T) {
 		{
 			desc:     "empty stats",
 			path:     "/stats",
-			wantBody: `{"buckets":{}}
+			wantBody: `{"buckets":{},"objects":0,"logicalBytes":0,"physicalBytes":0,"dedupRatio":0}
 `,
 		},
 	}
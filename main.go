@@ -1,20 +1,45 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"github.com/xperimental/bukky/internal/chunker"
+	"github.com/xperimental/bukky/internal/digest"
+	"github.com/xperimental/bukky/internal/store"
+	"github.com/xperimental/bukky/internal/store/disk"
 	"github.com/xperimental/bukky/internal/store/memory"
 	"github.com/xperimental/bukky/internal/web"
 )
 
 const (
-	envAddr = "LISTEN_ADDR"
+	envAddr     = "LISTEN_ADDR"
+	envS3Addr   = "S3_LISTEN_ADDR"
+	envDataDir  = "DATA_DIR"
+	envWALDir   = "WAL_DIR"
+	envChunking = "CHUNKING"
+	envGlobal   = "GLOBAL_DEDUPLICATION"
+	envExplicit = "EXPLICIT_BUCKETS"
+	envReaper   = "EXPIRATION_INTERVAL"
+	envLimit    = "MEMORY_LIMIT"
+	envBucket   = "BUCKET_MEMORY_LIMIT"
+	envPolicy   = "EVICTION_POLICY"
+	envDigest   = "DIGEST_ALGORITHM"
+	envVerify   = "VERIFY_CONTENTS"
+	envBatch    = "BATCH_MAX_BYTES"
+
+	walSnapshotEvery = 1000
 )
 
 var (
-	addr = ":8080"
+	addr           = ":8080"
+	reaperInterval = time.Minute
 
 	log = &logrus.Logger{
 		Out: os.Stderr,
@@ -27,13 +52,199 @@ var (
 	}
 )
 
+func envBool(name string) (bool, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return false, nil
+	}
+
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("can not parse %s: %w", name, err)
+	}
+
+	return enabled, nil
+}
+
+func envBytes(name string) (uint64, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return 0, nil
+	}
+
+	bytes, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can not parse %s: %w", name, err)
+	}
+
+	return bytes, nil
+}
+
+func createQuota() (*memory.Quota, error) {
+	limit, err := envBytes(envLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketLimit, err := envBytes(envBucket)
+	if err != nil {
+		return nil, err
+	}
+
+	if limit == 0 && bucketLimit == 0 {
+		return nil, nil
+	}
+
+	policy := memory.Reject
+	if value, ok := os.LookupEnv(envPolicy); ok {
+		policy, err = memory.ParsePolicy(value)
+		if err != nil {
+			return nil, fmt.Errorf("can not parse %s: %w", envPolicy, err)
+		}
+	}
+
+	return &memory.Quota{
+		Limit:       limit,
+		BucketLimit: bucketLimit,
+		Policy:      policy,
+	}, nil
+}
+
+func createAlgorithm() (digest.Algorithm, error) {
+	name := digest.DefaultAlgorithm
+	if value, ok := os.LookupEnv(envDigest); ok {
+		name = value
+	}
+
+	algorithm, err := digest.Lookup(name)
+	if err != nil {
+		return digest.Algorithm{}, fmt.Errorf("can not parse %s: %w", envDigest, err)
+	}
+
+	return algorithm, nil
+}
+
+func createStore() (store.Store, error) {
+	algorithm, err := createAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	if algorithm.Name != digest.DefaultAlgorithm {
+		log.Infof("Using digest algorithm %s.", algorithm.Name)
+	}
+
+	if dir, ok := os.LookupEnv(envDataDir); ok {
+		log.Infof("Using data directory %s", dir)
+		return disk.NewStore(log, dir, disk.WithDigest(algorithm))
+	}
+
+	opts := []memory.Option{memory.WithDigest(algorithm)}
+	chunking, err := envBool(envChunking)
+	if err != nil {
+		return nil, err
+	}
+
+	if chunking {
+		log.Info("Splitting contents into chunks.")
+		opts = append(opts, memory.WithChunking(chunker.DefaultConfig))
+	}
+
+	global, err := envBool(envGlobal)
+	if err != nil {
+		return nil, err
+	}
+
+	if global {
+		log.Info("De-duplicating contents across buckets.")
+		opts = append(opts, memory.WithGlobalDeduplication())
+	}
+
+	verify, err := envBool(envVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	if verify {
+		log.Info("Verifying contents with identical digests.")
+		opts = append(opts, memory.WithVerification())
+	}
+
+	quota, err := createQuota()
+	if err != nil {
+		return nil, err
+	}
+
+	if quota != nil {
+		log.Infof("Limiting memory to %d bytes (%d bytes per bucket).", quota.Limit, quota.BucketLimit)
+		opts = append(opts, memory.WithQuota(*quota))
+	}
+
+	if dir, ok := os.LookupEnv(envWALDir); ok {
+		log.Infof("Using write-ahead log in %s", dir)
+		return memory.NewPersistentStore(log, dir, walSnapshotEvery, opts...)
+	}
+
+	return memory.NewStore(log, opts...), nil
+}
+
 func main() {
 	if value, ok := os.LookupEnv(envAddr); ok {
 		addr = value
 	}
 
-	store := memory.NewStore(log)
-	r := web.NewRouter(log, store)
+	backend, err := createStore()
+	if err != nil {
+		log.Fatalf("Error creating store: %s", err)
+	}
+
+	if value, ok := os.LookupEnv(envReaper); ok {
+		reaperInterval, err = time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("Error parsing %s: %s", envReaper, err)
+		}
+	}
+
+	if reaperInterval > 0 {
+		log.Infof("Removing expired objects every %s.", reaperInterval)
+		go store.RunReaper(context.Background(), log, backend, reaperInterval)
+	}
+
+	var opts []web.Option
+	explicit, err := envBool(envExplicit)
+	if err != nil {
+		log.Fatalf("Error parsing configuration: %s", err)
+	}
+
+	if explicit {
+		log.Info("Buckets need to be created explicitly.")
+		opts = append(opts, web.WithExplicitBuckets())
+	}
+
+	batchMaxBytes, err := envBytes(envBatch)
+	if err != nil {
+		log.Fatalf("Error parsing configuration: %s", err)
+	}
+
+	if batchMaxBytes > 0 {
+		if batchMaxBytes > math.MaxInt64 {
+			log.Fatalf("Error parsing configuration: %s is too large", envBatch)
+		}
+
+		opts = append(opts, web.WithBatchMaxBytes(int64(batchMaxBytes)))
+	}
+
+	r := web.NewRouter(log, backend, opts...)
+
+	if s3Addr, ok := os.LookupEnv(envS3Addr); ok {
+		s3 := web.NewS3Router(log, backend, web.WithMetricsOf(r))
+		go func() {
+			log.Infof("Listening for S3 requests on %s ...", s3Addr)
+			if err := http.ListenAndServe(s3Addr, s3.Handler()); err != nil {
+				log.Fatalf("Error starting S3 server: %s", err)
+			}
+		}()
+	}
 
 	log.Infof("Listening on %s ...", addr)
 	if err := http.ListenAndServe(addr, r.Handler()); err != nil {